@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/middleware"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/service"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+// minPasswordLength is the minimum length of the driver passwords
+const minPasswordLength = 8
+
+// checkDriverCanWork returns errDriverCantWork when the driver is not active and errUnknownDriver when it
+// does not exist anymore
+func checkDriverCanWork(ctx context.Context, serviceData *ServiceData, driverID string) error {
+	id, err := strconv.Atoi(driverID)
+	if err != nil {
+		return errUnknownDriver
+	}
+	driver, err := serviceData.PGDB.GetDriver(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errUnknownDriver
+	}
+	if err != nil {
+		return err
+	}
+	if !driver.CanWork() {
+		return fmt.Errorf("%w: driver is %s", errDriverCantWork, driver.Status)
+	}
+	return nil
+}
+
+func setDriverPasswordHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var credentials model.DriverCredentials
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(credentials.Password) < minPasswordLength {
+			http.Error(w, "password must have at least 8 characters", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		// The staff resets the passwords, the drivers prove they know the current one to change theirs
+		if !middleware.CallerHasPermission(r, authentication.PermDriversWrite) {
+			driver, err := serviceData.PGDB.GetDriver(ctx, idInt)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			_, err = serviceData.PGDB.AuthenticateDriver(ctx, driver.Email, credentials.CurrentPassword)
+			if errors.Is(err, service.ErrInvalidCredentials) {
+				http.Error(w, "current password does not match", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = serviceData.PGDB.SetDriverPassword(ctx, idInt, credentials.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// A new password logs the driver out of every device, the access tokens already issued included
+		err = revokeDriverSessions(ctx, serviceData, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func loginDriverHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var credentials model.DriverCredentials
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		driver, err := serviceData.PGDB.AuthenticateDriver(ctx, credentials.Email, credentials.Password)
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !driver.CanWork() {
+			http.Error(w, fmt.Sprintf("%v: driver is %s", errDriverCantWork, driver.Status), http.StatusForbidden)
+			return
+		}
+
+		writeDriverTokens(ctx, w, serviceData, strconv.Itoa(driver.ID), "")
+	}
+}
+
+func refreshDriverTokenHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		driverID, refreshToken, err := serviceData.RefreshTokens.RotateRefreshToken(ctx, req.RefreshToken)
+		if errors.Is(err, authentication.ErrInvalidRefreshToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// A driver suspended after logging in can't keep refreshing, the rotated token is dropped
+		if err := checkDriverCanWork(ctx, serviceData, driverID); err != nil {
+			serviceData.RefreshTokens.RevokeRefreshToken(ctx, refreshToken)
+			switch {
+			case errors.Is(err, errDriverCantWork):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case errors.Is(err, errUnknownDriver):
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeDriverTokens(ctx, w, serviceData, driverID, refreshToken)
+	}
+}
+
+func logoutDriverHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		err := serviceData.RefreshTokens.RevokeRefreshToken(ctx, req.RefreshToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The access token used to log out is revoked as well, an invalid one has nothing to revoke
+		if claims, err := serviceData.Authenticator.ValidateDriverJWT(r.Header.Get("Authorization")); err == nil {
+			err = serviceData.Denylist.RevokeToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeDriverTokensHandler logs a driver out of every device, its open sessions are closed once they notice
+// the revocation
+func revokeDriverTokensHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := strconv.Atoi(id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		err := revokeDriverSessions(ctx, serviceData, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeDriverSessions revokes the access and refresh tokens of the driver
+func revokeDriverSessions(ctx context.Context, serviceData *ServiceData, driverID string) error {
+	err := serviceData.Denylist.RevokeDriverTokens(ctx, driverID, time.Now())
+	if err != nil {
+		return err
+	}
+	return serviceData.RefreshTokens.RevokeDriverRefreshTokens(ctx, driverID)
+}
+
+// writeDriverTokens issues a new access token and replies with it and the refresh token, a new refresh token
+// is issued when none is given
+func writeDriverTokens(ctx context.Context, w http.ResponseWriter, serviceData *ServiceData, driverID, refreshToken string) {
+	token, err := serviceData.Authenticator.GenerateDriverJWT(driverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if refreshToken == "" {
+		refreshToken, err = serviceData.RefreshTokens.IssueRefreshToken(ctx, driverID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(model.TokenResponse{Token: token, RefreshToken: refreshToken})
+}
+
+// jwksHandler serves the public keys validating the driver tokens, HMAC secrets are never published
+func jwksHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(serviceData.Keys.JWKS())
+	}
+}
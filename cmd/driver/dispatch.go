@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/eta"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// rideOfferRadius is the radius in kilometers of the drivers a ride is offered to
+	rideOfferRadius = 5.0
+	// rideOfferDrivers is the maximum number of drivers a ride is offered to at once
+	rideOfferDrivers = 5
+	// rideOfferTTL is how long an offer can be accepted
+	rideOfferTTL = 30 * time.Second
+	// queueOfferTTL is how long a driver of a staging zone has to accept before the next one gets the offer
+	queueOfferTTL = 15 * time.Second
+	// queueOfferDrivers is the maximum number of queued drivers a ride is offered to before it is offered to
+	// the nearby drivers
+	queueOfferDrivers = 10
+	// queueOfferPoll is how often the offer of a queued driver is checked, a declined or taken offer moves on
+	// to the next driver without waiting for queueOfferTTL
+	queueOfferPoll = 500 * time.Millisecond
+)
+
+// consumeRideEvents reads the ride events produced for the drivers. Pooled rides waiting for a driver join a
+// pool when one can take them, the other rides waiting for a driver are offered to the nearby drivers of the class requested, or to the queues of the staging zones of their pickup zone, and
+// the changes of a ride with a driver are pushed to the driver.
+func consumeRideEvents(ctx context.Context, serviceStatus *ServiceData) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-serviceStatus.Consumer.Messages():
+			if !ok {
+				return
+			}
+			var outbox model.RideOutbox
+			if err := json.Unmarshal(msg.Value, &outbox); err != nil {
+				log.Println("unmarshal ride event:", err)
+				continue
+			}
+			if outbox.Ride == nil {
+				continue
+			}
+
+			if outbox.Ride.DriverID == nil {
+				// The pooled rides join a pool on the way before being offered
+				poolCtx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+				pooled := joinPool(poolCtx, serviceStatus, outbox.Ride)
+				cancel()
+				if pooled {
+					continue
+				}
+			}
+
+			pickup := model.Coordinate{Lat: outbox.Ride.SrcLat, Lon: outbox.Ride.SrcLon}
+			if staging := serviceStatus.Zones.StagingFor(pickup); outbox.Ride.DriverID == nil && len(staging) > 0 {
+				// The queues are offered one driver at a time, it outlives the handling of the event
+				go offerRideInQueueOrder(ctx, serviceStatus, outbox.Ride, staging)
+				continue
+			}
+
+			sendCtx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+			if outbox.Ride.DriverID == nil {
+				offerRide(sendCtx, serviceStatus, outbox.Ride)
+			} else {
+				trackActiveRide(sendCtx, serviceStatus, &outbox)
+				pushRideStatus(sendCtx, serviceStatus, &outbox)
+			}
+			cancel()
+		}
+	}
+}
+
+// offerRide offers a ride waiting for a driver to the nearby drivers, the first one accepting gets it. The
+// drivers closest in time whose vehicle fits the ride get the offer, with their time to the pickup and the time
+// of the ride.
+func offerRide(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride) {
+	if ride.Status != model.RideStatusPassengerAccepted {
+		return
+	}
+	now := time.Now()
+	pickup := model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}
+	drivers, err := eta.NearbyDrivers(ctx, serviceStatus.GeoService, serviceStatus.ETA, pickup, rideOfferRadius, string(ride.VehicleClass), now)
+	if err != nil {
+		log.Println("NearbyDrivers:", err)
+		return
+	}
+	tripSeconds := estimateTripSeconds(serviceStatus, ride, now)
+
+	offered := 0
+	for _, driver := range drivers {
+		if offered == rideOfferDrivers {
+			break
+		}
+		if !vehicleFits(ctx, serviceStatus, driver.DriverID, ride) {
+			continue
+		}
+		sendRideOffer(ctx, serviceStatus, ride, driver.DriverID, now.Add(rideOfferTTL), driver.ETASeconds, tripSeconds)
+		offered++
+	}
+}
+
+// offerRideInQueueOrder offers a ride starting in a pickup zone to the drivers waiting in its staging zones,
+// one at a time in the order they entered. Every driver has queueOfferTTL to accept before the next one gets
+// the offer, a decline passes it on right away. The drivers declining it or letting it expire keep their spot.
+// When no queued driver takes the ride it is offered to the nearby drivers.
+func offerRideInQueueOrder(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride, staging []model.Zone) {
+	if ride.Status != model.RideStatusPassengerAccepted {
+		return
+	}
+	tripSeconds := estimateTripSeconds(serviceStatus, ride, time.Now())
+	seen := make(map[string]bool)
+	for offered := 0; offered < queueOfferDrivers; offered++ {
+		driverID, ok := nextQueuedDriver(ctx, serviceStatus, ride, staging, seen)
+		if !ok {
+			break
+		}
+		seen[driverID] = true
+
+		now := time.Now()
+		pickupSeconds := estimatePickupSeconds(ctx, serviceStatus, ride, driverID, now)
+		expiresAt := now.Add(queueOfferTTL)
+		if !sendRideOffer(ctx, serviceStatus, ride, driverID, expiresAt, pickupSeconds, tripSeconds) {
+			continue
+		}
+		if !waitOfferAnswer(ctx, serviceStatus, ride.ID, driverID, expiresAt) {
+			return
+		}
+
+		getCtx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+		current, err := serviceStatus.Rides.GetRide(getCtx, ride.ID)
+		cancel()
+		if err != nil {
+			log.Printf("offer ride %d in queue order: GetRide: %v\n", ride.ID, err)
+			return
+		}
+		if current.DriverID != nil || current.Status != model.RideStatusPassengerAccepted {
+			return
+		}
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+	defer cancel()
+	offerRide(sendCtx, serviceStatus, ride)
+}
+
+// waitOfferAnswer waits until the offer of the ride to the driver is gone, because the driver declined or took
+// it, or until it expires. It returns false when the context is done.
+func waitOfferAnswer(ctx context.Context, serviceStatus *ServiceData, rideID int, driverID string, expiresAt time.Time) bool {
+	ticker := time.NewTicker(queueOfferPoll)
+	defer ticker.Stop()
+	for time.Now().Before(expiresAt) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+		offered, err := serviceStatus.Offers.HasOffer(checkCtx, rideID, driverID)
+		cancel()
+		if err != nil {
+			log.Printf("offer of ride %d to driver %s: %v\n", rideID, driverID, err)
+			continue
+		}
+		if !offered {
+			return true
+		}
+	}
+	return true
+}
+
+// nextQueuedDriver returns the first driver of the staging zones whose vehicle fits the ride and that was not
+// seen yet, the queues are read every time since the drivers come and go. The drivers that don't fit are
+// added to seen.
+func nextQueuedDriver(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride, staging []model.Zone, seen map[string]bool) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+	defer cancel()
+	for _, zone := range staging {
+		drivers, err := serviceStatus.Staging.Drivers(ctx, zone.ID, string(ride.VehicleClass))
+		if err != nil {
+			log.Printf("queue of staging zone %d: %v\n", zone.ID, err)
+			continue
+		}
+		for _, driverID := range drivers {
+			if seen[driverID] {
+				continue
+			}
+			if vehicleFits(ctx, serviceStatus, driverID, ride) {
+				return driverID, true
+			}
+			seen[driverID] = true
+		}
+	}
+	return "", false
+}
+
+// vehicleFits returns true when the vehicle of the driver has the class and the seats of the ride, the drivers
+// without vehicle fit no ride
+func vehicleFits(ctx context.Context, serviceStatus *ServiceData, driverID string, ride *model.Ride) bool {
+	id, err := strconv.Atoi(driverID)
+	if err != nil {
+		return false
+	}
+	vehicle, err := serviceStatus.Vehicles.GetDriverVehicle(ctx, id)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("vehicle of driver %s: %v\n", driverID, err)
+		}
+		return false
+	}
+	return vehicle.Fits(ride.VehicleClass, ride.Seats)
+}
+
+// sendRideOffer offers the ride to a driver, it returns false when the offer did not reach the driver. The
+// offer is recorded first, the driver can only accept the ride until it expires.
+func sendRideOffer(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride, driverID string, expiresAt time.Time, pickupSeconds, tripSeconds int) bool {
+	if err := serviceStatus.Offers.RecordOffer(ctx, ride.ID, driverID, expiresAt); err != nil {
+		log.Printf("record offer of ride %d to driver %s: %v\n", ride.ID, driverID, err)
+		return false
+	}
+	offer := &model.DriverRideOffer{
+		BaseMessage:      model.BaseMessage{Type: model.DriverRideOfferMsgType, ID: "offer-" + strconv.Itoa(ride.ID)},
+		Ride:             ride,
+		ExpiresAt:        expiresAt,
+		PickupETASeconds: pickupSeconds,
+		TripETASeconds:   tripSeconds,
+	}
+	err := serviceStatus.Hub.SendToDriver(ctx, driverID, offer)
+	if err != nil && !errors.Is(err, ErrDriverNotConnected) {
+		log.Printf("offer ride %d to driver %s: %v\n", ride.ID, driverID, err)
+	}
+	return err == nil
+}
+
+// estimateTripSeconds returns the time of the ride, 0 when it can not be estimated
+func estimateTripSeconds(serviceStatus *ServiceData, ride *model.Ride, now time.Time) int {
+	trip, err := eta.EstimateRoute(serviceStatus.ETA, ride.Route(), now)
+	if err != nil {
+		log.Printf("estimate ride %d: %v\n", ride.ID, err)
+		return 0
+	}
+	return trip.Seconds()
+}
+
+// estimatePickupSeconds returns the time for the driver to reach the pickup of the ride, 0 when it can not be
+// estimated
+func estimatePickupSeconds(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride, driverID string, now time.Time) int {
+	ctx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+	defer cancel()
+	lat, lon, err := serviceStatus.GeoService.GetDriverLocation(ctx, driverID)
+	if err != nil {
+		return 0
+	}
+	pickup, err := serviceStatus.ETA.Estimate(model.Coordinate{Lat: lat, Lon: lon}, model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}, now)
+	if err != nil {
+		return 0
+	}
+	return pickup.Seconds()
+}
+
+// pushRideStatus notifies the driver of a ride its new status, a disconnected driver gets the ride when it
+// connects again
+func pushRideStatus(ctx context.Context, serviceStatus *ServiceData, outbox *model.RideOutbox) {
+	driverID := strconv.Itoa(*outbox.Ride.DriverID)
+	err := serviceStatus.Hub.SendToDriver(ctx, driverID, &model.DriverRideStatusResponse{
+		BaseMessage: model.BaseMessage{Type: model.DriverRideStatusMsgType},
+		RideID:      outbox.RideID,
+		Status:      outbox.Status,
+		Ride:        outbox.Ride,
+		StopSeq:     outbox.StopSeq,
+	})
+	if err != nil && !errors.Is(err, ErrDriverNotConnected) {
+		log.Printf("push ride %d status to driver %s: %v\n", outbox.RideID, driverID, err)
+	}
+}
+
+// trackActiveRide attaches the trail of the driver to the ride and keeps the driver out of the staging queues
+// until the ride ends
+func trackActiveRide(ctx context.Context, serviceStatus *ServiceData, outbox *model.RideOutbox) {
+	driverID := strconv.Itoa(*outbox.Ride.DriverID)
+	var err error
+	switch outbox.Status {
+	case model.RideStatusCompleted, model.RideStatusPassengerCancelled, model.RideStatusDriverCancelled,
+		model.RideStatusErrored, model.RideStatusDeleted:
+		err = errors.Join(
+			serviceStatus.Trails.ClearActiveRide(ctx, driverID, outbox.RideID),
+			serviceStatus.Staging.SetBusy(ctx, driverID, false),
+		)
+	case model.RideStatusDriverAccepted, model.RideStatusPickingUp, model.RideStatusInTransit:
+		err = errors.Join(
+			serviceStatus.Trails.SetActiveRide(ctx, driverID, outbox.RideID),
+			serviceStatus.Staging.SetBusy(ctx, driverID, true),
+		)
+	}
+	if err != nil {
+		log.Printf("track ride %d of driver %s: %v\n", outbox.RideID, driverID, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// errUnknownDriver is returned when the driver of a token is not in the drivers database
+	errUnknownDriver = errors.New("unknown driver")
+	// errDriverCantWork is returned when the driver is suspended or not verified
+	errDriverCantWork = errors.New("driver is not allowed to work")
+)
+
+// driverProfile is the data of the driver cached for the lifetime of a session, it is kept up to date with
+// the driver change events
+type driverProfile struct {
+	driverID string
+
+	mu           sync.RWMutex
+	status       string
+	region       string
+	vehicleClass model.VehicleClass
+}
+
+// loadDriverProfile reads the driver and its vehicle, drivers that are not allowed to work are refused
+func loadDriverProfile(ctx context.Context, serviceData *ServiceData, driverID string) (*driverProfile, error) {
+	id, err := strconv.Atoi(driverID)
+	if err != nil {
+		return nil, errUnknownDriver
+	}
+	driver, err := serviceData.PGDB.GetDriver(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errUnknownDriver
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !driver.CanWork() {
+		return nil, fmt.Errorf("%w: driver is %s", errDriverCantWork, driver.Status)
+	}
+
+	profile := &driverProfile{
+		driverID: driverID,
+		status:   driver.Status,
+		region:   driver.Region,
+	}
+	vehicle, err := serviceData.Vehicles.GetDriverVehicle(ctx, id)
+	switch {
+	case err == nil:
+		profile.vehicleClass = vehicle.Class
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, err
+	}
+	return profile, nil
+}
+
+// works tells whether the driver works in the region, the drivers and the zones without region match any
+func (p *driverProfile) works(region string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.region == "" || region == "" || strings.EqualFold(p.region, region)
+}
+
+func (p *driverProfile) VehicleClass() model.VehicleClass {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.vehicleClass
+}
+
+// reload replaces the data of the profile with the data read again
+func (p *driverProfile) reload(read *driverProfile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status, p.region, p.vehicleClass = read.status, read.region, read.vehicleClass
+}
+
+// apply updates the profile with the change, it returns false when the driver can't work anymore
+func (p *driverProfile) apply(event model.DriverChangeEvent) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch event.Kind {
+	case model.DriverChangeUpdated:
+		p.status = event.Status
+		p.region = event.Region
+	case model.DriverChangeDeleted:
+		p.status = ""
+	case model.DriverChangeVehicle:
+		p.vehicleClass = event.VehicleClass
+	}
+	return p.status == model.DriverStatusActive
+}
+
+// consumeDriverChanges applies the driver changes to the open sessions, the sessions of the drivers that can't
+// work anymore are closed
+func consumeDriverChanges(ctx context.Context, serviceData *ServiceData) error {
+	events, err := serviceData.PGDB.WatchDriverChanges(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for event := range events {
+			if event.Kind == model.DriverChangeResync {
+				reloadDriverProfiles(ctx, serviceData)
+				continue
+			}
+			driverID := strconv.Itoa(event.DriverID)
+			// The class used by the search follows the vehicle whether the driver is connected or not, a
+			// removed vehicle clears it
+			if event.Kind == model.DriverChangeVehicle {
+				err := serviceData.GeoService.SetDriverVehicleClass(ctx, driverID, string(event.VehicleClass))
+				if err != nil {
+					log.Println("SetDriverVehicleClass:", err)
+				}
+			}
+			session := serviceData.Hub.session(driverID)
+			if session == nil {
+				continue
+			}
+			if !session.profile.apply(event) {
+				session.end(fmt.Sprintf("driver is %s", statusOrDeleted(event)))
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadDriverProfiles reads again the drivers with a session, the changes missed while the watcher was not
+// listening are applied
+func reloadDriverProfiles(ctx context.Context, serviceData *ServiceData) {
+	for _, session := range serviceData.Hub.connected() {
+		profile, err := loadDriverProfile(ctx, serviceData, session.driverID())
+		switch {
+		case errors.Is(err, errUnknownDriver):
+			session.end("driver is deleted")
+		case errors.Is(err, errDriverCantWork):
+			session.end(err.Error())
+		case err != nil:
+			log.Printf("reload driver %s: %v\n", session.driverID(), err)
+		default:
+			session.profile.reload(profile)
+			err := serviceData.GeoService.SetDriverVehicleClass(ctx, session.driverID(), string(profile.vehicleClass))
+			if err != nil {
+				log.Println("SetDriverVehicleClass:", err)
+			}
+		}
+	}
+}
+
+func statusOrDeleted(event model.DriverChangeEvent) string {
+	if event.Kind == model.DriverChangeDeleted {
+		return "deleted"
+	}
+	return event.Status
+}
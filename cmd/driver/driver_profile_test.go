@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldMatchTheRegionOfTheDriver(t *testing.T) {
+	tests := []struct {
+		name          string
+		driverRegion  string
+		zoneRegion    string
+		expectedWorks bool
+	}{
+		{name: "same region", driverRegion: "Madrid", zoneRegion: "madrid", expectedWorks: true},
+		{name: "another region", driverRegion: "Madrid", zoneRegion: "Barcelona", expectedWorks: false},
+		{name: "driver without region", driverRegion: "", zoneRegion: "Barcelona", expectedWorks: true},
+		{name: "zone without region", driverRegion: "Madrid", zoneRegion: "", expectedWorks: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := &driverProfile{driverID: "1", region: tt.driverRegion}
+			assert.Equal(t, tt.expectedWorks, profile.works(tt.zoneRegion))
+		})
+	}
+}
+
+func TestShouldKeepTheProfileUpToDate(t *testing.T) {
+	profile := &driverProfile{driverID: "1", status: model.DriverStatusActive, region: "Madrid"}
+
+	assert.True(t, profile.apply(model.DriverChangeEvent{Kind: model.DriverChangeUpdated, DriverID: 1, Status: model.DriverStatusActive, Region: "Barcelona"}))
+	assert.False(t, profile.works("Madrid"))
+	assert.True(t, profile.apply(model.DriverChangeEvent{Kind: model.DriverChangeVehicle, DriverID: 1, VehicleClass: "xl"}))
+	assert.Equal(t, model.VehicleClass("xl"), profile.VehicleClass())
+
+	// The profile read again after missing changes replaces the cached one
+	profile.reload(&driverProfile{driverID: "1", status: model.DriverStatusActive, region: "Madrid"})
+	assert.True(t, profile.works("Madrid"))
+	assert.Equal(t, model.VehicleClass(""), profile.VehicleClass())
+
+	assert.False(t, profile.apply(model.DriverChangeEvent{Kind: model.DriverChangeUpdated, DriverID: 1, Status: model.DriverStatusSuspended}))
+	assert.False(t, profile.apply(model.DriverChangeEvent{Kind: model.DriverChangeDeleted, DriverID: 1}))
+}
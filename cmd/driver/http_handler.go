@@ -3,11 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/middleware"
 	"github.com/OscarMoya/Glubber/pkg/model"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 )
 
 func createDriverHandler(serviceData *ServiceData) http.HandlerFunc {
@@ -69,18 +73,47 @@ func getDriverHandler(serviceData *ServiceData) http.HandlerFunc {
 
 func updateDriverHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		var driver model.Driver
 		if err := json.NewDecoder(r.Body).Decode(&driver); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// The driver updated is always the one of the route
+		driver.ID = idInt
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
-		err := serviceData.PGDB.UpdateDriver(ctx, &driver)
+
+		// Drivers updating themselves can't change their status nor the region of the staging zones they
+		// queue in, only the staff does
+		if !middleware.CallerHasPermission(r, authentication.PermDriversWrite) {
+			stored, err := serviceData.PGDB.GetDriver(ctx, idInt)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			driver.Status = stored.Status
+			driver.Region = stored.Region
+		}
+
+		err = serviceData.PGDB.UpdateDriver(ctx, &driver)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// Deactivated drivers are logged out everywhere
+		if driver.Status != model.DriverStatusActive {
+			err = revokeDriverSessions(ctx, serviceData, id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(driver)
 
@@ -102,6 +135,114 @@ func deleteDriverHandler(serviceStatus *ServiceData) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		err = revokeDriverSessions(ctx, serviceStatus, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+func saveDriverVehicleHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var vehicle model.Vehicle
+		if err := json.NewDecoder(r.Body).Decode(&vehicle); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		vehicle.DriverID = idInt
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		// The class sets the tariff of the rides and the seats the rides and pools the vehicle is offered,
+		// the drivers keep the ones given by the staff
+		if !middleware.CallerHasPermission(r, authentication.PermDriversWrite) {
+			class, seats := model.VehicleClassEconomy, model.DefaultVehicleSeats
+			current, err := serviceData.Vehicles.GetDriverVehicle(ctx, idInt)
+			switch {
+			case err == nil:
+				class, seats = current.Class, current.Seats
+			case !errors.Is(err, pgx.ErrNoRows):
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if vehicle.Class != class {
+				http.Error(w, "the vehicle class can only be changed by the staff", http.StatusForbidden)
+				return
+			}
+			if vehicle.Seats != seats {
+				http.Error(w, "the vehicle seats can only be changed by the staff", http.StatusForbidden)
+				return
+			}
+		}
+
+		err = serviceData.Vehicles.SaveDriverVehicle(ctx, &vehicle)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Keep the class used by the nearby drivers search in sync with the vehicle
+		err = serviceData.GeoService.SetDriverVehicleClass(ctx, id, string(vehicle.Class))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(vehicle)
+	}
+}
+
+func deleteDriverVehicleHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		err = serviceData.Vehicles.DeleteDriverVehicle(ctx, idInt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// A driver without vehicle is not offered the rides of any class
+		err = serviceData.GeoService.SetDriverVehicleClass(ctx, id, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getDriverVehicleHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		vehicle, err := serviceData.Vehicles.GetDriverVehicle(ctx, idInt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(vehicle)
+	}
+}
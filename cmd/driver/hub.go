@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// hubChannelPrefix prefixes the pub/sub channel of every instance of the driver service
+	hubChannelPrefix = "driver_hub:"
+	// hubSessionKeyPrefix prefixes the keys holding the instance and the session of every connected driver
+	hubSessionKeyPrefix = "driver_hub_session:"
+)
+
+var (
+	// ErrDriverNotConnected is returned when sending a message to a driver without session in any instance
+	ErrDriverNotConnected = errors.New("driver not connected")
+	// ErrDriverTooSlow is returned when the messages queued for a driver fill its buffer, its session is ended
+	ErrDriverTooSlow = errors.New("driver too slow, session ended")
+)
+
+// releaseSessionScript removes the session key only if it still belongs to the session, a newer session of
+// the driver in another instance keeps its key
+var releaseSessionScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type hubEnvelopeKind string
+
+const (
+	// hubPush delivers a message to the session of the driver
+	hubPush hubEnvelopeKind = "push"
+	// hubEvict closes a session replaced by a newer login in another instance
+	hubEvict hubEnvelopeKind = "evict"
+)
+
+// hubEnvelope is the message sent between the instances of the driver service
+type hubEnvelope struct {
+	Kind      hubEnvelopeKind `json:"kind"`
+	DriverID  string          `json:"driver_id"`
+	SessionID string          `json:"session_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// driverHub keeps the session of every driver connected to this instance, a driver has a single session and
+// a new login evicts the older one. The instance holding every session is kept in Redis so any instance can
+// push messages to any driver.
+type driverHub struct {
+	instanceID  string
+	redisClient *redis.Client
+
+	mu       sync.RWMutex
+	sessions map[string]*driverSession
+}
+
+func newDriverHub(redisAddr, instanceID string) *driverHub {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &driverHub{
+		instanceID:  instanceID,
+		redisClient: rdb,
+		sessions:    make(map[string]*driverSession),
+	}
+}
+
+// sessionValue identifies a session across the instances
+func (h *driverHub) sessionValue(s *driverSession) string {
+	return h.instanceID + "|" + s.id
+}
+
+// register makes the session the one of its driver, the previous session is evicted wherever it is
+func (h *driverHub) register(ctx context.Context, s *driverSession) error {
+	h.mu.Lock()
+	older := h.sessions[s.driverID()]
+	h.sessions[s.driverID()] = s
+	h.mu.Unlock()
+	if older != nil {
+		older.end("logged in from another device")
+	}
+
+	previous, err := h.redisClient.SetArgs(ctx, hubSessionKeyPrefix+s.driverID(), h.sessionValue(s), redis.SetArgs{Get: true}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	instanceID, sessionID, ok := parseSessionValue(previous)
+	if !ok || instanceID == h.instanceID {
+		return nil
+	}
+	return h.publish(ctx, instanceID, hubEnvelope{Kind: hubEvict, DriverID: s.driverID(), SessionID: sessionID})
+}
+
+// unregister removes the session, unless it was already replaced by a newer one
+func (h *driverHub) unregister(ctx context.Context, s *driverSession) {
+	h.mu.Lock()
+	if h.sessions[s.driverID()] == s {
+		delete(h.sessions, s.driverID())
+	}
+	h.mu.Unlock()
+
+	err := releaseSessionScript.Run(ctx, h.redisClient, []string{hubSessionKeyPrefix + s.driverID()}, h.sessionValue(s)).Err()
+	if err != nil {
+		log.Printf("driver %s: release hub session: %v\n", s.driverID(), err)
+	}
+}
+
+// owns returns true when the session is still the current session of its driver in any instance
+func (h *driverHub) owns(ctx context.Context, s *driverSession) bool {
+	value, err := h.redisClient.Get(ctx, hubSessionKeyPrefix+s.driverID()).Result()
+	if err != nil {
+		// Without Redis the local registry decides
+		return h.session(s.driverID()) == s
+	}
+	return value == h.sessionValue(s)
+}
+
+// session returns the session of the driver in this instance, nil if the driver is not connected here
+func (h *driverHub) session(driverID string) *driverSession {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.sessions[driverID]
+}
+
+// connected returns the sessions of this instance
+func (h *driverHub) connected() []*driverSession {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sessions := make([]*driverSession, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// SendToDriver queues the message for the driver, in this instance or in the one holding its session
+func (h *driverHub) SendToDriver(ctx context.Context, driverID string, msg interface{}) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if s := h.session(driverID); s != nil {
+		return h.deliver(s, payload)
+	}
+
+	value, err := h.redisClient.Get(ctx, hubSessionKeyPrefix+driverID).Result()
+	if err == redis.Nil {
+		return ErrDriverNotConnected
+	}
+	if err != nil {
+		return err
+	}
+	instanceID, _, ok := parseSessionValue(value)
+	if !ok {
+		return fmt.Errorf("invalid hub session of driver %s: %q", driverID, value)
+	}
+	if instanceID == h.instanceID {
+		// The session of this instance is gone, the key was left behind
+		return ErrDriverNotConnected
+	}
+
+	receivers, err := h.redisClient.Publish(ctx, hubChannelPrefix+instanceID, mustMarshal(hubEnvelope{
+		Kind:     hubPush,
+		DriverID: driverID,
+		Payload:  payload,
+	})).Result()
+	if err != nil {
+		return err
+	}
+	if receivers == 0 {
+		// The instance died without releasing its sessions
+		releaseSessionScript.Run(ctx, h.redisClient, []string{hubSessionKeyPrefix + driverID}, value)
+		return ErrDriverNotConnected
+	}
+	return nil
+}
+
+// deliver queues the payload in the session without waiting, so a stuck driver does not hold the dispatch nor
+// the messages of the other instances. A session whose buffer is full is ended, the driver gets its rides
+// again when it reconnects.
+func (h *driverHub) deliver(s *driverSession, payload []byte) error {
+	if s.ctx.Err() != nil {
+		return ErrDriverNotConnected
+	}
+	outMsg := &model.DriverOutputMessage{}
+	outMsg.Payload = payload
+	select {
+	case s.out <- outMsg:
+		return nil
+	default:
+		s.end("too many messages pending")
+		return ErrDriverTooSlow
+	}
+}
+
+func (h *driverHub) publish(ctx context.Context, instanceID string, envelope hubEnvelope) error {
+	return h.redisClient.Publish(ctx, hubChannelPrefix+instanceID, mustMarshal(envelope)).Err()
+}
+
+// run receives the messages of the other instances for the sessions of this instance until the context is done
+func (h *driverHub) run(ctx context.Context) error {
+	pubsub := h.redisClient.Subscribe(ctx, hubChannelPrefix+h.instanceID)
+	// Wait for the subscription so no message is lost once run returns
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go func() {
+		defer pubsub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-pubsub.Channel():
+				if !ok {
+					return
+				}
+				var envelope hubEnvelope
+				if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+					log.Println("unmarshal hub message:", err)
+					continue
+				}
+				h.handleEnvelope(envelope)
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *driverHub) handleEnvelope(envelope hubEnvelope) {
+	s := h.session(envelope.DriverID)
+	if s == nil {
+		return
+	}
+	switch envelope.Kind {
+	case hubPush:
+		if err := h.deliver(s, envelope.Payload); err != nil {
+			log.Printf("driver %s: deliver hub message: %v\n", envelope.DriverID, err)
+		}
+	case hubEvict:
+		// Only the session replaced is closed, the driver may have logged in here again meanwhile
+		if s.id == envelope.SessionID {
+			s.end("logged in from another device")
+		}
+	}
+}
+
+// parseSessionValue splits the value stored by sessionValue
+func parseSessionValue(value string) (string, string, bool) {
+	return strings.Cut(value, "|")
+}
+
+func mustMarshal(v interface{}) []byte {
+	payload, _ := json.Marshal(v)
+	return payload
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHub creates a hub holding the sessions, its Redis is never reached by the local deliveries
+func newTestHub(sessions ...*driverSession) *driverHub {
+	hub := newDriverHub("localhost:0", "test")
+	for _, s := range sessions {
+		hub.sessions[s.driverID()] = s
+	}
+	return hub
+}
+
+func TestShouldEndSessionsThatCantKeepUp(t *testing.T) {
+	session := newTestDriverSession("1")
+	hub := newTestHub(session)
+
+	for i := 0; i < driverSendBuffer; i++ {
+		require.NoError(t, hub.deliver(session, []byte(`{}`)))
+	}
+
+	// The full buffer does not block the sender, the session is ended instead
+	done := make(chan error, 1)
+	go func() { done <- hub.deliver(session, []byte(`{}`)) }()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrDriverTooSlow)
+	case <-time.After(time.Second):
+		t.Fatal("deliver blocked on a full session")
+	}
+	select {
+	case reason := <-session.goodbye:
+		assert.Equal(t, "too many messages pending", reason)
+	default:
+		t.Fatal("the session was not ended")
+	}
+
+	// A closed session gets nothing
+	session.cancel()
+	assert.ErrorIs(t, hub.deliver(session, []byte(`{}`)), ErrDriverNotConnected)
+}
+
+func TestShouldHandleHubEnvelopes(t *testing.T) {
+	session := newTestDriverSession("1")
+	hub := newTestHub(session)
+
+	payload, err := json.Marshal(map[string]string{"type": "ping"})
+	require.NoError(t, err)
+	hub.handleEnvelope(hubEnvelope{Kind: hubPush, DriverID: "1", Payload: payload})
+	select {
+	case msg := <-session.out:
+		assert.JSONEq(t, string(payload), string(msg.Payload))
+	default:
+		t.Fatal("the push was not delivered")
+	}
+
+	// Drivers without session here and evictions of older sessions are ignored
+	hub.handleEnvelope(hubEnvelope{Kind: hubPush, DriverID: "2", Payload: payload})
+	hub.handleEnvelope(hubEnvelope{Kind: hubEvict, DriverID: "1", SessionID: "older"})
+	assert.Empty(t, session.out)
+	assert.Empty(t, session.goodbye)
+
+	hub.handleEnvelope(hubEnvelope{Kind: hubEvict, DriverID: "1", SessionID: session.id})
+	select {
+	case reason := <-session.goodbye:
+		assert.Equal(t, "logged in from another device", reason)
+	default:
+		t.Fatal("the session was not evicted")
+	}
+}
+
+func TestShouldEndSessionsOnce(t *testing.T) {
+	session := newTestDriverSession("1")
+	session.end("first")
+	// The later reasons are dropped without blocking
+	session.end("second")
+	assert.Equal(t, "first", <-session.goodbye)
+	assert.Empty(t, session.goodbye)
+}
+
+func TestShouldSendToLocalSessions(t *testing.T) {
+	session := newTestDriverSession("1")
+	hub := newTestHub(session)
+
+	err := hub.SendToDriver(session.ctx, "1", &model.DriverAckResponse{BaseMessage: model.BaseMessage{Type: model.DriverAckMsgType}})
+	require.NoError(t, err)
+	msg := <-session.out
+	var ack model.DriverAckResponse
+	require.NoError(t, json.Unmarshal(msg.Payload, &ack))
+	assert.Equal(t, model.DriverAckMsgType, ack.Type)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/OscarMoya/Glubber/pkg/location"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/zones"
+)
+
+const (
+	// locationWriters is the number of location writes in flight for the whole instance
+	locationWriters = 32
+	// locationWriterQueue is the number of sessions waiting for a writer, about the sessions of an instance
+	locationWriterQueue = 4096
+	// maxPendingFixes bounds the fixes of a session waiting for a writer, the oldest are dropped from the trail
+	maxPendingFixes = 256
+)
+
+// locationWriter writes the locations of the drivers with a fixed number of workers. A session keeps its fixes
+// until a writer takes them and is queued once however many fixes arrive meanwhile, so a chatty driver costs a
+// single round per write: only the latest fix becomes the location of the driver and all of them are appended
+// to its trail. The load on the location service is bounded by the workers. The latest fix also moves the
+// driver in and out of the queues of the staging zones.
+type locationWriter struct {
+	geo     location.LocationManager
+	trails  location.TrailRecorder
+	zones   *zones.Registry
+	staging location.StagingQueue
+	workers int
+	queue   chan *driverSession
+}
+
+func newLocationWriter(geo location.LocationManager, trails location.TrailRecorder, zones *zones.Registry, staging location.StagingQueue, workers, queueSize int) *locationWriter {
+	return &locationWriter{
+		geo:     geo,
+		trails:  trails,
+		zones:   zones,
+		staging: staging,
+		workers: workers,
+		queue:   make(chan *driverSession, queueSize),
+	}
+}
+
+// run starts the workers, they stop when the context is done
+func (w *locationWriter) run(ctx context.Context) {
+	for i := 0; i < w.workers; i++ {
+		go w.work(ctx)
+	}
+}
+
+// submit adds the fixes to the pending fixes of the session, the fixes are validated so they come in time
+// order. It returns false when the writers are saturated, the fixes are kept and written with the next ones.
+func (w *locationWriter) submit(s *driverSession, fixes ...model.DriverLocationFix) bool {
+	s.fixMu.Lock()
+	defer s.fixMu.Unlock()
+
+	s.pendingFixes = append(s.pendingFixes, fixes...)
+	if extra := len(s.pendingFixes) - maxPendingFixes; extra > 0 {
+		s.pendingFixes = s.pendingFixes[extra:]
+	}
+	if s.fixQueued {
+		return true
+	}
+	select {
+	case w.queue <- s:
+		s.fixQueued = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *locationWriter) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-w.queue:
+			s.fixMu.Lock()
+			fixes := s.pendingFixes
+			s.pendingFixes = nil
+			s.fixMu.Unlock()
+
+			if len(fixes) > 0 {
+				w.write(ctx, s, fixes)
+			}
+			w.release(s)
+		}
+	}
+}
+
+// release hands the session back once its fixes are written. The session stays queued while a writer has it,
+// so no other writer takes it meanwhile, and the fixes that arrived during the write queue it again.
+func (w *locationWriter) release(s *driverSession) {
+	s.fixMu.Lock()
+	defer s.fixMu.Unlock()
+	if len(s.pendingFixes) > 0 {
+		select {
+		case w.queue <- s:
+			return
+		default:
+			// The writers are saturated, the fixes are written with the next ones submitted
+		}
+	}
+	s.fixQueued = false
+}
+
+// wait blocks until the write of the session in flight, if any, is done. It is called once the session is
+// closed and before the location of the driver is removed, the writes after it see the session closed.
+func (w *locationWriter) wait(s *driverSession) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+}
+
+func (w *locationWriter) write(ctx context.Context, s *driverSession, fixes []model.DriverLocationFix) {
+	ctx, cancel := context.WithTimeout(ctx, driverBackendTimeout)
+	defer cancel()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := w.trails.AppendFixes(ctx, s.driverID(), fixes); err != nil {
+		log.Printf("driver %s: AppendFixes: %v\n", s.driverID(), err)
+	}
+	// A closed session already removed the location of the driver, or does it once this write is done
+	if s.ctx.Err() != nil {
+		return
+	}
+	latest := fixes[len(fixes)-1]
+	if err := w.geo.SaveDriverLocation(ctx, s.driverID(), latest.Latitude, latest.Longitude); err != nil {
+		log.Printf("driver %s: SaveDriverLocation: %v\n", s.driverID(), err)
+	}
+	w.stage(ctx, s, model.Coordinate{Lat: latest.Latitude, Lon: latest.Longitude})
+}
+
+// stage keeps the driver in the queue of the staging zone it is in, a driver leaving the zone loses its spot
+// and a driver with a ride or from another region is not queued. The driver is told its position when it
+// changes, without waiting for a driver that does not read so the writer is not held. A position that could
+// not be told is told again with the next location.
+func (w *locationWriter) stage(ctx context.Context, s *driverSession, p model.Coordinate) {
+	var zone model.Zone
+	position := 0
+	if staging, ok := w.zones.Staging(p); ok && s.profile.works(staging.Region) {
+		rank, err := w.staging.Enter(ctx, staging.ID, s.driverID())
+		switch {
+		case err == nil:
+			zone, position = staging, rank+1
+		case !errors.Is(err, location.ErrDriverBusy):
+			log.Printf("driver %s: enter staging zone %d: %v\n", s.driverID(), staging.ID, err)
+			return
+		}
+	} else if s.stagingZone.ID != 0 {
+		if err := w.staging.Leave(ctx, s.driverID()); err != nil {
+			log.Printf("driver %s: leave staging zone %d: %v\n", s.driverID(), s.stagingZone.ID, err)
+			return
+		}
+	}
+	if zone.ID == s.stagingZone.ID && position == s.queuePosition {
+		return
+	}
+
+	// The drivers leaving are told the zone they left
+	told := zone
+	if position == 0 {
+		told = s.stagingZone
+	}
+	sent := offerDriverMessage(s, &model.DriverQueuePosition{
+		BaseMessage: model.BaseMessage{Type: model.DriverQueuePositionMsgType},
+		ZoneID:      told.ID,
+		Zone:        told.Name,
+		Position:    position,
+	})
+	if !sent {
+		log.Printf("driver %s: dropping queue position, output buffer full\n", s.driverID())
+		return
+	}
+	s.stagingZone, s.queuePosition = zone, position
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/location"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/zones"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGeo records the locations saved and the writes in flight per driver, the rest of the LocationManager is
+// not used by the location writer
+type fakeGeo struct {
+	location.LocationManager
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    map[string]int
+	maxInFlight int
+	saved       map[string]model.Coordinate
+	saves       int
+}
+
+func newFakeGeo(delay time.Duration) *fakeGeo {
+	return &fakeGeo{delay: delay, inFlight: make(map[string]int), saved: make(map[string]model.Coordinate)}
+}
+
+func (g *fakeGeo) SaveDriverLocation(ctx context.Context, driverID string, latitude, longitude float64) error {
+	g.mu.Lock()
+	g.inFlight[driverID]++
+	if g.inFlight[driverID] > g.maxInFlight {
+		g.maxInFlight = g.inFlight[driverID]
+	}
+	g.mu.Unlock()
+
+	time.Sleep(g.delay)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight[driverID]--
+	g.saved[driverID] = model.Coordinate{Lat: latitude, Lon: longitude}
+	g.saves++
+	return nil
+}
+
+func (g *fakeGeo) location(driverID string) (model.Coordinate, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, ok := g.saved[driverID]
+	return c, ok
+}
+
+// fakeTrails records the fixes appended per driver
+type fakeTrails struct {
+	location.TrailRecorder
+
+	mu    sync.Mutex
+	fixes map[string][]model.DriverLocationFix
+}
+
+func (t *fakeTrails) AppendFixes(ctx context.Context, driverID string, fixes []model.DriverLocationFix) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fixes == nil {
+		t.fixes = make(map[string][]model.DriverLocationFix)
+	}
+	t.fixes[driverID] = append(t.fixes[driverID], fixes...)
+	return nil
+}
+
+func (t *fakeTrails) count(driverID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.fixes[driverID])
+}
+
+func newTestDriverSession(driverID string) *driverSession {
+	return newDriverSession(context.Background(), nil, &driverProfile{driverID: driverID}, nil)
+}
+
+func fixAt(lat float64) model.DriverLocationFix {
+	return model.DriverLocationFix{Latitude: lat, Longitude: 2, Timestamp: time.Now()}
+}
+
+func TestShouldWriteASessionWithOneWriterAtATime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	geo := newFakeGeo(2 * time.Millisecond)
+	trails := &fakeTrails{}
+	writer := newLocationWriter(geo, trails, zones.NewRegistry(nil), nil, 8, 16)
+	writer.run(ctx)
+
+	session := newTestDriverSession("1")
+	const fixes = 200
+	for i := 1; i <= fixes; i++ {
+		require.True(t, writer.submit(session, fixAt(float64(i))))
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		return trails.count("1") == fixes
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		session.fixMu.Lock()
+		defer session.fixMu.Unlock()
+		return !session.fixQueued
+	}, 5*time.Second, time.Millisecond)
+
+	geo.mu.Lock()
+	assert.Equal(t, 1, geo.maxInFlight)
+	geo.mu.Unlock()
+	// The location is the latest fix, however the fixes were batched
+	latest, ok := geo.location("1")
+	require.True(t, ok)
+	assert.Equal(t, float64(fixes), latest.Lat)
+}
+
+func TestShouldBatchTheFixesOfAQueuedSession(t *testing.T) {
+	geo := newFakeGeo(0)
+	trails := &fakeTrails{}
+	// No workers running, the session waits in the queue
+	writer := newLocationWriter(geo, trails, zones.NewRegistry(nil), nil, 1, 1)
+
+	session := newTestDriverSession("1")
+	other := newTestDriverSession("2")
+	require.True(t, writer.submit(session, fixAt(1)))
+	require.True(t, writer.submit(session, fixAt(2), fixAt(3)))
+	// The queue is full, the fixes of another session are kept for later
+	assert.False(t, writer.submit(other, fixAt(4)))
+	assert.Len(t, writer.queue, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer.run(ctx)
+	require.Eventually(t, func() bool {
+		return trails.count("1") == 3
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, ok := geo.location("1")
+		return ok
+	}, 5*time.Second, time.Millisecond)
+	geo.mu.Lock()
+	assert.Equal(t, 1, geo.saves)
+	geo.mu.Unlock()
+
+	// The kept fixes go with the next submit
+	require.True(t, writer.submit(other, fixAt(5)))
+	require.Eventually(t, func() bool {
+		return trails.count("2") == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestShouldNotSaveTheLocationOfAClosedSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	geo := newFakeGeo(20 * time.Millisecond)
+	trails := &fakeTrails{}
+	writer := newLocationWriter(geo, trails, zones.NewRegistry(nil), nil, 2, 16)
+	writer.run(ctx)
+
+	session := newTestDriverSession("1")
+	require.True(t, writer.submit(session, fixAt(1)))
+	require.Eventually(t, func() bool {
+		geo.mu.Lock()
+		defer geo.mu.Unlock()
+		return geo.inFlight["1"] == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// Closing waits for the write in flight, the later fixes only reach the trail
+	session.cancel()
+	writer.wait(session)
+	_, ok := geo.location("1")
+	assert.True(t, ok)
+	geo.mu.Lock()
+	delete(geo.saved, "1")
+	geo.mu.Unlock()
+
+	require.True(t, writer.submit(session, fixAt(2)))
+	require.Eventually(t, func() bool {
+		return trails.count("1") == 2
+	}, 5*time.Second, time.Millisecond)
+	writer.wait(session)
+	_, ok = geo.location("1")
+	assert.False(t, ok)
+}
+
+// fakeStaging queues every driver entering first, the rest of the StagingQueue is not used by the location
+// writer
+type fakeStaging struct {
+	location.StagingQueue
+}
+
+func (q *fakeStaging) Enter(ctx context.Context, zoneID int, driverID string) (int, error) {
+	return 0, nil
+}
+
+// zonesSource serves the zones of the registry
+type zonesSource []model.Zone
+
+func (z zonesSource) ListZones(ctx context.Context, region string) ([]model.Zone, error) {
+	return z, nil
+}
+
+func TestShouldTellTheQueuePositionWithoutWaitingForTheDriver(t *testing.T) {
+	registry := zones.NewRegistry(zonesSource{{
+		ID: 1, Region: "madrid", Name: "airport", Kind: model.ZoneKindRestricted,
+		Area:         json.RawMessage(`{"type": "Polygon", "coordinates": [[[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]]}`),
+		PickupPoints: []model.Coordinate{{Lat: 5, Lon: 5}},
+	}, {
+		ID: 2, Region: "madrid", Name: "taxi lot", Kind: model.ZoneKindStaging, PickupZoneID: 1,
+		Area: json.RawMessage(`{"type": "Polygon", "coordinates": [[[1, 1], [3, 1], [3, 3], [1, 3], [1, 1]]]}`),
+	}})
+	require.NoError(t, registry.Reload(context.Background()))
+	writer := newLocationWriter(newFakeGeo(0), &fakeTrails{}, registry, &fakeStaging{}, 1, 1)
+
+	session := newTestDriverSession("1")
+	for len(session.out) < cap(session.out) {
+		session.out <- &model.DriverOutputMessage{}
+	}
+
+	// The driver does not read, the position is dropped and the writer goes on
+	done := make(chan struct{})
+	go func() {
+		writer.stage(context.Background(), session, model.Coordinate{Lat: 2, Lon: 2})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the writer waited for the driver")
+	}
+	assert.Equal(t, 0, session.queuePosition)
+
+	// Once the driver reads, the position is told with the next location
+	for len(session.out) > 0 {
+		<-session.out
+	}
+	writer.stage(context.Background(), session, model.Coordinate{Lat: 2, Lon: 2})
+	assert.Equal(t, 1, session.queuePosition)
+	assert.Len(t, session.out, 1)
+}
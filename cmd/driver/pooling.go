@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/pooling"
+	"github.com/OscarMoya/Glubber/pkg/service"
+)
+
+const (
+	// poolMaxDetourKm bounds how much longer the remaining route of a pool can get with a new ride
+	poolMaxDetourKm = 3.0
+	// poolMaxDetourRatio bounds the extra distance of the new passenger compared to riding alone
+	poolMaxDetourRatio = 0.5
+)
+
+// joinPool assigns a pooled ride waiting for a driver to the pool that takes it with the smallest detour, the
+// fares of the pool are split again. It returns false when the ride has to be offered to the drivers, because
+// it is not pooled or no pool can take it.
+func joinPool(ctx context.Context, serviceStatus *ServiceData, ride *model.Ride) bool {
+	if serviceStatus.Pooling == nil || !ride.Pooled || ride.Status != model.RideStatusPassengerAccepted {
+		return false
+	}
+	pools, err := buildPools(ctx, serviceStatus)
+	if err != nil {
+		log.Printf("pools for ride %d: %v\n", ride.ID, err)
+		return false
+	}
+	price := ride.Price
+	pool, err := serviceStatus.Pooling.Match(ctx, pools, ride)
+	if err != nil {
+		log.Printf("match ride %d with the pools: %v\n", ride.ID, err)
+		return false
+	}
+	if pool == nil {
+		return false
+	}
+	driverID, err := strconv.Atoi(pool.DriverID)
+	if err != nil {
+		return false
+	}
+	err = serviceStatus.Rides.JoinPool(ctx, ride, pool.Rides, driverID)
+	if errors.Is(err, service.ErrRideNotOffered) {
+		// Another driver took the ride meanwhile
+		return true
+	}
+	if err != nil {
+		log.Printf("join ride %d to pool %d: %v\n", ride.ID, pool.ID, err)
+		// The ride is offered alone, at the price quoted for it
+		ride.DriverID = nil
+		ride.PoolID = nil
+		ride.Price = price
+		ride.Status = model.RideStatusPassengerAccepted
+		return false
+	}
+	return true
+}
+
+// buildPools groups the active rides by driver, the drivers with a ride that is not pooled, without vehicle
+// or without location have no pool. The pickups of the rides in transit are the legs already driven, and the
+// pickups of the rides waiting for the driver go before all the dropoffs.
+func buildPools(ctx context.Context, serviceStatus *ServiceData) ([]*pooling.Pool, error) {
+	rides, err := serviceStatus.Rides.ListActiveRides(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byDriver := make(map[int][]*model.Ride)
+	solo := make(map[int]bool)
+	for i := range rides {
+		ride := &rides[i]
+		if !ride.Pooled {
+			solo[*ride.DriverID] = true
+			continue
+		}
+		byDriver[*ride.DriverID] = append(byDriver[*ride.DriverID], ride)
+	}
+
+	pools := make([]*pooling.Pool, 0, len(byDriver))
+	for driverID, driverRides := range byDriver {
+		if solo[driverID] {
+			continue
+		}
+		vehicle, err := serviceStatus.Vehicles.GetDriverVehicle(ctx, driverID)
+		if err != nil {
+			continue
+		}
+		lat, lon, err := serviceStatus.GeoService.GetDriverLocation(ctx, strconv.Itoa(driverID))
+		if err != nil {
+			continue
+		}
+		pools = append(pools, newPool(driverID, vehicle.Seats, model.Coordinate{Lat: lat, Lon: lon}, driverRides))
+	}
+	return pools, nil
+}
+
+// newPool builds the pool of the rides of a driver, the pool is identified by the pool of its rides or by its
+// first ride when it has a single one
+func newPool(driverID, capacity int, position model.Coordinate, rides []*model.Ride) *pooling.Pool {
+	pool := &pooling.Pool{
+		ID:       rides[0].ID,
+		DriverID: strconv.Itoa(driverID),
+		Capacity: capacity,
+		Position: position,
+		Rides:    rides,
+	}
+	var dropoffs []pooling.Stop
+	for _, ride := range rides {
+		if ride.PoolID != nil {
+			pool.ID = *ride.PoolID
+		}
+		pickup := model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}
+		if ride.Status == model.RideStatusInTransit {
+			pool.Driven = append(pool.Driven, pickup)
+		} else {
+			pool.Stops = append(pool.Stops, pooling.Stop{RideID: ride.ID, Kind: pooling.StopPickup, Point: pickup})
+		}
+		dropoffs = append(dropoffs, pooling.Stop{RideID: ride.ID, Kind: pooling.StopDropoff, Point: model.Coordinate{Lat: ride.DstLat, Lon: ride.DstLon}})
+	}
+	pool.Stops = append(pool.Stops, dropoffs...)
+	return pool
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/location"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// driverWriteWait is the time allowed to write a message to the driver
+	driverWriteWait = 10 * time.Second
+	// driverPongWait is the time allowed to read the next pong from the driver
+	driverPongWait = 60 * time.Second
+	// driverPingPeriod is how often the driver is pinged, it must be shorter than driverPongWait
+	driverPingPeriod = (driverPongWait * 9) / 10
+	// driverMaxMessageSize is the largest message accepted from a driver
+	driverMaxMessageSize = 8192
+	// driverSendBuffer is the amount of messages queued for a driver before the session is considered stuck
+	driverSendBuffer = 256
+)
+
+// driverSession is an open WebSocket of a driver. The socket is read by readPump and written by writePump
+// only, so gorilla's single reader and single writer rules hold; the rest of the code queues messages in out.
+type driverSession struct {
+	id      string
+	profile *driverProfile
+	claims  *authentication.DriverClaims
+	ws      *websocket.Conn
+
+	out     chan *model.DriverOutputMessage
+	goodbye chan string
+	endOnce sync.Once
+
+	// protocol is the negotiated protocol version and encoding, the first version with JSON until the driver
+	// says hello
+	protocol atomic.Pointer[sessionProtocol]
+
+	// pendingFixes are the locations not written yet, fixQueued is set while the session waits for a
+	// location writer or is being written. writeMu is held by the writer of the session.
+	fixMu        sync.Mutex
+	pendingFixes []model.DriverLocationFix
+	fixQueued    bool
+	writeMu      sync.Mutex
+
+	// fixes validates the locations of the driver, spoofFlagged is set once the driver was flagged. Both are
+	// only used by the service loop.
+	fixes        *location.FixValidator
+	spoofFlagged bool
+
+	// stagingZone is the staging zone the driver is queued in and queuePosition its position told to the
+	// driver, 0 when it is not queued. Both are only used by the location writers.
+	stagingZone   model.Zone
+	queuePosition int
+
+	// pongWait and pingPeriod are driverPongWait and driverPingPeriod, the tests shorten them
+	pongWait   time.Duration
+	pingPeriod time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDriverSession(ctx context.Context, ws *websocket.Conn, profile *driverProfile, claims *authentication.DriverClaims) *driverSession {
+	ctx, cancel := context.WithCancel(ctx)
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	session := &driverSession{
+		id:      hex.EncodeToString(raw),
+		profile: profile,
+		claims:  claims,
+		ws:      ws,
+		out:     make(chan *model.DriverOutputMessage, driverSendBuffer),
+		goodbye: make(chan string, 1),
+		fixes:   location.NewFixValidator(location.FixValidatorOpts{}),
+
+		pongWait:   driverPongWait,
+		pingPeriod: driverPingPeriod,
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	session.setProtocol(model.DriverProtocolVersion1, model.DriverEncodingJSON)
+	return session
+}
+
+// sessionProtocol is what the driver and the server agreed to speak
+type sessionProtocol struct {
+	version  int
+	encoding model.DriverEncoding
+}
+
+func (s *driverSession) setProtocol(version int, encoding model.DriverEncoding) {
+	s.protocol.Store(&sessionProtocol{version: version, encoding: encoding})
+}
+
+// protocolVersion returns the protocol version negotiated with the driver
+func (s *driverSession) protocolVersion() int {
+	return s.protocol.Load().version
+}
+
+// encoding returns the encoding negotiated with the driver
+func (s *driverSession) encoding() model.DriverEncoding {
+	return s.protocol.Load().encoding
+}
+
+// driverID returns the ID of the driver of the session
+func (s *driverSession) driverID() string {
+	return s.profile.driverID
+}
+
+// end closes the session from the server side, the driver gets a goodbye with the reason before the socket is
+// closed. Only the first reason is sent.
+func (s *driverSession) end(reason string) {
+	s.endOnce.Do(func() {
+		s.goodbye <- reason
+	})
+}
+
+// readPump reads the messages of the driver until the socket fails or is closed. The read deadline is pushed
+// forward by every pong and message, a driver that stops answering the pings is dropped.
+func (s *driverSession) readPump(in chan<- *model.DriverInputMessage) {
+	defer s.cancel()
+
+	s.ws.SetReadLimit(driverMaxMessageSize)
+	s.ws.SetReadDeadline(time.Now().Add(s.pongWait))
+	s.ws.SetPongHandler(func(string) error {
+		return s.ws.SetReadDeadline(time.Now().Add(s.pongWait))
+	})
+
+	for {
+		frameType, msg, err := s.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("driver %s: read: %v\n", s.driverID(), err)
+			}
+			return
+		}
+		s.ws.SetReadDeadline(time.Now().Add(s.pongWait))
+
+		driverIn := &model.DriverInputMessage{}
+		driverIn.Payload = msg
+		driverIn.Binary = frameType == websocket.BinaryMessage
+		driverIn.DriverAuth = s.claims
+
+		select {
+		case in <- driverIn:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// writePump writes the queued messages and the pings. It owns the socket: when it returns the session is
+// cancelled and the socket closed, which also stops readPump.
+func (s *driverSession) writePump() {
+	ticker := time.NewTicker(s.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		s.cancel()
+		s.ws.Close()
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(driverWriteWait))
+			return
+		case reason := <-s.goodbye:
+			log.Printf("closing session of driver %s: %s\n", s.driverID(), reason)
+			goodbye := model.DriverGoodByeResponse{Reason: reason}
+			goodbye.Type = model.DriverGoodByeMsgType
+			payload, _ := json.Marshal(goodbye)
+			s.ws.SetWriteDeadline(time.Now().Add(driverWriteWait))
+			s.ws.WriteMessage(websocket.TextMessage, payload)
+			s.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session ended"), time.Now().Add(driverWriteWait))
+			return
+		case msg := <-s.out:
+			s.ws.SetWriteDeadline(time.Now().Add(driverWriteWait))
+			if err := s.ws.WriteMessage(websocket.TextMessage, msg.Payload); err != nil {
+				log.Printf("driver %s: write: %v\n", s.driverID(), err)
+				return
+			}
+		case <-ticker.C:
+			s.ws.SetWriteDeadline(time.Now().Add(driverWriteWait))
+			if err := s.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("driver %s: ping: %v\n", s.driverID(), err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestPumps serves a single WebSocket whose server side runs the pumps of a driver session, it returns
+// the session and the client side of the socket. Zero durations keep the pings of the service.
+func startTestPumps(t *testing.T, pongWait, pingPeriod time.Duration) (*driverSession, *websocket.Conn, chan *model.DriverInputMessage) {
+	sessions := make(chan *driverSession, 1)
+	in := make(chan *model.DriverInputMessage, driverSendBuffer)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		session := newDriverSession(context.Background(), ws, &driverProfile{driverID: "1"}, nil)
+		if pongWait > 0 {
+			session.pongWait, session.pingPeriod = pongWait, pingPeriod
+		}
+		go session.writePump()
+		go session.readPump(in)
+		sessions <- session
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return <-sessions, client, in
+}
+
+func sessionDone(session *driverSession) bool {
+	select {
+	case <-session.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestShouldDropMessagesOverTheReadLimit(t *testing.T) {
+	session, client, in := startTestPumps(t, 0, 0)
+
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`)))
+	msg := <-in
+	assert.JSONEq(t, `{"type":"ping"}`, string(msg.Payload))
+
+	large := `{"type":"ping","pad":"` + strings.Repeat("x", driverMaxMessageSize) + `"}`
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(large)))
+	require.Eventually(t, func() bool { return sessionDone(session) }, 5*time.Second, time.Millisecond)
+
+	// The server closes the socket
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := client.ReadMessage(); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig, websocket.CloseNormalClosure), err)
+			break
+		}
+	}
+	assert.Empty(t, in)
+}
+
+func TestShouldDropDriversThatStopAnsweringPings(t *testing.T) {
+	const pongWait = 200 * time.Millisecond
+
+	// A client reading the socket answers the pings and keeps the session
+	session, client, _ := startTestPumps(t, pongWait, pongWait/4)
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(4 * pongWait)
+	assert.False(t, sessionDone(session))
+
+	// A client that does not read never answers, the session is dropped after the pong deadline
+	silent, _, _ := startTestPumps(t, pongWait, pongWait/4)
+	require.Eventually(t, func() bool { return sessionDone(silent) }, 5*time.Second, time.Millisecond)
+}
+
+func TestShouldSayGoodbyeWhenTheSessionEnds(t *testing.T) {
+	session, client, _ := startTestPumps(t, 0, 0)
+
+	session.end("logged in from another device")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, payload, err := client.ReadMessage()
+	require.NoError(t, err)
+	var goodbye model.DriverGoodByeResponse
+	require.NoError(t, json.Unmarshal(payload, &goodbye))
+	assert.Equal(t, model.DriverGoodByeMsgType, goodbye.Type)
+	assert.Equal(t, "logged in from another device", goodbye.Reason)
+
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+	require.Eventually(t, func() bool { return sessionDone(session) }, 5*time.Second, time.Millisecond)
+}
+
+func TestShouldCloseTheSocketOnShutdown(t *testing.T) {
+	session, client, _ := startTestPumps(t, 0, 0)
+
+	session.cancel()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+}
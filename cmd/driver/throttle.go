@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// driverMessageRate is the sustained number of messages per second accepted from a driver, location batches
+	// count as one message
+	driverMessageRate = 5
+	// driverMessageBurst is the number of messages a driver can send at once after being quiet
+	driverMessageBurst = 20
+	// driverSessionHandlers bounds the messages of a driver handled at the same time, the session stops reading
+	// while they are busy
+	driverSessionHandlers = 4
+)
+
+// tokenBucket is a token bucket rate limiter. It is not safe for concurrent use, every session reads its
+// messages from a single goroutine.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// allow takes a token if there is one
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldThrottleWithTokenBucket(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    float64
+		offsets  []time.Duration
+		expected []bool
+	}{
+		{
+			name:     "Burst is allowed at once",
+			rate:     1,
+			burst:    3,
+			offsets:  []time.Duration{0, 0, 0, 0},
+			expected: []bool{true, true, true, false},
+		},
+		{
+			name:     "Tokens refill at the rate",
+			rate:     2,
+			burst:    1,
+			offsets:  []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond},
+			expected: []bool{true, false, false, true},
+		},
+		{
+			name:     "Refill is capped by the burst",
+			rate:     10,
+			burst:    2,
+			offsets:  []time.Duration{0, 0, time.Minute, time.Minute, time.Minute},
+			expected: []bool{true, true, true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newTokenBucket(tt.rate, tt.burst)
+			var got []bool
+			for _, offset := range tt.offsets {
+				got = append(got, bucket.allow(start.Add(offset)))
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/trail"
+	"github.com/gorilla/mux"
+)
+
+// trailWindow is the time range returned when the query does not bound it
+const trailWindow = 24 * time.Hour
+
+// getDriverTrailHandler returns the trail of the driver between the from and to query parameters, RFC3339
+// times. They default to the last trailWindow.
+func getDriverTrailHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idInt, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseTimeParam(r, "to", time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := parseTimeParam(r, "from", to.Add(-trailWindow))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		trail, err := serviceData.TrailDB.GetDriverTrail(ctx, idInt, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(trail)
+	}
+}
+
+// getRideTrailHandler returns the trail of the ride, the fixes reach it once flushed from Redis
+func getRideTrailHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rideID, err := strconv.Atoi(mux.Vars(r)["rideID"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		trail, err := serviceData.TrailDB.GetRideTrail(ctx, rideID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(trail)
+	}
+}
+
+// getRidePathHandler returns the trail of the ride cleaned of the GPS noise, with the distance driven
+func getRidePathHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rideID, err := strconv.Atoi(mux.Vars(r)["rideID"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		points, err := serviceData.TrailDB.GetRideTrail(ctx, rideID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(points) == 0 {
+			http.Error(w, "no trail for the ride", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(trail.Process(points, trail.Options{Roads: serviceData.Roads}))
+	}
+}
+
+// parseTimeParam parses an RFC3339 query parameter, def is returned when it is missing
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -2,13 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/OscarMoya/Glubber/pkg/authentication"
 	"github.com/OscarMoya/Glubber/pkg/location"
 	"github.com/OscarMoya/Glubber/pkg/model"
-	"github.com/gorilla/websocket"
+	"github.com/OscarMoya/Glubber/pkg/service"
+)
+
+const (
+	// tokenCheckInterval is how often an open session checks that its token was not revoked
+	tokenCheckInterval = 15 * time.Second
+	// driverBackendTimeout bounds the time spent handling a message of a driver
+	driverBackendTimeout = 10 * time.Second
 )
 
 func handleDriverConnections(w http.ResponseWriter, r *http.Request, serviceStatus *ServiceData) {
@@ -26,136 +37,482 @@ func handleDriverConnections(w http.ResponseWriter, r *http.Request, serviceStat
 		return
 	}
 
+	// The token only proves who the driver is, the drivers database tells if it can work right now
+	profile, err := loadDriverProfile(r.Context(), serviceStatus, claims.DriverID)
+	switch {
+	case errors.Is(err, errUnknownDriver):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, errDriverCantWork):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Keep the class used by the nearby drivers search in sync with the vehicle
+	err = serviceStatus.GeoService.SetDriverVehicleClass(r.Context(), profile.driverID, string(profile.VehicleClass()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
+		// The upgrader already replied to the client
+		log.Println("upgrade:", err)
+		return
+	}
+
+	// The session outlives the request context, hijacked connections are not cancelled by net/http
+	session := newDriverSession(context.Background(), ws, profile, claims)
+	// A driver has a single session, an older one in any instance is evicted
+	if err := serviceStatus.Hub.register(session.ctx, session); err != nil {
+		log.Printf("driver %s: register hub session: %v\n", session.driverID(), err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), driverBackendTimeout)
+		defer cancel()
+		serviceStatus.Hub.unregister(ctx, session)
+	}()
 
-	in := make(chan *model.DriverInputMessage, 256)
-	out := make(chan *model.DriverOutputMessage, 256)
+	in := make(chan *model.DriverInputMessage, driverSendBuffer)
+	go session.writePump()
+	go driverSvcLoop(session, in, serviceStatus)
+	go watchDriverToken(session, tokenString, serviceStatus)
 
-	defer ws.Close()
+	session.readPump(in)
 
-	ctx, cancel := context.WithCancel(r.Context())
+	// The driver is gone, it must not be offered rides until it connects again. A session evicted by a newer
+	// login leaves the location to the new session. The location written last must be done before it is
+	// removed.
+	serviceStatus.Locations.wait(session)
+	ctx, cancel := context.WithTimeout(context.Background(), driverBackendTimeout)
 	defer cancel()
+	if serviceStatus.Hub.owns(ctx, session) {
+		if err := serviceStatus.GeoService.RemoveDriverLocation(ctx, session.driverID()); err != nil {
+			log.Println("RemoveDriverLocation:", err)
+		}
+		if err := serviceStatus.Staging.Leave(ctx, session.driverID()); err != nil {
+			log.Println("Leave staging zone:", err)
+		}
+	}
+	log.Printf("driver %s disconnected\n", session.driverID())
+}
 
-	go driverSvcLoop(ctx, in, out, serviceStatus.GeoService)
+// watchDriverToken validates the token of the session periodically, once it is revoked or expires the
+// session is ended
+func watchDriverToken(session *driverSession, tokenString string, serviceStatus *ServiceData) {
+	ticker := time.NewTicker(tokenCheckInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ctx.Done():
-			log.Println("Driver service loop done")
+		case <-session.ctx.Done():
 			return
-		case msg := <-out:
-			err := ws.WriteMessage(websocket.TextMessage, msg.Payload)
-			if err != nil {
-				// If there is an error writing to the websocket, log it and continue
-				// We may want to handle this differently in a production system
-				log.Println("write:", err)
+		case <-ticker.C:
+			_, err := serviceStatus.Authenticator.ValidateDriverJWT(tokenString)
+			if err == nil {
 				continue
 			}
-		default:
-			// TODO: check message types
-			_, msg, err := ws.ReadMessage()
-			if err != nil {
-				log.Println("read:", err)
-				break
+			// A denylist outage does not log out every driver, the check is retried on the next tick
+			if errors.Is(err, authentication.ErrRevocationCheckFailed) {
+				log.Println("ValidateDriverJWT:", err)
+				continue
 			}
-			log.Printf("recv: %s", msg)
-
-			// TODO: Add constructor
-			driverIn := &model.DriverInputMessage{}
-			driverIn.Payload = msg
-			driverIn.DriverAuth = claims
-
-			in <- driverIn
+			session.end(err.Error())
+			return
 		}
 	}
 }
 
-func driverSvcLoop(ctx context.Context, in <-chan *model.DriverInputMessage, out chan<- *model.DriverOutputMessage, geoService location.LocationManager) {
+// driverSvcLoop dispatches the messages of the driver. The messages beyond the rate of the token bucket are
+// dropped, the driver is told once per throttled streak. Locations are handed to the location writer, which
+// keeps only the latest one, and the rest of the messages are handled by a few goroutines of the session with
+// their own deadline. Messages with an ID that have no response of their own are acknowledged, failures are
+// always replied with a DriverErrorResponse carrying the original type and ID.
+func driverSvcLoop(session *driverSession, in <-chan *model.DriverInputMessage, serviceStatus *ServiceData) {
+	limiter := newTokenBucket(driverMessageRate, driverMessageBurst)
+	throttled := false
+	handlers := make(chan struct{}, driverSessionHandlers)
+
 	for {
 		select {
-		case <-ctx.Done():
+		case <-session.ctx.Done():
 			log.Println("Driver service loop done")
 			return
 		case msg := <-in:
-			// TODO: Stablish timeout for this OPS
-			backendCtx, cancel := context.WithCancel(ctx)
-			defer cancel()
-			var baseMessage model.BaseMessage
-			if err := json.Unmarshal(msg.Payload, &baseMessage); err != nil {
-				log.Println("unmarshal base message:", err)
+			decoded, err := decodeDriverInput(session, msg)
+			if err != nil {
+				if decoded == nil {
+					sendDriverError(session.ctx, session, &model.BaseMessage{}, 400, "invalid message: "+err.Error())
+				} else {
+					sendDriverError(session.ctx, session, decoded.Base(), 400, err.Error())
+				}
 				continue
 			}
 
-			switch baseMessage.Type {
-			case model.DriverLocationMsgType:
-				var loc model.DriverLocationRequest
-				if err := json.Unmarshal(msg.Payload, &loc); err != nil {
-					log.Println("unmarshal driver location:", err)
-					continue
+			if !limiter.allow(time.Now()) {
+				if !throttled {
+					throttled = true
+					sendDriverError(session.ctx, session, decoded.Base(), 429, "rate limit exceeded, messages are dropped")
 				}
-				go handleDriverLocation(backendCtx, out, msg.DriverAuth.DriverID, loc.Latitude, loc.Longitude, geoService)
+				continue
+			}
+			throttled = false
 
-			case model.DriverGoodByeMsgType:
-				go handleDriverGoodBye(backendCtx, out, msg.DriverAuth.DriverID, geoService)
+			switch req := decoded.(type) {
+			case *model.DriverLocationRequest:
+				handleDriverLocation(session.ctx, session, req, serviceStatus)
+				continue
+			case *model.DriverLocationBatchRequest:
+				handleDriverLocationBatch(session.ctx, session, req, serviceStatus)
+				continue
+			}
 
-			default:
-				log.Println("Unknown message type:", baseMessage.Type)
+			// The session stops reading while its handlers are busy, so a slow backend slows the driver down
+			select {
+			case handlers <- struct{}{}:
+			case <-session.ctx.Done():
+				return
 			}
+			go func() {
+				defer func() { <-handlers }()
+				ctx, cancel := context.WithTimeout(session.ctx, driverBackendTimeout)
+				defer cancel()
+				handleDriverMessage(ctx, session, decoded, serviceStatus)
+			}()
 		}
 	}
 }
 
-func handleDriverLocation(ctx context.Context, out chan<- *model.DriverOutputMessage, driverID string, latitude, longitude float64, geoService location.LocationManager) {
-	err := geoService.SaveDriverLocation(ctx, driverID, latitude, longitude)
-	if err != nil {
-		log.Println("SaveDriverLocation:", err)
-		errMsg := model.DriverErrorResponse{
-			Code:   500,
-			Reason: err.Error(),
-		}
-		errMsg.Type = model.DriverErrorResponseMsgType
-		payload, err := json.Marshal(errMsg)
-		if err != nil {
-			log.Println("marshal error response:", err)
+// decodeDriverInput decodes a frame of the driver, binary frames are only accepted with the compact encoding
+func decodeDriverInput(session *driverSession, msg *model.DriverInputMessage) (model.DriverMessage, error) {
+	if !msg.Binary {
+		return model.DecodeDriverMessage(msg.Payload)
+	}
+	if session.encoding() != model.DriverEncodingCompact {
+		return nil, errors.New("binary frames need the compact encoding")
+	}
+	return model.DecodeCompactDriverMessage(msg.Payload)
+}
+
+func handleDriverMessage(ctx context.Context, session *driverSession, msg model.DriverMessage, serviceStatus *ServiceData) {
+	driverID := session.driverID()
+	switch req := msg.(type) {
+	case *model.DriverHelloRequest:
+		handleDriverHello(ctx, session, req)
+	case *model.DriverGoodByeRequest:
+		handleDriverGoodBye(ctx, session, req, serviceStatus)
+	case *model.DriverArrivedRequest:
+		handleDriverRideTransition(ctx, session, req.Base(), req.RideID, serviceStatus.Rides, serviceStatus.Rides.DriverArrived)
+	case *model.DriverStartRideRequest:
+		handleDriverStartRide(ctx, session, req, serviceStatus.Rides)
+	case *model.DriverCompleteRideRequest:
+		handleDriverRideTransition(ctx, session, req.Base(), req.RideID, serviceStatus.Rides, serviceStatus.Rides.CompleteRide)
+	case *model.DriverOfferAcceptRequest:
+		handleDriverOfferAccept(ctx, session, req, serviceStatus)
+	case *model.DriverOfferDeclineRequest:
+		log.Printf("driver %s declined ride %d: %s\n", driverID, req.RideID, req.Reason)
+		// The dispatcher sees the offer gone and passes the ride to the next driver
+		if err := serviceStatus.Offers.DeclineOffer(ctx, req.RideID, driverID); err != nil {
+			log.Println("DeclineOffer:", err)
+			sendDriverError(ctx, session, req.Base(), 500, err.Error())
 			return
 		}
-		outMsg := &model.DriverOutputMessage{}
-		outMsg.IsError = true
-		outMsg.Payload = payload
+		sendDriverAck(ctx, session, req.Base())
+	default:
+		// The rest of the types are sent by the server
+		sendDriverError(ctx, session, msg.Base(), 400, "message type not accepted from clients")
+	}
+}
 
-		out <- outMsg
+// queueDriverMessage queues a message for the write pump, it is dropped if the session is closed meanwhile
+func queueDriverMessage(ctx context.Context, out chan<- *model.DriverOutputMessage, msg *model.DriverOutputMessage) {
+	select {
+	case out <- msg:
+	case <-ctx.Done():
+	}
+}
 
+// sendDriverMessage encodes and queues a message of the protocol for the driver
+func sendDriverMessage(ctx context.Context, session *driverSession, msg model.DriverMessage) {
+	outMsg, err := encodeDriverOutput(msg)
+	if err != nil {
+		log.Println("encode driver message:", err)
 		return
 	}
+	queueDriverMessage(ctx, session.out, outMsg)
 }
 
-func handleDriveRequest(ctx context.Context, out chan<- *model.DriverOutputMessage, req model.DriveRequest) {
-	log.Printf("Received drive request: %+v\n", req)
+// offerDriverMessage encodes and queues a message of the protocol for the driver without waiting, it returns
+// false when the message was dropped because the output buffer of the driver is full
+func offerDriverMessage(session *driverSession, msg model.DriverMessage) bool {
+	outMsg, err := encodeDriverOutput(msg)
+	if err != nil {
+		log.Println("encode driver message:", err)
+		return false
+	}
+	select {
+	case session.out <- outMsg:
+		return true
+	default:
+		return false
+	}
 }
 
-func handleDriverGoodBye(ctx context.Context, out chan<- *model.DriverOutputMessage, driverID string, geoService location.LocationManager) {
-	err := geoService.RemoveDriverLocation(ctx, driverID)
+func encodeDriverOutput(msg model.DriverMessage) (*model.DriverOutputMessage, error) {
+	payload, err := model.EncodeDriverMessage(msg)
 	if err != nil {
-		log.Println("DeleteDriverLocation:", err)
-		errMsg := model.DriverErrorResponse{
-			Code:   500,
-			Reason: err.Error(),
+		return nil, err
+	}
+	outMsg := &model.DriverOutputMessage{}
+	outMsg.IsError = msg.Base().Type == model.DriverErrorResponseMsgType
+	outMsg.Payload = payload
+	return outMsg, nil
+}
+
+// sendDriverAck acknowledges a message, messages without ID are not acknowledged
+func sendDriverAck(ctx context.Context, session *driverSession, req *model.BaseMessage) {
+	if req.ID == "" {
+		return
+	}
+	sendDriverMessage(ctx, session, &model.DriverAckResponse{
+		BaseMessage:         req.Reply(model.DriverAckMsgType),
+		OriginalMessageType: req.Type,
+	})
+}
+
+// sendDriverError builds a DriverErrorResponse for the original message and queues it to the driver
+func sendDriverError(ctx context.Context, session *driverSession, req *model.BaseMessage, code int, reason string) {
+	sendDriverMessage(ctx, session, &model.DriverErrorResponse{
+		BaseMessage:         req.Reply(model.DriverErrorResponseMsgType),
+		OriginalMessageType: req.Type,
+		Code:                code,
+		Reason:              reason,
+	})
+}
+
+// handleDriverHello negotiates the protocol version and the encoding of the session, the first version only
+// speaks JSON
+func handleDriverHello(ctx context.Context, session *driverSession, req *model.DriverHelloRequest) {
+	version, err := model.NegotiateDriverProtocolVersion(req.Versions)
+	if err != nil {
+		sendDriverError(ctx, session, req.Base(), 400, err.Error())
+		return
+	}
+	encoding := model.DriverEncodingJSON
+	if version >= model.DriverProtocolVersion2 {
+		encoding = model.NegotiateDriverEncoding(req.Encodings)
+	}
+	session.setProtocol(version, encoding)
+	sendDriverMessage(ctx, session, &model.DriverHelloResponse{
+		BaseMessage: req.Reply(model.DriverHelloMsgType),
+		Version:     version,
+		Encoding:    encoding,
+	})
+}
+
+// handleDriverLocation hands the location to the location writer once validated, the ack means the location
+// was accepted
+func handleDriverLocation(ctx context.Context, session *driverSession, req *model.DriverLocationRequest, serviceStatus *ServiceData) {
+	now := time.Now()
+	fix := req.Fix(now)
+	if err := validateDriverFix(session, fix, now, serviceStatus.GeoService); err != nil {
+		sendDriverError(ctx, session, req.Base(), 422, err.Error())
+		return
+	}
+	submitDriverLocation(ctx, session, req.Base(), serviceStatus.Locations, fix)
+}
+
+// handleDriverLocationBatch validates the fixes of the batch in order and hands the valid ones to the location
+// writer, the batch is only rejected when none of its fixes is valid
+func handleDriverLocationBatch(ctx context.Context, session *driverSession, req *model.DriverLocationBatchRequest, serviceStatus *ServiceData) {
+	if session.protocolVersion() < model.DriverProtocolVersion2 {
+		sendDriverError(ctx, session, req.Base(), 400, "location batches need protocol version 2")
+		return
+	}
+	if len(req.Fixes) == 0 || len(req.Fixes) > model.MaxDriverLocationFixes {
+		sendDriverError(ctx, session, req.Base(), 400, fmt.Sprintf("a batch has between 1 and %d fixes", model.MaxDriverLocationFixes))
+		return
+	}
+
+	now := time.Now()
+	var accepted []model.DriverLocationFix
+	var err error
+	for _, fix := range req.FixesAt(now) {
+		if fixErr := validateDriverFix(session, fix, now, serviceStatus.GeoService); fixErr != nil {
+			err = fixErr
+			continue
 		}
-		errMsg.Type = model.DriverErrorResponseMsgType
-		payload, err := json.Marshal(errMsg)
-		if err != nil {
-			log.Println("marshal error response:", err)
-			return
+		accepted = append(accepted, fix)
+	}
+	if len(accepted) == 0 {
+		sendDriverError(ctx, session, req.Base(), 422, err.Error())
+		return
+	}
+	submitDriverLocation(ctx, session, req.Base(), serviceStatus.Locations, accepted...)
+}
+
+// validateDriverFix validates the fix with the validator of the session. The first time the driver gathers
+// enough spoofing signals it is flagged for review, its fixes are still validated as usual.
+func validateDriverFix(session *driverSession, fix model.DriverLocationFix, now time.Time, geoService location.LocationManager) error {
+	err := session.fixes.Validate(fix, now)
+	if err == nil || session.spoofFlagged || !session.fixes.Flagged(now) {
+		return err
+	}
+
+	session.spoofFlagged = true
+	reason := err.Error()
+	log.Printf("driver %s flagged for location spoofing: %s\n", session.driverID(), reason)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), driverBackendTimeout)
+		defer cancel()
+		if err := geoService.FlagDriverLocation(ctx, session.driverID(), reason); err != nil {
+			log.Println("FlagDriverLocation:", err)
+		}
+	}()
+	return err
+}
+
+func submitDriverLocation(ctx context.Context, session *driverSession, req *model.BaseMessage, locations *locationWriter, fixes ...model.DriverLocationFix) {
+	if !locations.submit(session, fixes...) {
+		sendDriverError(ctx, session, req, 503, "location service busy, the location is written with the next one")
+		return
+	}
+	sendDriverAck(ctx, session, req)
+}
+
+// handleDriverGoodBye takes the driver off the rides, it also loses its spot in the staging zones
+func handleDriverGoodBye(ctx context.Context, session *driverSession, req *model.DriverGoodByeRequest, serviceStatus *ServiceData) {
+	err := serviceStatus.GeoService.RemoveDriverLocation(ctx, session.driverID())
+	if err != nil {
+		log.Println("DeleteDriverLocation:", err)
+		sendDriverError(ctx, session, req.Base(), 500, err.Error())
+		return
+	}
+	err = serviceStatus.Staging.Leave(ctx, session.driverID())
+	if err != nil {
+		log.Println("Leave staging zone:", err)
+		sendDriverError(ctx, session, req.Base(), 500, err.Error())
+		return
+	}
+	sendDriverAck(ctx, session, req.Base())
+}
+
+// handleDriverRideTransition moves a ride of the driver to its next status, the reply carries the ride with its
+// new status
+func handleDriverRideTransition(ctx context.Context, session *driverSession, req *model.BaseMessage, rideID int, rides service.RideCruder, transition func(ctx context.Context, ride *model.Ride) error) {
+	ride, err := rides.GetRide(ctx, rideID)
+	if err != nil {
+		log.Println("GetRide:", err)
+		sendDriverError(ctx, session, req, 404, err.Error())
+		return
+	}
+	// Only the driver assigned to the ride moves it
+	if ride.DriverID == nil || strconv.Itoa(*ride.DriverID) != session.driverID() {
+		sendDriverError(ctx, session, req, 403, "ride not assigned to driver")
+		return
+	}
+	err = transition(ctx, ride)
+	if errors.Is(err, service.ErrInvalidRideStatus) {
+		sendDriverError(ctx, session, req, 409, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("ride %d %s: %v\n", rideID, req.Type, err)
+		sendDriverError(ctx, session, req, 500, err.Error())
+		return
+	}
+	sendDriverMessage(ctx, session, &model.DriverRideStatusResponse{
+		BaseMessage: req.Reply(model.DriverRideStatusMsgType),
+		RideID:      ride.ID,
+		Status:      ride.Status,
+		Ride:        ride,
+	})
+}
+
+func handleDriverStartRide(ctx context.Context, session *driverSession, req *model.DriverStartRideRequest, rides service.RideCruder) {
+	ride, err := rides.GetRide(ctx, req.RideID)
+	if err != nil {
+		log.Println("GetRide:", err)
+		sendDriverError(ctx, session, req.Base(), 404, err.Error())
+		return
+	}
+
+	// Only the driver assigned to the ride can start it
+	if ride.DriverID == nil || strconv.Itoa(*ride.DriverID) != session.driverID() {
+		sendDriverError(ctx, session, req.Base(), 403, "ride not assigned to driver")
+		return
+	}
+
+	err = rides.StartRide(ctx, ride, req.PIN)
+	if err != nil {
+		log.Println("StartRide:", err)
+		code := 500
+		switch {
+		case errors.Is(err, service.ErrInvalidRideStatus):
+			code = 409
+		case errors.Is(err, service.ErrInvalidPickupPIN):
+			code = 403
+		case errors.Is(err, service.ErrPickupPINLocked):
+			code = 423
 		}
-		outMsg := &model.DriverOutputMessage{}
-		outMsg.IsError = true
-		outMsg.Payload = payload
+		sendDriverError(ctx, session, req.Base(), code, err.Error())
+		return
+	}
 
-		out <- outMsg
+	sendDriverMessage(ctx, session, &model.DriverStartRideResponse{
+		BaseMessage: req.Reply(model.DriverStartRideMsgType),
+		RideID:      ride.ID,
+		Status:      ride.Status,
+	})
+}
 
+// handleDriverOfferAccept assigns the offered ride to the driver, the reply carries the ride with its new status
+func handleDriverOfferAccept(ctx context.Context, session *driverSession, req *model.DriverOfferAcceptRequest, serviceStatus *ServiceData) {
+	driverID, err := strconv.Atoi(session.driverID())
+	if err != nil {
+		sendDriverError(ctx, session, req.Base(), 400, err.Error())
+		return
+	}
+	// Only the drivers the ride was offered to can take it, and only while their offer lasts
+	offered, err := serviceStatus.Offers.HasOffer(ctx, req.RideID, session.driverID())
+	if err != nil {
+		log.Println("HasOffer:", err)
+		sendDriverError(ctx, session, req.Base(), 500, err.Error())
+		return
+	}
+	if !offered {
+		sendDriverError(ctx, session, req.Base(), 409, service.ErrRideNotOffered.Error())
+		return
+	}
+	ride, err := serviceStatus.Rides.AcceptRideOffer(ctx, req.RideID, driverID)
+	if errors.Is(err, service.ErrRideNotOffered) {
+		sendDriverError(ctx, session, req.Base(), 409, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("AcceptRideOffer:", err)
+		sendDriverError(ctx, session, req.Base(), 500, err.Error())
 		return
 	}
+	if err := serviceStatus.Offers.ClearOffers(ctx, ride.ID); err != nil {
+		log.Println("ClearOffers:", err)
+	}
+	// The ride event reaches the dispatcher later, the trail follows the ride right away
+	if err := serviceStatus.Trails.SetActiveRide(ctx, session.driverID(), ride.ID); err != nil {
+		log.Println("SetActiveRide:", err)
+	}
+	// The driver leaves the staging zones until the ride ends
+	if err := serviceStatus.Staging.SetBusy(ctx, session.driverID(), true); err != nil {
+		log.Println("SetBusy:", err)
+	}
+
+	sendDriverMessage(ctx, session, &model.DriverRideStatusResponse{
+		BaseMessage: req.Reply(model.DriverRideStatusMsgType),
+		RideID:      ride.ID,
+		Status:      ride.Status,
+		Ride:        ride,
+	})
 }
@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/OscarMoya/Glubber/pkg/middleware"
 	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/service"
 	"github.com/gorilla/mux"
 )
 
@@ -54,6 +57,10 @@ func getPassengerHandler(serviceData *ServiceData) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if !isCallingPassenger(r, id) {
+			http.Error(w, "passenger not found", http.StatusNotFound)
+			return
+		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 		Passenger, err := serviceData.PGDB.GetPassenger(ctx, idInt)
@@ -69,14 +76,25 @@ func getPassengerHandler(serviceData *ServiceData) http.HandlerFunc {
 
 func updatePassengerHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isCallingPassenger(r, id) {
+			http.Error(w, "passenger not found", http.StatusNotFound)
+			return
+		}
 		var Passenger model.Passenger
 		if err := json.NewDecoder(r.Body).Decode(&Passenger); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		Passenger.ID = idInt
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
-		err := serviceData.PGDB.UpdatePassenger(ctx, &Passenger)
+		err = serviceData.PGDB.UpdatePassenger(ctx, &Passenger)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -95,6 +113,10 @@ func deletePassengerHandler(serviceStatus *ServiceData) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if !isCallingPassenger(r, id) {
+			http.Error(w, "passenger not found", http.StatusNotFound)
+			return
+		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 		err = serviceStatus.PGDB.DeletePassenger(ctx, idInt)
@@ -105,3 +127,66 @@ func deletePassengerHandler(serviceStatus *ServiceData) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+func signupPassengerHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var credentials model.PassengerCredentials
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if credentials.Email == "" || len(credentials.Password) < minPasswordLength {
+			http.Error(w, "email and a password of at least 8 characters are required", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		passenger := &model.Passenger{Name: credentials.Name, Email: credentials.Email}
+		err := serviceData.PGDB.SignupPassenger(ctx, passenger, credentials.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(passenger)
+	}
+}
+
+func loginPassengerHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var credentials model.PassengerCredentials
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		passenger, err := serviceData.PGDB.AuthenticatePassenger(ctx, credentials.Email, credentials.Password)
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		token, err := serviceData.Authenticator.GeneratePassengerJWT(strconv.Itoa(passenger.ID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(model.TokenResponse{Token: token})
+	}
+}
+
+// isCallingPassenger returns true when the authenticated passenger is the one identified by id
+func isCallingPassenger(r *http.Request, id string) bool {
+	claims := middleware.PassengerClaimsFromContext(r.Context())
+	return claims != nil && claims.PassengerID == id
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/service"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// driverTrackingInterval is how often the position of the assigned driver is pushed to the passenger
+	driverTrackingInterval = 3 * time.Second
+	// passengerWriteWait is the time allowed to write a message to the passenger
+	passengerWriteWait = 10 * time.Second
+	// passengerPongWait is the time allowed to read the next pong from the passenger
+	passengerPongWait = 60 * time.Second
+	// passengerPingPeriod is how often the passenger is pinged, it must be shorter than passengerPongWait
+	passengerPingPeriod = (passengerPongWait * 9) / 10
+	// passengerMaxMessageSize is the largest message accepted from a passenger
+	passengerMaxMessageSize = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// passengerSession is a connected passenger, it keeps the rides that are being tracked so the position of
+// the assigned driver can be pushed while the ride is active
+type passengerSession struct {
+	passengerID string
+	out         chan []byte
+
+	mu    sync.Mutex
+	rides map[int]*model.Ride
+}
+
+func newPassengerSession(passengerID string) *passengerSession {
+	return &passengerSession{
+		passengerID: passengerID,
+		out:         make(chan []byte, 256),
+		rides:       make(map[int]*model.Ride),
+	}
+}
+
+// send queues a message for the passenger, if the passenger is not reading fast enough the message is dropped
+// so a slow client never blocks the event dispatching
+func (s *passengerSession) send(payload []byte) {
+	select {
+	case s.out <- payload:
+	default:
+		log.Printf("passenger %s: dropping message, output buffer full\n", s.passengerID)
+	}
+}
+
+// trackRide keeps the last known state of a ride, rides that are not active anymore are not tracked
+func (s *passengerSession) trackRide(ride *model.Ride) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if isTrackedStatus(ride.Status) {
+		s.rides[ride.ID] = ride
+	} else {
+		delete(s.rides, ride.ID)
+	}
+}
+
+func (s *passengerSession) trackedRides() []*model.Ride {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rides := make([]*model.Ride, 0, len(s.rides))
+	for _, ride := range s.rides {
+		rides = append(rides, ride)
+	}
+	return rides
+}
+
+// isTrackedStatus returns true for the statuses where the passenger follows the driver
+func isTrackedStatus(status model.RideStatus) bool {
+	switch status {
+	case model.RideStatusDriverAccepted, model.RideStatusPickingUp, model.RideStatusInTransit:
+		return true
+	default:
+		return false
+	}
+}
+
+// passengerHub keeps the connected sessions of every passenger, a passenger may be connected from more than
+// one device
+type passengerHub struct {
+	mu       sync.RWMutex
+	sessions map[string]map[*passengerSession]bool
+}
+
+func newPassengerHub() *passengerHub {
+	return &passengerHub{sessions: make(map[string]map[*passengerSession]bool)}
+}
+
+func (h *passengerHub) register(s *passengerSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[s.passengerID] == nil {
+		h.sessions[s.passengerID] = make(map[*passengerSession]bool)
+	}
+	h.sessions[s.passengerID][s] = true
+}
+
+func (h *passengerHub) unregister(s *passengerSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions[s.passengerID], s)
+	if len(h.sessions[s.passengerID]) == 0 {
+		delete(h.sessions, s.passengerID)
+	}
+}
+
+// connected returns true when the passenger has a session in this instance
+func (h *passengerHub) connected(passengerID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.sessions[strconv.Itoa(passengerID)]) > 0
+}
+
+// publishRideEvent pushes the ride of the event to every session of the passenger owning the ride with the
+// pickup PIN, if any, and the event when it does not change the status
+func (h *passengerHub) publishRideEvent(outbox *model.RideOutbox, pin string) {
+	ride := outbox.Ride
+	msg := rideStatusMessage(ride, pin)
+	if outbox.Status.Event() {
+		msg.Event, msg.StopSeq = outbox.Status, outbox.StopSeq
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("marshal ride status:", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.sessions[strconv.Itoa(ride.PassengerID)] {
+		s.trackRide(ride)
+		s.send(payload)
+	}
+}
+
+func rideStatusMessage(ride *model.Ride, pin string) model.PassengerRideStatusResponse {
+	msg := model.PassengerRideStatusResponse{
+		Ride:      ride,
+		PickupPIN: pin,
+	}
+	msg.Type = model.PassengerRideStatusMsgType
+	return msg
+}
+
+// awaitsPickup returns true for the statuses where the driver is matched and the passenger is not on board,
+// the passenger needs the PIN meanwhile
+func awaitsPickup(status model.RideStatus) bool {
+	return status == model.RideStatusDriverAccepted || status == model.RideStatusPickingUp
+}
+
+// pickupPIN returns the PIN the passenger shows to the driver of the ride, empty when the ride is not waiting
+// for the pickup. The ride events never carry it, it is read from the PIN store.
+func pickupPIN(ctx context.Context, ride *model.Ride, serviceData *ServiceData) string {
+	if !awaitsPickup(ride.Status) {
+		return ""
+	}
+	pin, err := serviceData.PickupPINs.PickupPIN(ctx, ride.ID)
+	if err != nil {
+		log.Printf("pickup PIN of ride %d: %v\n", ride.ID, err)
+	}
+	return pin
+}
+
+// consumeRideEvents reads the ride events produced by the ride service and dispatches them to the
+// connected passengers
+func consumeRideEvents(ctx context.Context, serviceData *ServiceData) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-serviceData.Consumer.Messages():
+			if !ok {
+				return
+			}
+			var outbox model.RideOutbox
+			if err := json.Unmarshal(msg.Value, &outbox); err != nil {
+				log.Println("unmarshal ride event:", err)
+				continue
+			}
+			// Every instance gets every event, only the one with the passenger connected reads the PIN
+			if outbox.Ride == nil || !serviceData.Hub.connected(outbox.Ride.PassengerID) {
+				continue
+			}
+			serviceData.Hub.publishRideEvent(&outbox, pickupPIN(ctx, outbox.Ride, serviceData))
+		}
+	}
+}
+
+func handlePassengerConnections(w http.ResponseWriter, r *http.Request, serviceData *ServiceData) {
+	tokenString := r.Header.Get("Authorization")
+	if tokenString == "" {
+		http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := serviceData.Authenticator.ValidatePassengerJWT(tokenString)
+	if err != nil {
+		http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied to the client
+		log.Println("upgrade:", err)
+		return
+	}
+	defer ws.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	session := newPassengerSession(claims.PassengerID)
+	serviceData.Hub.register(session)
+	defer serviceData.Hub.unregister(session)
+
+	go passengerWriteLoop(ctx, cancel, ws, session)
+	go trackDrivers(ctx, session, serviceData)
+	sendActiveRides(ctx, session, serviceData)
+
+	ws.SetReadLimit(passengerMaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(passengerPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(passengerPongWait))
+	})
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("read:", err)
+			}
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(passengerPongWait))
+
+		var baseMessage model.PassengerBaseMessage
+		if err := json.Unmarshal(msg, &baseMessage); err != nil {
+			sendPassengerError(session, "", 400, "invalid message")
+			continue
+		}
+
+		switch baseMessage.Type {
+		case model.PassengerCancelRideMsgType:
+			var req model.PassengerCancelRideRequest
+			if err := json.Unmarshal(msg, &req); err != nil {
+				sendPassengerError(session, baseMessage.Type, 400, err.Error())
+				continue
+			}
+			handlePassengerCancelRide(ctx, session, req, serviceData)
+		default:
+			sendPassengerError(session, baseMessage.Type, 400, "unknown message type")
+		}
+	}
+}
+
+// sendActiveRides sends the rides of the passenger that did not end when it connects, so a passenger that
+// reconnects does not wait for the next change to know the status of its rides
+func sendActiveRides(ctx context.Context, session *passengerSession, serviceData *ServiceData) {
+	passengerID, err := strconv.Atoi(session.passengerID)
+	if err != nil {
+		return
+	}
+	rides, err := serviceData.Rides.ListPassengerRides(ctx, passengerID)
+	if err != nil {
+		log.Printf("passenger %s: list rides: %v\n", session.passengerID, err)
+		return
+	}
+	for i := range rides {
+		ride := &rides[i]
+		if ride.Status.Ended() {
+			continue
+		}
+		payload, err := json.Marshal(rideStatusMessage(ride, pickupPIN(ctx, ride, serviceData)))
+		if err != nil {
+			log.Println("marshal ride status:", err)
+			continue
+		}
+		session.trackRide(ride)
+		session.send(payload)
+	}
+}
+
+// passengerWriteLoop writes the queued messages and the pings to the socket, it is the only writer of the
+// socket so it never waits on the backends. Any write error closes the session.
+func passengerWriteLoop(ctx context.Context, cancel context.CancelFunc, ws *websocket.Conn, session *passengerSession) {
+	defer cancel()
+	ping := time.NewTicker(passengerPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(passengerWriteWait))
+			return
+		case payload := <-session.out:
+			ws.SetWriteDeadline(time.Now().Add(passengerWriteWait))
+			if err := ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+				log.Println("write:", err)
+				ws.Close()
+				return
+			}
+		case <-ping.C:
+			ws.SetWriteDeadline(time.Now().Add(passengerWriteWait))
+			if err := ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("ping:", err)
+				ws.Close()
+				return
+			}
+		}
+	}
+}
+
+// trackDrivers periodically queues the position of the drivers of the tracked rides until the session ends,
+// the location and ETA lookups run here so a slow backend does not delay the writes of the session
+func trackDrivers(ctx context.Context, session *passengerSession, serviceData *ServiceData) {
+	ticker := time.NewTicker(driverTrackingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, ride := range session.trackedRides() {
+				pushDriverLocation(ctx, session, ride, serviceData)
+			}
+		}
+	}
+}
+
+// pushDriverLocation sends the position of the driver of the ride and the ETA to the next point of the
+// ride, the pickup until the passenger is on board and the destination afterwards
+func pushDriverLocation(ctx context.Context, session *passengerSession, ride *model.Ride, serviceData *ServiceData) {
+	if ride.DriverID == nil {
+		return
+	}
+	lat, lon, err := serviceData.GeoService.GetDriverLocation(ctx, strconv.Itoa(*ride.DriverID))
+	if err != nil {
+		log.Println("GetDriverLocation:", err)
+		return
+	}
+
+	target := model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}
+	if ride.Status == model.RideStatusInTransit {
+		target = model.Coordinate{Lat: ride.DstLat, Lon: ride.DstLon}
+	}
+	estimate, err := serviceData.ETA.Estimate(model.Coordinate{Lat: lat, Lon: lon}, target, time.Now())
+	if err != nil {
+		log.Println("estimate ETA:", err)
+		return
+	}
+
+	msg := model.PassengerDriverLocationResponse{
+		RideID:     ride.ID,
+		DriverID:   *ride.DriverID,
+		Latitude:   lat,
+		Longitude:  lon,
+		ETASeconds: estimate.Seconds(),
+	}
+	msg.Type = model.PassengerDriverLocationMsgType
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("marshal driver location:", err)
+		return
+	}
+	session.send(payload)
+}
+
+func handlePassengerCancelRide(ctx context.Context, session *passengerSession, req model.PassengerCancelRideRequest, serviceData *ServiceData) {
+	ride, err := serviceData.Rides.GetRide(ctx, req.RideID)
+	if err != nil {
+		sendPassengerError(session, model.PassengerCancelRideMsgType, 404, err.Error())
+		return
+	}
+	// Passengers can only cancel their own rides
+	if strconv.Itoa(ride.PassengerID) != session.passengerID {
+		sendPassengerError(session, model.PassengerCancelRideMsgType, 403, "ride not owned by passenger")
+		return
+	}
+	err = serviceData.Rides.CancelRide(ctx, ride)
+	if errors.Is(err, service.ErrInvalidRideStatus) {
+		sendPassengerError(session, model.PassengerCancelRideMsgType, 409, "ride can't be cancelled in status "+string(ride.Status))
+		return
+	}
+	if err != nil {
+		sendPassengerError(session, model.PassengerCancelRideMsgType, 500, err.Error())
+		return
+	}
+	// The status change reaches the passenger through the ride events
+}
+
+// sendPassengerError builds a PassengerErrorResponse for the original message type and queues it
+func sendPassengerError(session *passengerSession, originalType model.PassengerMsgType, code int, reason string) {
+	errMsg := model.PassengerErrorResponse{
+		OriginalMessageType: originalType,
+		Code:                code,
+		Reason:              reason,
+	}
+	errMsg.Type = model.PassengerErrorResponseMsgType
+	payload, err := json.Marshal(errMsg)
+	if err != nil {
+		log.Println("marshal error response:", err)
+		return
+	}
+	session.send(payload)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/OscarMoya/Glubber/pkg/middleware"
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// callerPassengerID returns the ID of the passenger calling the endpoint, false if the caller is not a passenger
+func callerPassengerID(r *http.Request) (int, bool) {
+	claims := middleware.PassengerClaimsFromContext(r.Context())
+	if claims == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(claims.PassengerID)
+	return id, err == nil
+}
+
+// callerDriverID returns the ID of the driver calling the endpoint, false if the caller is not a driver
+func callerDriverID(r *http.Request) (int, bool) {
+	claims := middleware.DriverClaimsFromContext(r.Context())
+	if claims == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(claims.DriverID)
+	return id, err == nil
+}
+
+// isRidePassenger returns true when the caller is the passenger that requested the ride
+func isRidePassenger(r *http.Request, ride *model.Ride) bool {
+	id, ok := callerPassengerID(r)
+	return ok && ride.PassengerID == id
+}
+
+// isRideDriver returns true when the caller is the driver assigned to the ride
+func isRideDriver(r *http.Request, ride *model.Ride) bool {
+	id, ok := callerDriverID(r)
+	return ok && ride.DriverID != nil && *ride.DriverID == id
+}
+
+// getAccessibleRide loads a ride and checks that the caller is allowed to access it. Rides not owned by the
+// caller are reported as not found so their existence is not disclosed.
+func getAccessibleRide(w http.ResponseWriter, r *http.Request, serviceData *ServiceData, id int, allowPassenger, allowDriver bool) (*model.Ride, bool) {
+	ride, err := serviceData.PGDB.GetRide(r.Context(), id)
+	if err != nil {
+		http.Error(w, "ride not found", http.StatusNotFound)
+		return nil, false
+	}
+	if (allowPassenger && isRidePassenger(r, ride)) || (allowDriver && isRideDriver(r, ride)) {
+		return ride, true
+	}
+	http.Error(w, "ride not found", http.StatusNotFound)
+	return nil, false
+}
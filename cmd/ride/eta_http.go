@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/eta"
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// nearbyDriversRadius is the radius in kilometers of the drivers considered for the pickup ETA
+const nearbyDriversRadius = 5.0
+
+// estimateRideETA fills the trip and pickup ETA of the ride, the ride is still valid without them so the
+// failures are only logged
+func estimateRideETA(ctx context.Context, serviceData *ServiceData, ride *model.Ride) {
+	err := eta.EstimateRide(ctx, serviceData.ETA, serviceData.GeoService, ride, nearbyDriversRadius, time.Now())
+	if err != nil {
+		log.Printf("estimate ETA of ride %d: %v\n", ride.ID, err)
+	}
+}
+
+// nearbyDriversHandler returns the drivers around the lat and lon query parameters with their time to reach
+// the point, the closest first. The class parameter filters the drivers by vehicle class. The passengers only
+// get the time and a coarse position of every driver, see model.NearbyDriverView.
+func nearbyDriversHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := callerPassengerID(r); !ok {
+			http.Error(w, "only passengers can look for drivers", http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+		if err != nil {
+			http.Error(w, "invalid lat: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+		if err != nil {
+			http.Error(w, "invalid lon: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		class := query.Get("class")
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		pickup := model.Coordinate{Lat: lat, Lon: lon}
+		drivers, err := eta.NearbyDrivers(ctx, serviceData.GeoService, serviceData.ETA, pickup, nearbyDriversRadius, class, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views := make([]model.NearbyDriverView, 0, len(drivers))
+		for _, driver := range drivers {
+			views = append(views, driver.View())
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(views)
+	}
+}
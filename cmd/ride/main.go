@@ -4,11 +4,19 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/OscarMoya/Glubber/pkg/authentication"
 	"github.com/OscarMoya/Glubber/pkg/billing"
+	"github.com/OscarMoya/Glubber/pkg/eta"
+	"github.com/OscarMoya/Glubber/pkg/location"
+	"github.com/OscarMoya/Glubber/pkg/middleware"
 	"github.com/OscarMoya/Glubber/pkg/queue"
 	"github.com/OscarMoya/Glubber/pkg/repository"
+	"github.com/OscarMoya/Glubber/pkg/routing"
 	"github.com/OscarMoya/Glubber/pkg/service"
+	"github.com/OscarMoya/Glubber/pkg/zones"
 	"github.com/gorilla/mux"
 )
 
@@ -18,12 +26,32 @@ const (
 	serveURL    = "localhost:8083"
 	rideWSURI   = "ws/v1/ride"
 	rideHTTPUri = "v1/rides"
+	zoneHTTPUri = "v1/zones"
+
+	// zonesReloadInterval is how often the zones edited by other instances are loaded
+	zonesReloadInterval = time.Minute
+)
+
+// ridePolicy is the permission required by the ride routes, the routes without rule are open to the
+// participants of the rides and the handlers check the ownership
+var ridePolicy = middleware.NewPolicy(
+	middleware.Rule{Method: "GET", Path: rideHTTPUri + "/all", Permission: authentication.PermRidesListAll},
+	middleware.Rule{Method: "POST", Path: zoneHTTPUri, Permission: authentication.PermZonesWrite},
+	middleware.Rule{Method: "DELETE", Path: zoneHTTPUri + "/{id}", Permission: authentication.PermZonesWrite},
 )
 
 // ServiceData is the struct that holds the database connection
 type ServiceData struct {
 	PGDB   *service.RideService
 	Biller billing.Biller
+	// ETA estimates the rides and GeoService finds the drivers for the pickup ETA
+	ETA        eta.Estimator
+	GeoService location.LocationManager
+	// Zones are the service areas the rides are checked against
+	Zones *zones.Registry
+
+	PassengerAuthenticator authentication.PassengerAuthenticator
+	DriverAuthenticator    authentication.DriverAuthenticator
 }
 
 func main() {
@@ -38,7 +66,7 @@ func main() {
 	}
 	defer producer.Close()
 
-	biller := billing.NewSimpleBiller(2.0, 1.0)
+	biller := billing.NewClassBiller(billing.DefaultTariff, billing.ClassTariffs)
 
 	// Create a new service
 	riderOpts := service.RideServiceOpts{
@@ -48,26 +76,102 @@ func main() {
 		Table:       "rides",
 		DriverTopic: "drivers",
 		DriverKey:   "driver",
+		// Ride changes are pushed to the passengers through the passenger service
+		PassengerTopic: "passengers",
+
+		RequirePickupPIN: true,
+		PickupPINs:       service.NewRedisPickupPINStore("localhost:6379", service.DefaultPickupPINTTL),
 	}
 
 	pgdb, err := service.NewRideService(context.Background(), riderOpts)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Start the outbox notifications and the scheduled rides workers
+	err = pgdb.Start(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The zones are loaded before serving, without them every ride would be out of the service area
+	registry := zones.NewRegistry(pgdb)
+	err = registry.Reload(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	go registry.Run(context.Background(), zonesReloadInterval)
+	biller.WithZones(registry)
+
 	serviceData := &ServiceData{}
 	serviceData.PGDB = pgdb
+	serviceData.Biller = biller
+	serviceData.Zones = registry
+	// ETA_MODEL_FILE is a model trained with etatrain, the baseline estimator is used without it
+	estimator, err := eta.LoadEstimator(os.Getenv("ETA_MODEL_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// ROADS_OSM_FILE is an OSM extract of the region, PBF or XML. Without it the rides are billed and
+	// estimated on the straight line.
+	if path := os.Getenv("ROADS_OSM_FILE"); path != "" {
+		engine, err := routing.LoadEngine(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		biller.WithRouter(engine)
+		estimator = eta.NewRouted(engine, estimator)
+	}
+	serviceData.ETA = estimator
+	serviceData.GeoService = location.NewRedisLocationService("localhost:6379")
+	passengerAuthenticator, err := authentication.NewJWTPassengerAuthenticationServiceFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serviceData.PassengerAuthenticator = passengerAuthenticator
+	// The driver tokens are validated with the same DRIVER_JWT_* keys the driver service signs them with
+	driverAuthenticator, err := authentication.NewJWTDriverAuthenticationServiceFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	driverAuthenticator.Denylist = authentication.NewRedisTokenDenylist("localhost:6379")
+	serviceData.DriverAuthenticator = driverAuthenticator
+
+	// Every ride endpoint requires a passenger, driver or staff token, the handlers check the ownership of the
+	// rides and the staff routes are listed in ridePolicy
+	staffAuthenticator, err := authentication.NewJWTStaffAuthenticationServiceFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	authenticated := func(h http.HandlerFunc) http.Handler {
+		return middleware.StaffMiddleware(middleware.RideParticipantMiddleware(middleware.AuthorizationMiddleware(h, ridePolicy), serviceData.PassengerAuthenticator, serviceData.DriverAuthenticator), staffAuthenticator)
+	}
 
 	r := mux.NewRouter()
 
 	// HTTP Handlers
-	r.HandleFunc(rideHTTPUri, createRideHandler(serviceData)).Methods("POST")
-	r.HandleFunc(rideHTTPUri, listRidesHandler(serviceData)).Methods("GET")
-	r.HandleFunc(rideHTTPUri+"/{id}", getRideHandler(serviceData)).Methods("GET")
-	r.HandleFunc(rideHTTPUri+"/{id}", updateRideHandler(serviceData)).Methods("PUT")
-	r.HandleFunc(rideHTTPUri+"/{id}", deleteRideHandler(serviceData)).Methods("DELETE")
+	r.Handle(rideHTTPUri, authenticated(createRideHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri, authenticated(listRidesHandler(serviceData))).Methods("GET")
+	r.Handle(rideHTTPUri+"/all", authenticated(listAllRidesHandler(serviceData))).Methods("GET")
+	r.Handle(rideHTTPUri+"/nearby_drivers", authenticated(nearbyDriversHandler(serviceData))).Methods("GET")
+	// Scheduled routes go before the {id} ones so "scheduled" is not matched as an ID
+	r.Handle(rideHTTPUri+"/scheduled", authenticated(listScheduledRidesHandler(serviceData))).Methods("GET")
+	r.Handle(rideHTTPUri+"/scheduled/{id}", authenticated(cancelScheduledRideHandler(serviceData))).Methods("DELETE")
+	r.Handle(rideHTTPUri+"/{id}", authenticated(getRideHandler(serviceData))).Methods("GET")
+	r.Handle(rideHTTPUri+"/{id}", authenticated(updateRideHandler(serviceData))).Methods("PUT")
+	r.Handle(rideHTTPUri+"/{id}", authenticated(deleteRideHandler(serviceData))).Methods("DELETE")
+	r.Handle(rideHTTPUri+"/{id}/accept", authenticated(acceptRideHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri+"/{id}/arrived", authenticated(driverArrivedHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri+"/{id}/start", authenticated(startRideHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri+"/{id}/complete", authenticated(completeRideHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri+"/{id}/stops/{seq}/arrived", authenticated(stopArrivedHandler(serviceData))).Methods("POST")
+	r.Handle(rideHTTPUri+"/{id}/stops/{seq}/departed", authenticated(stopDepartedHandler(serviceData))).Methods("POST")
+	r.Handle(zoneHTTPUri, authenticated(createZoneHandler(serviceData))).Methods("POST")
+	r.Handle(zoneHTTPUri, authenticated(listZonesHandler(serviceData))).Methods("GET")
+	r.Handle(zoneHTTPUri+"/{id}", authenticated(getZoneHandler(serviceData))).Methods("GET")
+	r.Handle(zoneHTTPUri+"/{id}", authenticated(deleteZoneHandler(serviceData))).Methods("DELETE")
 
 	log.Printf("HTTP server started on %s\n", serveURL)
-	err = http.ListenAndServe(serveURL, nil)
+	err = http.ListenAndServe(serveURL, r)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
@@ -3,23 +3,59 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/middleware"
 	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/service"
 	"github.com/gorilla/mux"
 )
 
 func createRideHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		passengerID, ok := callerPassengerID(r)
+		if !ok {
+			http.Error(w, "only passengers can request rides", http.StatusForbidden)
+			return
+		}
 		var ride model.Ride
 		if err := json.NewDecoder(r.Body).Decode(&ride); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// The ride always belongs to the caller and starts without driver
+		ride.PassengerID = passengerID
+		ride.DriverID = nil
+		// The pool is chosen by the dispatch, the quoted price is the solo one and no pooled passenger pays more
+		ride.PoolID = nil
+		if ride.Pooled && len(ride.Stops) > 0 {
+			http.Error(w, "pooled rides can't have intermediate stops", http.StatusBadRequest)
+			return
+		}
+		// The pickup and the dropoff must be in the service area, airports only allow their pickup points
+		if err := serviceData.Zones.ValidateRide(&ride); err != nil {
+			writeZoneError(w, err)
+			return
+		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
+		// Rides booked for later are estimated and stored by the scheduler flow
+		if ride.ScheduledAt != nil {
+			err := serviceData.PGDB.ScheduleRide(ctx, &ride)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			estimateRideETA(ctx, serviceData, &ride)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ride)
+			return
+		}
+
 		ride.Status = model.RideStatusPending
 		err := serviceData.Biller.EstimateRide(&ride)
 		if err != nil {
@@ -32,6 +68,7 @@ func createRideHandler(serviceData *ServiceData) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		estimateRideETA(ctx, serviceData, &ride)
 
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(ride)
@@ -43,7 +80,17 @@ func listRidesHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
-		Rides, err := serviceData.PGDB.ListRides(ctx)
+		// Callers only see their own rides, as passenger or as assigned driver
+		var Rides []model.Ride
+		var err error
+		if passengerID, ok := callerPassengerID(r); ok {
+			Rides, err = serviceData.PGDB.ListPassengerRides(ctx, passengerID)
+		} else if driverID, ok := callerDriverID(r); ok {
+			Rides, err = serviceData.PGDB.ListDriverRides(ctx, driverID)
+		} else {
+			http.Error(w, "unknown caller", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -53,7 +100,41 @@ func listRidesHandler(serviceData *ServiceData) http.HandlerFunc {
 	}
 }
 
-func getRideHandler(serviceData *ServiceData) http.HandlerFunc {
+// listAllRidesHandler lists the rides of every passenger, the route is restricted to admins by ridePolicy
+func listAllRidesHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		rides, err := serviceData.PGDB.ListRides(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rides)
+	}
+}
+
+func listScheduledRidesHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		passengerID, ok := callerPassengerID(r)
+		if !ok {
+			http.Error(w, "only passengers have scheduled rides", http.StatusForbidden)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		rides, err := serviceData.PGDB.ListScheduledRides(ctx, passengerID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rides)
+	}
+}
+
+func cancelScheduledRideHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 		idInt, err := strconv.Atoi(id)
@@ -61,40 +142,106 @@ func getRideHandler(serviceData *ServiceData) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if _, ok := getAccessibleRide(w, r, serviceData, idInt, true, false); !ok {
+			return
+		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
-		Ride, err := serviceData.PGDB.GetRide(ctx, idInt)
+		err = serviceData.PGDB.CancelScheduledRide(ctx, idInt)
+		if errors.Is(err, service.ErrInvalidRideStatus) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getRideHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Ride, ok := getAccessibleRide(w, r, serviceData, idInt, true, true)
+		if !ok {
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(Ride)
 
 	}
 }
 
+// updateRideHandler lets the passenger change the destination of the ride and, until a driver is matched,
+// the pickup and the vehicle. The destination of a pooled ride is fixed once it has a driver, the pool was
+// built with it. The price is estimated again and the new route must be in the service area, the status and
+// the rest of the ride only change through their own endpoints.
 func updateRideHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var Ride model.Ride
-		if err := json.NewDecoder(r.Body).Decode(&Ride); err != nil {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		var update model.RideUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ride, ok := getAccessibleRide(w, r, serviceData, idInt, true, false)
+		if !ok {
+			return
+		}
+		if ride.Status.Ended() {
+			http.Error(w, "the ride has ended", http.StatusConflict)
+			return
+		}
+		if ride.DriverID != nil && update.ChangesMatch() {
+			http.Error(w, "the pickup and the vehicle can't change once a driver is matched", http.StatusConflict)
+			return
+		}
+		if ride.DriverID != nil && ride.Pooled && (update.DstLat != nil || update.DstLon != nil) {
+			http.Error(w, "the destination of a pooled ride can't change once a driver is matched", http.StatusConflict)
+			return
+		}
+		status := ride.Status
+		update.Apply(ride)
+		if err := serviceData.Zones.ValidateRide(ride); err != nil {
+			writeZoneError(w, err)
+			return
+		}
+		if err := serviceData.Biller.EstimateRide(ride); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		err := serviceData.PGDB.UpdateRide(ctx, &Ride)
+		// The ride is only written if nothing changed its status since it was read
+		err = serviceData.PGDB.UpdateRideRoute(ctx, ride, status)
+		if errors.Is(err, service.ErrInvalidRideStatus) {
+			http.Error(w, "the ride changed meanwhile, read it again", http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(Ride)
+		json.NewEncoder(w).Encode(ride)
 	}
 }
 
+// deleteRideHandler cancels the ride on behalf of its passenger while it can be cancelled, the driver is told
+// through the ride event. Only the staff removes the rides.
 func deleteRideHandler(serviceData *ServiceData) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
@@ -105,11 +252,167 @@ func deleteRideHandler(serviceData *ServiceData) http.HandlerFunc {
 		}
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
-		err = serviceData.PGDB.DeleteRide(ctx, idInt)
+		if middleware.CallerHasPermission(r, authentication.PermRidesDelete) {
+			err = serviceData.PGDB.DeleteRide(ctx, idInt)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		ride, ok := getAccessibleRide(w, r, serviceData, idInt, true, false)
+		if !ok {
+			return
+		}
+		err = serviceData.PGDB.CancelRide(ctx, ride)
+		if errors.Is(err, service.ErrInvalidRideStatus) {
+			http.Error(w, "the ride can't be cancelled anymore", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func startRideHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req model.StartRideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Only the assigned driver starts the ride
+		ride, ok := getAccessibleRide(w, r, serviceData, idInt, false, true)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		err = serviceData.PGDB.StartRide(ctx, ride, req.PIN)
+		if err != nil {
+			http.Error(w, err.Error(), startRideErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ride)
+	}
+}
+
+// acceptRideHandler accepts the quote of a ride on behalf of its passenger, the ride is offered to the drivers
+func acceptRideHandler(serviceData *ServiceData) http.HandlerFunc {
+	return rideTransitionHandler(serviceData, true, false, serviceData.PGDB.AcceptRide)
+}
+
+// driverArrivedHandler tells the passenger that the driver is waiting at the pickup
+func driverArrivedHandler(serviceData *ServiceData) http.HandlerFunc {
+	return rideTransitionHandler(serviceData, false, true, serviceData.PGDB.DriverArrived)
+}
+
+// completeRideHandler ends the ride once the passenger is dropped off
+func completeRideHandler(serviceData *ServiceData) http.HandlerFunc {
+	return rideTransitionHandler(serviceData, false, true, serviceData.PGDB.CompleteRide)
+}
+
+// rideTransitionHandler moves a ride of the caller to its next status, the transition returns
+// ErrInvalidRideStatus when the ride is not in the status it moves from
+func rideTransitionHandler(serviceData *ServiceData, allowPassenger, allowDriver bool, transition func(ctx context.Context, ride *model.Ride) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idInt, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ride, ok := getAccessibleRide(w, r, serviceData, idInt, allowPassenger, allowDriver)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		err = transition(ctx, ride)
+		if errors.Is(err, service.ErrInvalidRideStatus) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ride)
+	}
+}
+
+// startRideErrorStatus maps the errors returned by StartRide to HTTP status codes
+func startRideErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidRideStatus):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidPickupPIN):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrPickupPINLocked):
+		return http.StatusLocked
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// stopEventErrorStatus maps the errors returned when recording a stop event to HTTP status codes
+func stopEventErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrStopNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidRideStatus), errors.Is(err, service.ErrStopOutOfOrder):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func stopArrivedHandler(serviceData *ServiceData) http.HandlerFunc {
+	return stopEventHandler(serviceData, serviceData.PGDB.StopArrived)
+}
+
+func stopDepartedHandler(serviceData *ServiceData) http.HandlerFunc {
+	return stopEventHandler(serviceData, serviceData.PGDB.StopDeparted)
+}
+
+// stopEventHandler parses the ride ID and stop sequence and records the stop event, only the assigned
+// driver can record them
+func stopEventHandler(serviceData *ServiceData, record func(ctx context.Context, rideID, seq int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idInt, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		seqInt, err := strconv.Atoi(mux.Vars(r)["seq"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := getAccessibleRide(w, r, serviceData, idInt, false, true); !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		err = record(ctx, idInt, seqInt)
+		if err != nil {
+			http.Error(w, err.Error(), stopEventErrorStatus(err))
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
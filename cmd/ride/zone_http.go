@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/service"
+	"github.com/OscarMoya/Glubber/pkg/zones"
+	"github.com/gorilla/mux"
+)
+
+// restrictedPickupResponse tells the passenger where the pickup of a restricted zone must be moved to
+type restrictedPickupResponse struct {
+	Error        string             `json:"error"`
+	Zone         string             `json:"zone"`
+	PickupPoints []model.Coordinate `json:"pickup_points"`
+}
+
+// writeZoneError answers the rides rejected by the service areas with a 422, the pickups in restricted zones
+// get the designated pickup points in the body
+func writeZoneError(w http.ResponseWriter, err error) {
+	var restricted *zones.RestrictedPickupError
+	if errors.As(err, &restricted) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(restrictedPickupResponse{
+			Error:        err.Error(),
+			Zone:         restricted.Zone,
+			PickupPoints: restricted.PickupPoints,
+		})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+}
+
+// reloadZones applies the changes of the zones to the running registry, the periodic reload retries it
+func reloadZones(ctx context.Context, serviceData *ServiceData) {
+	if err := serviceData.Zones.Reload(ctx); err != nil {
+		log.Println("reload zones:", err)
+	}
+}
+
+func createZoneHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var zone model.Zone
+		if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		err := serviceData.PGDB.CreateZone(ctx, &zone)
+		if errors.Is(err, zones.ErrInvalidZone) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reloadZones(ctx, serviceData)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(zone)
+	}
+}
+
+// listZonesHandler returns the stored zones, the region query parameter filters them
+func listZonesHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		result, err := serviceData.PGDB.ListZones(ctx, r.URL.Query().Get("region"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func getZoneHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		zone, err := serviceData.PGDB.GetZone(ctx, id)
+		if errors.Is(err, service.ErrZoneNotFound) {
+			http.Error(w, "zone not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(zone)
+	}
+}
+
+func deleteZoneHandler(serviceData *ServiceData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		err = serviceData.PGDB.DeleteZone(ctx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reloadZones(ctx, serviceData)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
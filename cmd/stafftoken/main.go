@@ -0,0 +1,36 @@
+// stafftoken issues the tokens of the admin and ops staff. It signs them with the staff keys of the
+// STAFF_JWT_* variables, so only the holders of those keys can grant the staff roles. The token is printed
+// to the standard output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+)
+
+func main() {
+	staffID := flag.String("id", "", "ID of the staff member, it goes in the subject of the token")
+	roles := flag.String("roles", string(authentication.RoleOps), "comma separated staff roles: admin, ops")
+	flag.Parse()
+
+	var staffRoles []authentication.Role
+	for _, role := range strings.Split(*roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			staffRoles = append(staffRoles, authentication.Role(role))
+		}
+	}
+
+	authenticator, err := authentication.NewJWTStaffAuthenticationServiceFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, err := authenticator.GenerateStaffJWT(*staffID, staffRoles...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(token)
+}
@@ -1,6 +1,7 @@
 // Package: authentication defines authentication lifecycle of the service
-// for now it only provides basic JWT lifecycle for drivers without checking
-// anything in the driver db.
+// it provides the JWT lifecycle for drivers and passengers. The tokens are trusted
+// without checking the driver db, the driver service checks the status of the
+// driver when it opens a session.
 
 // Having this, showcases how the system can still process messages with a certain
 // level of trust, without having to implement all the boilerplate for the authentication
@@ -13,9 +14,11 @@ import "github.com/dgrijalva/jwt-go"
 // This struct will be used to generate and validate the JWT
 // Even if there are some repeated fieds in the standard claims, it is better to have them
 // in the struct to avoid any confusion or if the claims need to be extended in the future
+// The ID of the token goes in the jti claim of the StandardClaims so the token can be revoked
 type DriverClaims struct {
 	DriverID  string `json:"driver_id"`
 	Timestamp int64  `json:"timestamp"`
+	Roles     []Role `json:"roles,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -27,3 +30,17 @@ type DriverAuthenticator interface {
 	GenerateDriverJWT(driverID string) (string, error)
 	ValidateDriverJWT(tokenString string) (*DriverClaims, error)
 }
+
+// PassengerClaims is the struct that will be used to store the claims of the passenger JWT
+type PassengerClaims struct {
+	PassengerID string `json:"passenger_id"`
+	Timestamp   int64  `json:"timestamp"`
+	Roles       []Role `json:"roles,omitempty"`
+	jwt.StandardClaims
+}
+
+// PassengerAuthenticator is the interface that will be used to generate and validate the passenger JWT
+type PassengerAuthenticator interface {
+	GeneratePassengerJWT(passengerID string) (string, error)
+	ValidatePassengerJWT(tokenString string) (*PassengerClaims, error)
+}
@@ -0,0 +1,97 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// revokedTokenKeyPrefix prefixes the keys of the revoked token IDs
+	revokedTokenKeyPrefix = "driver_jwt_revoked:"
+	// driverRevokedAtKeyPrefix prefixes the keys holding when all the tokens of a driver were revoked
+	driverRevokedAtKeyPrefix = "driver_jwt_revoked_at:"
+	// maxDriverTokenLifetime is the longest lifetime of a driver token, revocations are kept this long
+	maxDriverTokenLifetime = 72 * time.Hour
+)
+
+var (
+	// ErrTokenRevoked is returned when validating a token that was revoked
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrRevocationCheckFailed is returned when the denylist can't be checked, the token is not trusted
+	ErrRevocationCheckFailed = errors.New("error checking token revocation")
+)
+
+// TokenDenylist keeps the revoked driver tokens until they expire. A single token is revoked by its ID (jti)
+// and all the tokens of a driver by revoking the ones issued before a point in time.
+type TokenDenylist interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	RevokeDriverTokens(ctx context.Context, driverID string, at time.Time) error
+	IsRevoked(ctx context.Context, claims *DriverClaims) (bool, error)
+}
+
+// RedisTokenDenylist keeps the denylist in Redis so every instance of the services sees the revocations
+type RedisTokenDenylist struct {
+	redisClient *redis.Client
+}
+
+// NewRedisTokenDenylist creates a new RedisTokenDenylist
+func NewRedisTokenDenylist(redisAddr string) *RedisTokenDenylist {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisTokenDenylist{redisClient: rdb}
+}
+
+// RevokeToken denies the token until it expires, tokens already expired are ignored
+func (d *RedisTokenDenylist) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if tokenID == "" || ttl <= 0 {
+		return nil
+	}
+	return d.redisClient.Set(ctx, revokedTokenKeyPrefix+tokenID, 1, ttl).Err()
+}
+
+// RevokeDriverTokens denies every token of the driver issued up to the given time
+func (d *RedisTokenDenylist) RevokeDriverTokens(ctx context.Context, driverID string, at time.Time) error {
+	return d.redisClient.Set(ctx, driverRevokedAtKeyPrefix+driverID, at.Unix(), maxDriverTokenLifetime).Err()
+}
+
+// IsRevoked checks the ID of the token and the last revocation of the driver
+func (d *RedisTokenDenylist) IsRevoked(ctx context.Context, claims *DriverClaims) (bool, error) {
+	if claims.Id != "" {
+		n, err := d.redisClient.Exists(ctx, revokedTokenKeyPrefix+claims.Id).Result()
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	if claims.DriverID == "" {
+		return false, nil
+	}
+	revokedAt, err := d.redisClient.Get(ctx, driverRevokedAtKeyPrefix+claims.DriverID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	at, err := strconv.ParseInt(revokedAt, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	// Tokens without issue time were issued before the revocations existed. The timestamps have second
+	// precision, so the tokens issued in the same second of the revocation are revoked too.
+	issuedAt := claims.IssuedAt
+	if issuedAt == 0 {
+		issuedAt = claims.Timestamp
+	}
+	return issuedAt <= at, nil
+}
@@ -1,47 +1,111 @@
 package authentication
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-// driverSigningKey is the secret key used to sign the driver JWT this will become the location of the key
-// via encrypted volume or the secret name via a secret manager. This can also be part of the struct later on
-const driverSigningKey = "my_secret123" // TODO: Change this to a more secure secret key
+// driverSigningKey is the development secret used to sign the driver JWT when no key is configured, the
+// deployed services load their keys with LoadKeySetFromEnv
+const driverSigningKey = "my_secret123"
 
+// DriverKeyEnvPrefix is the prefix of the environment variables holding the driver signing keys
+const DriverKeyEnvPrefix = "DRIVER_JWT"
+
+// denylistTimeout bounds the time spent checking the denylist when validating a token
+const denylistTimeout = 2 * time.Second
+
+// JWTDriverAuthenticationService signs the driver tokens with the current key of the keyset and validates
+// them against all its keys. The zero value uses the development secret. When Denylist is set the revoked
+// tokens are rejected.
 type JWTDriverAuthenticationService struct {
+	Keys     *KeySet
+	Denylist TokenDenylist
+}
+
+// NewJWTDriverAuthenticationService creates a new JWTDriverAuthenticationService using the keyset
+func NewJWTDriverAuthenticationService(keys *KeySet) *JWTDriverAuthenticationService {
+	return &JWTDriverAuthenticationService{Keys: keys}
+}
+
+// NewJWTDriverAuthenticationServiceFromEnv creates a new JWTDriverAuthenticationService with the keys
+// configured in the DRIVER_JWT_* environment variables
+func NewJWTDriverAuthenticationServiceFromEnv() (*JWTDriverAuthenticationService, error) {
+	keys, err := LoadKeySetFromEnv(DriverKeyEnvPrefix, []byte(driverSigningKey))
+	if err != nil {
+		return nil, err
+	}
+	return NewJWTDriverAuthenticationService(keys), nil
+}
+
+func (d *JWTDriverAuthenticationService) keySet() *KeySet {
+	if d.Keys == nil {
+		return &KeySet{Current: NewHMACKey(defaultKeyID, []byte(driverSigningKey))}
+	}
+	return d.Keys
+}
+
+// newTokenID returns a random ID for the jti claim, so a single token can be revoked
+func newTokenID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
 }
 
 func (d *JWTDriverAuthenticationService) GenerateDriverJWT(driverID string) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := DriverClaims{
 		DriverID:  driverID,
-		Timestamp: time.Now().Unix(),
+		Timestamp: now.Unix(),
+		Roles:     []Role{RoleDriver},
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
+			Id:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(maxDriverTokenLifetime).Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(driverSigningKey)
+	return d.keySet().Sign(claims)
 }
 
 func (d *JWTDriverAuthenticationService) ValidateDriverJWT(tokenString string) (*DriverClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return driverSigningKey, nil
-	})
-
+	// The claims need to be parsed into DriverClaims, jwt.Parse would produce MapClaims
+	token, err := d.keySet().Parse(tokenString, &DriverClaims{})
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*DriverClaims); ok && token.Valid {
-		return claims, nil
-	} else {
+	claims, ok := token.Claims.(*DriverClaims)
+	if !ok || !token.Valid || claims.DriverID == "" {
 		return nil, fmt.Errorf("invalid token")
 	}
+	if d.Denylist != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), denylistTimeout)
+		defer cancel()
+		// The denylist fails closed, a token can't be trusted if the revocations can't be checked
+		revoked, err := d.Denylist.IsRevoked(ctx, claims)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRevocationCheckFailed, err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys used to validate the driver tokens
+func (d *JWTDriverAuthenticationService) JWKS() JSONWebKeySet {
+	return d.keySet().JWKS()
 }
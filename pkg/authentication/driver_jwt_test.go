@@ -0,0 +1,101 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldValidateDriverJWT(t *testing.T) {
+	authenticator := &JWTDriverAuthenticationService{}
+
+	token, err := authenticator.GenerateDriverJWT("42")
+	require.NoError(t, err)
+
+	claims, err := authenticator.ValidateDriverJWT(token)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.DriverID)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "Empty token",
+			token: "",
+		},
+		{
+			name:  "Tampered token",
+			token: token + "x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authenticator.ValidateDriverJWT(tt.token)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// memoryDenylist is an in-memory TokenDenylist with the same rules as the Redis one
+type memoryDenylist struct {
+	tokens    map[string]bool
+	revokedAt map[string]int64
+	err       error
+}
+
+func (d *memoryDenylist) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	d.tokens[tokenID] = true
+	return nil
+}
+
+func (d *memoryDenylist) RevokeDriverTokens(ctx context.Context, driverID string, at time.Time) error {
+	d.revokedAt[driverID] = at.Unix()
+	return nil
+}
+
+func (d *memoryDenylist) IsRevoked(ctx context.Context, claims *DriverClaims) (bool, error) {
+	if d.err != nil {
+		return false, d.err
+	}
+	at, ok := d.revokedAt[claims.DriverID]
+	return d.tokens[claims.Id] || (ok && claims.IssuedAt <= at), nil
+}
+
+func TestShouldRejectRevokedDriverJWT(t *testing.T) {
+	denylist := &memoryDenylist{tokens: map[string]bool{}, revokedAt: map[string]int64{}}
+	authenticator := &JWTDriverAuthenticationService{Denylist: denylist}
+
+	token1, err := authenticator.GenerateDriverJWT("42")
+	require.NoError(t, err)
+	token2, err := authenticator.GenerateDriverJWT("42")
+	require.NoError(t, err)
+
+	claims1, err := authenticator.ValidateDriverJWT(token1)
+	require.NoError(t, err)
+	claims2, err := authenticator.ValidateDriverJWT(token2)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims1.Id)
+	assert.NotEqual(t, claims1.Id, claims2.Id)
+
+	// Revoking a token leaves the other tokens of the driver valid
+	require.NoError(t, denylist.RevokeToken(context.Background(), claims1.Id, time.Unix(claims1.ExpiresAt, 0)))
+	_, err = authenticator.ValidateDriverJWT(token1)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	_, err = authenticator.ValidateDriverJWT(token2)
+	require.NoError(t, err)
+
+	require.NoError(t, denylist.RevokeDriverTokens(context.Background(), "42", time.Now()))
+	_, err = authenticator.ValidateDriverJWT(token2)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	// Tokens can't be trusted when the denylist is down
+	denylist.err = errors.New("connection refused")
+	_, err = authenticator.ValidateDriverJWT(token2)
+	assert.ErrorIs(t, err, ErrRevocationCheckFailed)
+}
@@ -0,0 +1,315 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Supported signing algorithms, HS256 is kept for the existing deployments with a shared secret
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// defaultKeyID is the kid of the development key used when no key is configured
+const defaultKeyID = "default"
+
+// DevKeysEnv enables the development secrets of the services when their keys are not configured, it must
+// never be set in a deployment since those secrets are in the source
+const DevKeysEnv = "JWT_DEV_KEYS"
+
+var (
+	// ErrUnknownKeyID is returned when the kid of a token is not part of the keyset
+	ErrUnknownKeyID = errors.New("unknown signing key")
+	// ErrUnsupportedAlgorithm is returned when a key is configured with an algorithm that is not supported
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	// ErrNoSigningKey is returned when no key is configured and the development secrets are not enabled
+	ErrNoSigningKey = errors.New("no signing key configured")
+)
+
+// SigningKey is a key of the keyset. Private is only needed to sign, keys kept to validate the tokens
+// issued before a rotation can be loaded from their public part.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet holds the key used to sign new tokens and the previous keys still accepted during a rotation.
+// Tokens are signed with the current key and carry its ID in the kid header.
+type KeySet struct {
+	Current  *SigningKey
+	Previous []*SigningKey
+}
+
+// NewKeySet creates a new KeySet, the current key must be able to sign
+func NewKeySet(current *SigningKey, previous ...*SigningKey) (*KeySet, error) {
+	if current == nil || current.Private == nil {
+		return nil, errors.New("the current key must have a private key")
+	}
+	ids := map[string]bool{current.ID: true}
+	for _, key := range previous {
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicated key id %q", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return &KeySet{Current: current, Previous: previous}, nil
+}
+
+// NewHMACKey creates a HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// ParseSigningKey creates a key for the algorithm. For HS256 data is the secret, otherwise it is a PEM
+// encoded private key (PKCS#1, PKCS#8 or SEC 1) or a PEM encoded public key when the key is only used to
+// validate.
+func ParseSigningKey(id, algorithm string, data []byte) (*SigningKey, error) {
+	if algorithm == AlgorithmHS256 {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("empty secret for key %q", id)
+		}
+		return NewHMACKey(id, secret), nil
+	}
+
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil || (algorithm != AlgorithmRS256 && algorithm != AlgorithmES256 && algorithm != AlgorithmEdDSA) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	private, public, err := parsePEMKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key %q: %w", id, err)
+	}
+	if !keyMatchesAlgorithm(public, algorithm) {
+		return nil, fmt.Errorf("key %q can't be used with %s", id, algorithm)
+	}
+	return &SigningKey{ID: id, Method: method, Private: private, Public: public}, nil
+}
+
+// LoadSigningKey reads the key of the algorithm from a file
+func LoadSigningKey(id, algorithm, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKey(id, algorithm, data)
+}
+
+// LoadKeySetFromEnv builds the keyset from the environment variables with the given prefix:
+//
+//	<prefix>_KEY_ID         kid of the current key
+//	<prefix>_KEY_ALG        algorithm of the current key, HS256 when empty
+//	<prefix>_KEY_FILE       file with the current key
+//	<prefix>_KEY            the current key itself, when there is no file
+//	<prefix>_PREVIOUS_KEYS  comma separated list of kid:alg:file of the keys still accepted
+//
+// When no key is configured the fallback secret is used with HS256 only if JWT_DEV_KEYS is true, so local
+// setups keep working while a deployment missing its keys fails to start instead of accepting tokens signed
+// with a public secret.
+func LoadKeySetFromEnv(prefix string, fallback []byte) (*KeySet, error) {
+	id := os.Getenv(prefix + "_KEY_ID")
+	if id == "" {
+		id = defaultKeyID
+	}
+	algorithm := os.Getenv(prefix + "_KEY_ALG")
+	if algorithm == "" {
+		algorithm = AlgorithmHS256
+	}
+
+	var current *SigningKey
+	var err error
+	switch {
+	case os.Getenv(prefix+"_KEY_FILE") != "":
+		current, err = LoadSigningKey(id, algorithm, os.Getenv(prefix+"_KEY_FILE"))
+	case os.Getenv(prefix+"_KEY") != "":
+		current, err = ParseSigningKey(id, algorithm, []byte(os.Getenv(prefix+"_KEY")))
+	case devKeysEnabled():
+		log.Printf("%s: no signing key configured, using the development secret\n", prefix)
+		current = NewHMACKey(id, fallback)
+	default:
+		return nil, fmt.Errorf("%w: set %s_KEY_FILE or %s_KEY, or %s=true in development", ErrNoSigningKey, prefix, prefix, DevKeysEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*SigningKey
+	for _, entry := range strings.Split(os.Getenv(prefix+"_PREVIOUS_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid previous key %q, expected kid:alg:file", entry)
+		}
+		key, err := LoadSigningKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return NewKeySet(current, previous...)
+}
+
+func devKeysEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(DevKeysEnv))
+	return enabled
+}
+
+// Sign signs the claims with the current key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.Current.Method, claims)
+	token.Header["kid"] = ks.Current.ID
+	return token.SignedString(ks.Current.Private)
+}
+
+// Keyfunc returns the key to validate the token. The key is chosen by the kid header and its algorithm must
+// match the one of the token, so a token can't pick a weaker algorithm for a key. Tokens issued before the
+// kid header was added are validated with the keys of their algorithm.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range ks.keys() {
+		if kid != "" && key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			if kid != "" {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			continue
+		}
+		return key.Public, nil
+	}
+	if kid != "" {
+		return nil, ErrUnknownKeyID
+	}
+	return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+}
+
+// Parse validates the token against the keyset and fills the claims
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.Keyfunc)
+}
+
+func (ks *KeySet) keys() []*SigningKey {
+	return append([]*SigningKey{ks.Current}, ks.Previous...)
+}
+
+// JSONWebKey is the public part of a key as described in RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document served in the JWKS endpoint
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyset, HMAC secrets are never published
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	jwks := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.keys() {
+		jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeJWKInt(public.N)
+			jwk.E = encodeJWKInt(big.NewInt(int64(public.E)))
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func encodeJWKInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// parsePEMKey returns the private key, when the PEM holds one, and the public key
+func parsePEMKey(data []byte) (interface{}, interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found")
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return nil, public, err
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		return nil, public, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported private key")
+	}
+	return private, signer.Public(), nil
+}
+
+func keyMatchesAlgorithm(public interface{}, algorithm string) bool {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return algorithm == AlgorithmRS256
+	case *ecdsa.PublicKey:
+		return algorithm == AlgorithmES256 && key.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		return algorithm == AlgorithmEdDSA
+	default:
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generatePEMKey(t *testing.T, algorithm string) []byte {
+	t.Helper()
+	var key interface{}
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestShouldSignWithEveryAlgorithm(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		kty       string
+	}{
+		{algorithm: AlgorithmRS256, kty: "RSA"},
+		{algorithm: AlgorithmES256, kty: "EC"},
+		{algorithm: AlgorithmEdDSA, kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			key, err := ParseSigningKey("k1", tt.algorithm, generatePEMKey(t, tt.algorithm))
+			require.NoError(t, err)
+			keys, err := NewKeySet(key)
+			require.NoError(t, err)
+			authenticator := NewJWTDriverAuthenticationService(keys)
+
+			token, err := authenticator.GenerateDriverJWT("42")
+			require.NoError(t, err)
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &DriverClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, "k1", parsed.Header["kid"])
+			assert.Equal(t, tt.algorithm, parsed.Header["alg"])
+
+			claims, err := authenticator.ValidateDriverJWT(token)
+			require.NoError(t, err)
+			assert.Equal(t, "42", claims.DriverID)
+
+			jwks := authenticator.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tt.kty, jwks.Keys[0].Kty)
+			assert.Equal(t, "k1", jwks.Keys[0].Kid)
+			assert.Equal(t, tt.algorithm, jwks.Keys[0].Alg)
+		})
+	}
+}
+
+func TestShouldValidateTokensOfPreviousKeys(t *testing.T) {
+	oldKey, err := ParseSigningKey("old", AlgorithmES256, generatePEMKey(t, AlgorithmES256))
+	require.NoError(t, err)
+	newKey, err := ParseSigningKey("new", AlgorithmEdDSA, generatePEMKey(t, AlgorithmEdDSA))
+	require.NoError(t, err)
+
+	oldKeys, err := NewKeySet(oldKey)
+	require.NoError(t, err)
+	oldToken, err := NewJWTDriverAuthenticationService(oldKeys).GenerateDriverJWT("42")
+	require.NoError(t, err)
+
+	// After the rotation the old tokens are still valid and the new ones use the new key
+	rotated, err := NewKeySet(newKey, &SigningKey{ID: oldKey.ID, Method: oldKey.Method, Public: oldKey.Public})
+	require.NoError(t, err)
+	authenticator := NewJWTDriverAuthenticationService(rotated)
+	claims, err := authenticator.ValidateDriverJWT(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.DriverID)
+	assert.Len(t, authenticator.JWKS().Keys, 2)
+
+	// Once the old key is dropped its tokens are rejected
+	dropped, err := NewKeySet(newKey)
+	require.NoError(t, err)
+	_, err = NewJWTDriverAuthenticationService(dropped).ValidateDriverJWT(oldToken)
+	assert.Error(t, err)
+}
+
+func TestShouldRejectAlgorithmMismatch(t *testing.T) {
+	pemKey := generatePEMKey(t, AlgorithmRS256)
+	key, err := ParseSigningKey("rsa", AlgorithmRS256, pemKey)
+	require.NoError(t, err)
+	keys, err := NewKeySet(key)
+	require.NoError(t, err)
+
+	// A HS256 token using the public key as secret must not be accepted for the RSA key
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, DriverClaims{
+		DriverID:       "42",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	})
+	forged.Header["kid"] = "rsa"
+	forgedToken, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	require.NoError(t, err)
+
+	_, err = NewJWTDriverAuthenticationService(keys).ValidateDriverJWT(forgedToken)
+	assert.Error(t, err)
+
+	_, err = ParseSigningKey("rsa", AlgorithmES256, pemKey)
+	assert.Error(t, err)
+	_, err = ParseSigningKey("rsa", "none", pemKey)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestShouldLoadKeySetFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	currentFile := filepath.Join(dir, "current.pem")
+	previousFile := filepath.Join(dir, "previous.pem")
+	require.NoError(t, os.WriteFile(currentFile, generatePEMKey(t, AlgorithmEdDSA), 0600))
+	require.NoError(t, os.WriteFile(previousFile, generatePEMKey(t, AlgorithmRS256), 0600))
+
+	t.Setenv("TEST_JWT_KEY_ID", "2024-06")
+	t.Setenv("TEST_JWT_KEY_ALG", AlgorithmEdDSA)
+	t.Setenv("TEST_JWT_KEY_FILE", currentFile)
+	t.Setenv("TEST_JWT_PREVIOUS_KEYS", "2024-01:RS256:"+previousFile)
+
+	keys, err := LoadKeySetFromEnv("TEST_JWT", []byte("fallback"))
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06", keys.Current.ID)
+	assert.Equal(t, AlgorithmEdDSA, keys.Current.Method.Alg())
+	require.Len(t, keys.Previous, 1)
+	assert.Equal(t, "2024-01", keys.Previous[0].ID)
+
+	// Without configuration the fallback secret is only used in development
+	t.Setenv(DevKeysEnv, "")
+	_, err = LoadKeySetFromEnv("UNSET_JWT", []byte("fallback"))
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	t.Setenv(DevKeysEnv, "true")
+	keys, err = LoadKeySetFromEnv("UNSET_JWT", []byte("fallback"))
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmHS256, keys.Current.Method.Alg())
+	assert.Empty(t, keys.JWKS().Keys)
+}
@@ -0,0 +1,63 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// passengerSigningKey is the development secret used to sign the passenger JWT when no key is configured,
+// it is kept apart from the driver one so a passenger token can never be used as a driver token
+const passengerSigningKey = "my_passenger_secret123"
+
+// PassengerKeyEnvPrefix is the prefix of the environment variables holding the passenger signing keys
+const PassengerKeyEnvPrefix = "PASSENGER_JWT"
+
+// JWTPassengerAuthenticationService signs the passenger tokens with its own keyset, the zero value uses the
+// development secret
+type JWTPassengerAuthenticationService struct {
+	Keys *KeySet
+}
+
+// NewJWTPassengerAuthenticationServiceFromEnv creates a new JWTPassengerAuthenticationService with the keys
+// configured in the PASSENGER_JWT_* environment variables
+func NewJWTPassengerAuthenticationServiceFromEnv() (*JWTPassengerAuthenticationService, error) {
+	keys, err := LoadKeySetFromEnv(PassengerKeyEnvPrefix, []byte(passengerSigningKey))
+	if err != nil {
+		return nil, err
+	}
+	return &JWTPassengerAuthenticationService{Keys: keys}, nil
+}
+
+func (p *JWTPassengerAuthenticationService) keySet() *KeySet {
+	if p.Keys == nil {
+		return &KeySet{Current: NewHMACKey(defaultKeyID, []byte(passengerSigningKey))}
+	}
+	return p.Keys
+}
+
+func (p *JWTPassengerAuthenticationService) GeneratePassengerJWT(passengerID string) (string, error) {
+	claims := PassengerClaims{
+		PassengerID: passengerID,
+		Timestamp:   time.Now().Unix(),
+		Roles:       []Role{RolePassenger},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
+		},
+	}
+
+	return p.keySet().Sign(claims)
+}
+
+func (p *JWTPassengerAuthenticationService) ValidatePassengerJWT(tokenString string) (*PassengerClaims, error) {
+	token, err := p.keySet().Parse(tokenString, &PassengerClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*PassengerClaims); ok && token.Valid && claims.PassengerID != "" {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("invalid token")
+}
@@ -0,0 +1,40 @@
+package authentication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldValidatePassengerJWT(t *testing.T) {
+	authenticator := &JWTPassengerAuthenticationService{}
+
+	token, err := authenticator.GeneratePassengerJWT("42")
+	require.NoError(t, err)
+
+	claims, err := authenticator.ValidatePassengerJWT(token)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.PassengerID)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "Empty token",
+			token: "",
+		},
+		{
+			name:  "Tampered token",
+			token: token + "x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authenticator.ValidatePassengerJWT(tt.token)
+			assert.Error(t, err)
+		})
+	}
+}
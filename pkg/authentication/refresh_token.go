@@ -0,0 +1,120 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// refreshTokenKeyPrefix prefixes the keys holding the driver of every refresh token
+	refreshTokenKeyPrefix = "driver_refresh:"
+	// driverRefreshTokensKeyPrefix prefixes the sets holding the refresh tokens of every driver, so all of
+	// them can be revoked at once
+	driverRefreshTokensKeyPrefix = "driver_refresh_tokens:"
+	// refreshTokenBytes is the amount of random bytes of a refresh token
+	refreshTokenBytes = 32
+)
+
+// ErrInvalidRefreshToken is returned when the refresh token does not exist, expired or was revoked
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// RefreshTokenStore issues opaque refresh tokens for drivers, refreshing a token revokes it and issues a new
+// one so a stolen token can only be used once
+type RefreshTokenStore interface {
+	IssueRefreshToken(ctx context.Context, driverID string) (string, error)
+	RotateRefreshToken(ctx context.Context, token string) (string, string, error)
+	RevokeRefreshToken(ctx context.Context, token string) error
+	RevokeDriverRefreshTokens(ctx context.Context, driverID string) error
+}
+
+// RedisRefreshTokenStore keeps the refresh tokens in Redis with a TTL, so expired tokens are removed by Redis
+type RedisRefreshTokenStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+// NewRedisRefreshTokenStore creates a new RedisRefreshTokenStore
+func NewRedisRefreshTokenStore(redisAddr string, ttl time.Duration) *RedisRefreshTokenStore {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisRefreshTokenStore{redisClient: rdb, ttl: ttl}
+}
+
+// IssueRefreshToken creates a new refresh token for the driver
+func (s *RedisRefreshTokenStore) IssueRefreshToken(ctx context.Context, driverID string) (string, error) {
+	raw := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, refreshTokenKeyPrefix+token, driverID, s.ttl)
+	pipe.SAdd(ctx, driverRefreshTokensKeyPrefix+driverID, token)
+	pipe.Expire(ctx, driverRefreshTokensKeyPrefix+driverID, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken revokes the token and issues a new one for the same driver, it returns the driver
+// and the new token
+func (s *RedisRefreshTokenStore) RotateRefreshToken(ctx context.Context, token string) (string, string, error) {
+	// GETDEL makes the token single use even with concurrent refreshes
+	driverID, err := s.redisClient.GetDel(ctx, refreshTokenKeyPrefix+token).Result()
+	if err == redis.Nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.redisClient.SRem(ctx, driverRefreshTokensKeyPrefix+driverID, token).Err(); err != nil {
+		return "", "", err
+	}
+
+	newToken, err := s.IssueRefreshToken(ctx, driverID)
+	if err != nil {
+		return "", "", err
+	}
+	return driverID, newToken, nil
+}
+
+// RevokeRefreshToken removes a refresh token, revoking an unknown token is not an error
+func (s *RedisRefreshTokenStore) RevokeRefreshToken(ctx context.Context, token string) error {
+	driverID, err := s.redisClient.GetDel(ctx, refreshTokenKeyPrefix+token).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.redisClient.SRem(ctx, driverRefreshTokensKeyPrefix+driverID, token).Err()
+}
+
+// RevokeDriverRefreshTokens removes all the refresh tokens of a driver
+func (s *RedisRefreshTokenStore) RevokeDriverRefreshTokens(ctx context.Context, driverID string) error {
+	setKey := driverRefreshTokensKeyPrefix + driverID
+	tokens, err := s.redisClient.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(tokens)+1)
+	for _, token := range tokens {
+		keys = append(keys, refreshTokenKeyPrefix+token)
+	}
+	keys = append(keys, setKey)
+	if err := s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("error revoking refresh tokens of driver %s: %w", driverID, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package authentication
+
+// Role is the role of the caller, the driver and passenger tokens always carry their own role and the staff
+// roles are only taken from the staff tokens
+type Role string
+
+const (
+	// RoleAdmin manages the whole platform
+	RoleAdmin Role = "admin"
+	// RoleOps is the operations staff, they manage the drivers but can't remove them
+	RoleOps Role = "ops"
+	// RoleDriver is a driver, it can only manage its own data
+	RoleDriver Role = "driver"
+	// RolePassenger is a passenger, it can only manage its own data
+	RolePassenger Role = "passenger"
+)
+
+// Permission is an action on a resource. Permissions ending in ":self" only apply to the resources of the
+// caller, the {id} of the route must be the ID of the caller.
+type Permission string
+
+const (
+	PermDriversList   Permission = "drivers:list"
+	PermDriversRead   Permission = "drivers:read"
+	PermDriversWrite  Permission = "drivers:write"
+	PermDriversDelete Permission = "drivers:delete"
+	PermDriversSelf   Permission = "drivers:self"
+	PermDriversRevoke Permission = "drivers:revoke_tokens"
+	PermRidesListAll  Permission = "rides:list_all"
+	PermRidesDelete   Permission = "rides:delete"
+	PermZonesWrite    Permission = "zones:write"
+	// The passengers sign up by themselves, the staff can list them and create them
+	PermPassengersList  Permission = "passengers:list"
+	PermPassengersWrite Permission = "passengers:write"
+)
+
+// RolePermissions is the permissions granted to every role
+var RolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermDriversList, PermDriversRead, PermDriversWrite, PermDriversDelete, PermDriversRevoke, PermRidesListAll,
+		PermRidesDelete, PermZonesWrite, PermPassengersList, PermPassengersWrite,
+	},
+	RoleOps: {
+		PermDriversList, PermDriversRead, PermDriversWrite, PermPassengersList,
+	},
+	RoleDriver: {
+		PermDriversSelf,
+	},
+	RolePassenger: {},
+}
+
+// HasPermission returns true when any of the roles grants the permission
+func HasPermission(roles []Role, permission Permission) bool {
+	for _, role := range roles {
+		for _, granted := range RolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DriverRoles returns the roles of the driver claims. A driver token only grants the driver role whatever its
+// roles claim says, the staff roles come from the staff tokens signed with their own keys.
+func (c *DriverClaims) DriverRoles() []Role {
+	return []Role{RoleDriver}
+}
+
+// PassengerRoles returns the roles of the passenger claims, a passenger token only grants the passenger role
+func (c *PassengerClaims) PassengerRoles() []Role {
+	return []Role{RolePassenger}
+}
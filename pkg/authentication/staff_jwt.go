@@ -0,0 +1,111 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// staffSigningKey is the development secret used to sign the staff JWT when no key is configured
+const staffSigningKey = "my_staff_secret123"
+
+// StaffKeyEnvPrefix is the prefix of the environment variables holding the staff signing keys, they are
+// apart from the driver and passenger ones so only the holders of the staff keys can grant staff roles
+const StaffKeyEnvPrefix = "STAFF_JWT"
+
+// staffTokenLifetime is how long a staff token is valid
+const staffTokenLifetime = 8 * time.Hour
+
+// ErrNotStaffRole is returned when a staff token is requested for a role that is not a staff one
+var ErrNotStaffRole = errors.New("not a staff role")
+
+// StaffClaims are the claims of the tokens of the admin and ops staff, the staff ID goes in the subject
+type StaffClaims struct {
+	Roles []Role `json:"roles"`
+	jwt.StandardClaims
+}
+
+// StaffAuthenticator generates and validates the staff JWT
+type StaffAuthenticator interface {
+	GenerateStaffJWT(staffID string, roles ...Role) (string, error)
+	ValidateStaffJWT(tokenString string) (*StaffClaims, error)
+}
+
+// JWTStaffAuthenticationService signs the staff tokens with its own keyset, the zero value uses the
+// development secret
+type JWTStaffAuthenticationService struct {
+	Keys *KeySet
+}
+
+// NewJWTStaffAuthenticationServiceFromEnv creates a new JWTStaffAuthenticationService with the keys
+// configured in the STAFF_JWT_* environment variables
+func NewJWTStaffAuthenticationServiceFromEnv() (*JWTStaffAuthenticationService, error) {
+	keys, err := LoadKeySetFromEnv(StaffKeyEnvPrefix, []byte(staffSigningKey))
+	if err != nil {
+		return nil, err
+	}
+	return &JWTStaffAuthenticationService{Keys: keys}, nil
+}
+
+func (s *JWTStaffAuthenticationService) keySet() *KeySet {
+	if s.Keys == nil {
+		return &KeySet{Current: NewHMACKey(defaultKeyID, []byte(staffSigningKey))}
+	}
+	return s.Keys
+}
+
+// GenerateStaffJWT issues a token for the staff member with the given staff roles
+func (s *JWTStaffAuthenticationService) GenerateStaffJWT(staffID string, roles ...Role) (string, error) {
+	if staffID == "" || len(roles) == 0 {
+		return "", errors.New("staff ID and roles are required")
+	}
+	for _, role := range roles {
+		if !isStaffRole(role) {
+			return "", fmt.Errorf("%w: %s", ErrNotStaffRole, role)
+		}
+	}
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := StaffClaims{
+		Roles: roles,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   staffID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(staffTokenLifetime).Unix(),
+		},
+	}
+	return s.keySet().Sign(claims)
+}
+
+func (s *JWTStaffAuthenticationService) ValidateStaffJWT(tokenString string) (*StaffClaims, error) {
+	token, err := s.keySet().Parse(tokenString, &StaffClaims{})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*StaffClaims)
+	if !ok || !token.Valid || claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// StaffRoles returns the staff roles of the claims, any other role in the claim is ignored
+func (c *StaffClaims) StaffRoles() []Role {
+	var roles []Role
+	for _, role := range c.Roles {
+		if isStaffRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func isStaffRole(role Role) bool {
+	return role == RoleAdmin || role == RoleOps
+}
@@ -0,0 +1,43 @@
+package authentication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldValidateStaffJWT(t *testing.T) {
+	authenticator := &JWTStaffAuthenticationService{}
+
+	token, err := authenticator.GenerateStaffJWT("alice", RoleAdmin)
+	require.NoError(t, err)
+
+	claims, err := authenticator.ValidateStaffJWT(token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, []Role{RoleAdmin}, claims.StaffRoles())
+
+	// Staff tokens only carry staff roles
+	_, err = authenticator.GenerateStaffJWT("alice", RoleDriver)
+	assert.ErrorIs(t, err, ErrNotStaffRole)
+	_, err = authenticator.GenerateStaffJWT("", RoleOps)
+	assert.Error(t, err)
+
+	// Driver and passenger tokens are signed with other keys, they are never staff tokens
+	driverToken, err := (&JWTDriverAuthenticationService{}).GenerateDriverJWT("42")
+	require.NoError(t, err)
+	passengerToken, err := (&JWTPassengerAuthenticationService{}).GeneratePassengerJWT("42")
+	require.NoError(t, err)
+	for _, token := range []string{"", token + "x", driverToken, passengerToken} {
+		_, err := authenticator.ValidateStaffJWT(token)
+		assert.Error(t, err)
+	}
+}
+
+func TestShouldIgnoreRolesClaimOfDriversAndPassengers(t *testing.T) {
+	driver := &DriverClaims{DriverID: "7", Roles: []Role{RoleAdmin}}
+	assert.Equal(t, []Role{RoleDriver}, driver.DriverRoles())
+	passenger := &PassengerClaims{PassengerID: "7", Roles: []Role{RoleAdmin}}
+	assert.Equal(t, []Role{RolePassenger}, passenger.PassengerRoles())
+}
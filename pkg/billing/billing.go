@@ -1,7 +1,11 @@
 package billing
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/routing"
 	"github.com/OscarMoya/Glubber/pkg/util"
 )
 
@@ -9,9 +13,24 @@ type Biller interface {
 	EstimateRide(ride *model.Ride) error
 }
 
+// PoolBiller splits the fare of a shared vehicle between the rides in it, the route is the whole route of the
+// pool including the legs already driven with the passengers on board
+type PoolBiller interface {
+	SplitPooledFare(rides []*model.Ride, route []model.Coordinate) error
+}
+
+// ZoneLookup returns the zones holding a point, for the surcharges of the zones, see zones.Registry
+type ZoneLookup interface {
+	Lookup(p model.Coordinate) []model.Zone
+}
+
 type SimpleBiller struct {
 	baseCost float64
 	kmCharge float64
+	// router measures the distances along the roads, nil to use the great-circle distance
+	router routing.Router
+	// zones adds the surcharges of the zones of the pickup and the dropoff, nil for no surcharges
+	zones ZoneLookup
 }
 
 func NewSimpleBiller(baseCost, kmCharge float64) *SimpleBiller {
@@ -21,8 +40,87 @@ func NewSimpleBiller(baseCost, kmCharge float64) *SimpleBiller {
 	}
 }
 
+// WithRouter measures the distances along the roads of the router, the routes out of the roads keep the
+// great-circle distance
+func (sb *SimpleBiller) WithRouter(router routing.Router) *SimpleBiller {
+	sb.router = router
+	return sb
+}
+
+// WithZones adds the surcharges of the zones the rides start or end in, a zone is charged once per ride
+func (sb *SimpleBiller) WithZones(zones ZoneLookup) *SimpleBiller {
+	sb.zones = zones
+	return sb
+}
+
+// surcharge returns the surcharges of the zones of the pickup and the dropoff of the ride
+func (sb *SimpleBiller) surcharge(ride *model.Ride) float64 {
+	if sb.zones == nil {
+		return 0
+	}
+	charged := make(map[int]bool)
+	total := 0.0
+	for _, p := range []model.Coordinate{{Lat: ride.SrcLat, Lon: ride.SrcLon}, {Lat: ride.DstLat, Lon: ride.DstLon}} {
+		for _, z := range sb.zones.Lookup(p) {
+			if !charged[z.ID] {
+				charged[z.ID] = true
+				total += z.Surcharge
+			}
+		}
+	}
+	return total
+}
+
+// distance returns the distance of the route along the roads when there is a router and a route, the
+// great-circle distance otherwise
+func (sb *SimpleBiller) distance(route []model.Coordinate) float64 {
+	if sb.router != nil {
+		if r, err := routing.RouteVia(sb.router, route); err == nil {
+			return r.DistanceKm
+		}
+	}
+	return RouteDistance(route)
+}
+
+// EstimateRide prices the ride along its full route, so intermediate stops are accounted
 func (sb *SimpleBiller) EstimateRide(ride *model.Ride) error {
-	distance := util.CalculateDistance(ride.SrcLat, ride.SrcLon, ride.DstLat, ride.DstLon)
-	ride.Price = sb.baseCost + (distance * sb.kmCharge)
+	distance := sb.distance(ride.Route())
+	ride.Price = sb.baseCost + (distance * sb.kmCharge) + sb.surcharge(ride)
+	return nil
+}
+
+// RouteDistance returns the distance in kilometers along the polyline defined by the route points
+func RouteDistance(route []model.Coordinate) float64 {
+	distance := 0.0
+	for i := 1; i < len(route); i++ {
+		distance += util.CalculateDistance(route[i-1].Lat, route[i-1].Lon, route[i].Lat, route[i].Lon)
+	}
+	return distance
+}
+
+// SplitPooledFare prices the shared route once and splits it between the rides proportionally to the
+// distance of each ride travelled alone. The route goes from the first pickup of the passengers on board to
+// the last dropoff, the distance already driven is billed along the remaining one. No passenger pays more
+// than the solo price of the ride, the zone surcharges of every ride are not shared.
+func (sb *SimpleBiller) SplitPooledFare(rides []*model.Ride, route []model.Coordinate) error {
+	if len(rides) == 0 {
+		return fmt.Errorf("no rides to split the fare")
+	}
+	soloDistances := make([]float64, len(rides))
+	totalSolo := 0.0
+	for i, ride := range rides {
+		soloDistances[i] = sb.distance(ride.Route())
+		totalSolo += soloDistances[i]
+	}
+
+	total := sb.baseCost + (sb.distance(route) * sb.kmCharge)
+	for i, ride := range rides {
+		share := 1.0 / float64(len(rides))
+		if totalSolo > 0 {
+			share = soloDistances[i] / totalSolo
+		}
+		solo := sb.baseCost + (soloDistances[i] * sb.kmCharge)
+		ride.Price = math.Min(total*share, solo) + sb.surcharge(ride)
+	}
 	return nil
 }
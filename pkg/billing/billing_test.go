@@ -0,0 +1,176 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/routing"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestShouldEstimateRideAlongStops checks that the price follows the whole polyline and not only
+// the straight line between source and destination
+func TestShouldEstimateRideAlongStops(t *testing.T) {
+	srcLat, srcLon := 40.7128, -74.0
+	// Stop 2km north, destination 2km east of the source
+	stopLat, stopLon := util.AddKM(srcLat, srcLon, 2, 0)
+	dstLat, dstLon := util.AddKM(srcLat, srcLon, 2, 90)
+
+	biller := NewSimpleBiller(2.0, 1.0)
+
+	tests := []struct {
+		name          string
+		stops         []model.RideStop
+		expectedPrice float64
+	}{
+		{
+			name:          "Direct ride",
+			expectedPrice: 2.0 + 2.0,
+		},
+		{
+			name:          "Ride with one stop",
+			stops:         []model.RideStop{{Seq: 1, Lat: stopLat, Lon: stopLon}},
+			expectedPrice: 2.0 + 2.0 + util.CalculateDistance(stopLat, stopLon, dstLat, dstLon),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ride := &model.Ride{
+				SrcLat: srcLat,
+				SrcLon: srcLon,
+				DstLat: dstLat,
+				DstLon: dstLon,
+				Stops:  tt.stops,
+			}
+			err := biller.EstimateRide(ride)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expectedPrice, ride.Price, 0.01)
+		})
+	}
+}
+
+// TestShouldEstimateRideWithClassTariff checks that each vehicle class uses its own tariff
+func TestShouldEstimateRideWithClassTariff(t *testing.T) {
+	srcLat, srcLon := 40.7128, -74.0
+	dstLat, dstLon := util.AddKM(srcLat, srcLon, 10, 90)
+
+	biller := NewClassBiller(
+		Tariff{BaseCost: 2.0, KmCharge: 1.0},
+		map[model.VehicleClass]Tariff{
+			model.VehicleClassXL:      {BaseCost: 3.0, KmCharge: 1.5},
+			model.VehicleClassPremium: {BaseCost: 5.0, KmCharge: 2.0},
+		},
+	)
+
+	tests := []struct {
+		name          string
+		class         model.VehicleClass
+		expectedPrice float64
+	}{
+		{
+			name:          "No class uses the default tariff",
+			expectedPrice: 12.0,
+		},
+		{
+			name:          "Class without tariff uses the default tariff",
+			class:         model.VehicleClassEconomy,
+			expectedPrice: 12.0,
+		},
+		{
+			name:          "XL",
+			class:         model.VehicleClassXL,
+			expectedPrice: 18.0,
+		},
+		{
+			name:          "Premium",
+			class:         model.VehicleClassPremium,
+			expectedPrice: 25.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ride := &model.Ride{
+				SrcLat:       srcLat,
+				SrcLon:       srcLon,
+				DstLat:       dstLat,
+				DstLon:       dstLon,
+				VehicleClass: tt.class,
+			}
+			err := biller.EstimateRide(ride)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expectedPrice, ride.Price, 0.01)
+		})
+	}
+}
+
+// detourRouter drives every leg 1.5 times the straight line, unless the destination is out of the roads
+type detourRouter struct {
+	offRoad model.Coordinate
+}
+
+func (r *detourRouter) Route(from, to model.Coordinate) (routing.Route, error) {
+	if to == r.offRoad {
+		return routing.Route{}, routing.ErrNoRoute
+	}
+	distance := util.CalculateDistance(from.Lat, from.Lon, to.Lat, to.Lon) * 1.5
+	return routing.Route{DistanceKm: distance, Points: []model.Coordinate{from, to}}, nil
+}
+
+// TestShouldEstimateRideAlongRoads checks that the distance along the roads is billed when there is a router
+// and the great-circle distance when the route is out of the roads
+func TestShouldEstimateRideAlongRoads(t *testing.T) {
+	srcLat, srcLon := 40.7128, -74.0
+	dstLat, dstLon := util.AddKM(srcLat, srcLon, 10, 90)
+	offLat, offLon := util.AddKM(srcLat, srcLon, 10, 0)
+	router := &detourRouter{offRoad: model.Coordinate{Lat: offLat, Lon: offLon}}
+	biller := NewClassBiller(Tariff{BaseCost: 2.0, KmCharge: 1.0}, nil).WithRouter(router)
+
+	ride := &model.Ride{SrcLat: srcLat, SrcLon: srcLon, DstLat: dstLat, DstLon: dstLon}
+	require.NoError(t, biller.EstimateRide(ride))
+	assert.InDelta(t, 17.0, ride.Price, 0.01)
+
+	ride = &model.Ride{SrcLat: srcLat, SrcLon: srcLon, DstLat: offLat, DstLon: offLon}
+	require.NoError(t, biller.EstimateRide(ride))
+	assert.InDelta(t, 12.0, ride.Price, 0.01)
+}
+
+// airportZones puts an airport with a surcharge north of every point with a latitude above its edge
+type airportZones struct {
+	edge float64
+}
+
+func (z *airportZones) Lookup(p model.Coordinate) []model.Zone {
+	if p.Lat < z.edge {
+		return nil
+	}
+	return []model.Zone{{ID: 1, Name: "airport", Kind: model.ZoneKindRestricted, Surcharge: 4.5}}
+}
+
+// TestShouldAddZoneSurcharges checks that the surcharge of a zone is added once to the rides starting or
+// ending in it
+func TestShouldAddZoneSurcharges(t *testing.T) {
+	srcLat, srcLon := 40.7128, -74.0
+	cityLat, cityLon := util.AddKM(srcLat, srcLon, 10, 90)
+	airportLat, airportLon := util.AddKM(srcLat, srcLon, 10, 0)
+	biller := NewClassBiller(Tariff{BaseCost: 2.0, KmCharge: 1.0}, nil).WithZones(&airportZones{edge: srcLat + 0.05})
+
+	tests := []struct {
+		name     string
+		ride     *model.Ride
+		expected float64
+	}{
+		{"no zone", &model.Ride{SrcLat: srcLat, SrcLon: srcLon, DstLat: cityLat, DstLon: cityLon}, 12.0},
+		{"to the airport", &model.Ride{SrcLat: srcLat, SrcLon: srcLon, DstLat: airportLat, DstLon: airportLon}, 16.5},
+		{"within the airport", &model.Ride{SrcLat: airportLat, SrcLon: airportLon, DstLat: airportLat + 0.01, DstLon: airportLon}, 2.0 + 1.11 + 4.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, biller.EstimateRide(tt.ride))
+			assert.InDelta(t, tt.expected, tt.ride.Price, 0.02)
+		})
+	}
+}
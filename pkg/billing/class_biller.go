@@ -0,0 +1,80 @@
+package billing
+
+import (
+	"fmt"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/routing"
+)
+
+// Tariff is the base cost and the charge per kilometer of a vehicle class
+type Tariff struct {
+	BaseCost float64 `json:"base_cost"`
+	KmCharge float64 `json:"km_charge"`
+}
+
+// DefaultTariff and ClassTariffs are the tariffs of the service, the ride service quotes the rides with them
+// and the driver service splits the fares of the pooled rides with the same ones
+var (
+	DefaultTariff = Tariff{BaseCost: 2.0, KmCharge: 1.0}
+	ClassTariffs  = map[model.VehicleClass]Tariff{
+		model.VehicleClassXL:      {BaseCost: 3.0, KmCharge: 1.5},
+		model.VehicleClassPremium: {BaseCost: 5.0, KmCharge: 2.2},
+	}
+)
+
+// ClassBiller prices the rides with the tariff of the requested vehicle class, rides without class or
+// with a class without tariff use the default tariff
+type ClassBiller struct {
+	defaultBiller *SimpleBiller
+	classBillers  map[model.VehicleClass]*SimpleBiller
+}
+
+func NewClassBiller(defaultTariff Tariff, tariffs map[model.VehicleClass]Tariff) *ClassBiller {
+	classBillers := make(map[model.VehicleClass]*SimpleBiller, len(tariffs))
+	for class, tariff := range tariffs {
+		classBillers[class] = NewSimpleBiller(tariff.BaseCost, tariff.KmCharge)
+	}
+	return &ClassBiller{
+		defaultBiller: NewSimpleBiller(defaultTariff.BaseCost, defaultTariff.KmCharge),
+		classBillers:  classBillers,
+	}
+}
+
+// WithRouter measures the distances of every tariff along the roads of the router
+func (cb *ClassBiller) WithRouter(router routing.Router) *ClassBiller {
+	cb.defaultBiller.WithRouter(router)
+	for _, biller := range cb.classBillers {
+		biller.WithRouter(router)
+	}
+	return cb
+}
+
+// WithZones adds the surcharges of the zones to every tariff
+func (cb *ClassBiller) WithZones(zones ZoneLookup) *ClassBiller {
+	cb.defaultBiller.WithZones(zones)
+	for _, biller := range cb.classBillers {
+		biller.WithZones(zones)
+	}
+	return cb
+}
+
+func (cb *ClassBiller) EstimateRide(ride *model.Ride) error {
+	return cb.billerFor(ride.VehicleClass).EstimateRide(ride)
+}
+
+// SplitPooledFare splits the fare with the tariff of the class of the pooled vehicle, all the rides of
+// a pool are expected to share the same class
+func (cb *ClassBiller) SplitPooledFare(rides []*model.Ride, route []model.Coordinate) error {
+	if len(rides) == 0 {
+		return fmt.Errorf("no rides to split the fare")
+	}
+	return cb.billerFor(rides[0].VehicleClass).SplitPooledFare(rides, route)
+}
+
+func (cb *ClassBiller) billerFor(class model.VehicleClass) *SimpleBiller {
+	if biller, ok := cb.classBillers[class]; ok {
+		return biller
+	}
+	return cb.defaultBiller
+}
@@ -0,0 +1,119 @@
+// Package eta estimates how long it takes to drive between two points, for the pickup of a passenger and for
+// the trip itself. The estimators are pluggable: the baseline one uses the straight line distance with the
+// average speed and the detour of the region, the learned one uses the speeds and detours measured on the
+// trails of the completed rides.
+package eta
+
+import (
+	"errors"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// ErrEmptyRoute is returned when a route has less than two points
+var ErrEmptyRoute = errors.New("a route needs at least two points")
+
+// Estimate is the expected driving distance and duration between two points
+type Estimate struct {
+	DistanceKm float64
+	Duration   time.Duration
+}
+
+// Seconds returns the duration rounded to seconds, as sent to the clients
+func (e Estimate) Seconds() int {
+	return int(e.Duration.Round(time.Second) / time.Second)
+}
+
+// Estimator estimates the driving distance and duration between two points for a departure time
+type Estimator interface {
+	Estimate(from, to model.Coordinate, at time.Time) (Estimate, error)
+}
+
+// EstimateRoute adds up the estimates of the legs of a route, every leg departs when the previous one arrives
+func EstimateRoute(estimator Estimator, route []model.Coordinate, at time.Time) (Estimate, error) {
+	if len(route) < 2 {
+		return Estimate{}, ErrEmptyRoute
+	}
+	var total Estimate
+	for i := 1; i < len(route); i++ {
+		leg, err := estimator.Estimate(route[i-1], route[i], at.Add(total.Duration))
+		if err != nil {
+			return Estimate{}, err
+		}
+		total.DistanceKm += leg.DistanceKm
+		total.Duration += leg.Duration
+	}
+	return total, nil
+}
+
+// Region is an area with its own traffic, the bounds are in degrees
+type Region struct {
+	Name   string  `json:"name"`
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+	// SpeedKmh is the average driving speed, DetourFactor how much longer the roads are than the straight line
+	SpeedKmh     float64 `json:"speed_kmh"`
+	DetourFactor float64 `json:"detour_factor"`
+}
+
+// Contains returns true when the point is inside the bounds of the region
+func (r Region) Contains(p model.Coordinate) bool {
+	return p.Lat >= r.MinLat && p.Lat <= r.MaxLat && p.Lon >= r.MinLon && p.Lon <= r.MaxLon
+}
+
+// DefaultRegion is used for the points out of every configured region
+var DefaultRegion = Region{Name: "default", SpeedKmh: 30, DetourFactor: 1.3}
+
+// DefaultRegions are the regions the service operates in
+var DefaultRegions = []Region{
+	{Name: "madrid", MinLat: 40.30, MinLon: -3.85, MaxLat: 40.56, MaxLon: -3.52, SpeedKmh: 24, DetourFactor: 1.35},
+	{Name: "barcelona", MinLat: 41.32, MinLon: 2.05, MaxLat: 41.47, MaxLon: 2.23, SpeedKmh: 21, DetourFactor: 1.3},
+}
+
+// Baseline estimates with util.CalculateDistance stretched by the detour factor of the region of the origin
+// and driven at the average speed of the region
+type Baseline struct {
+	regions  []Region
+	fallback Region
+}
+
+// NewBaseline creates a baseline estimator, the points out of every region use the fallback region
+func NewBaseline(regions []Region, fallback Region) *Baseline {
+	return &Baseline{regions: regions, fallback: fallback}
+}
+
+// NewDefaultBaseline creates a baseline estimator with the DefaultRegions
+func NewDefaultBaseline() *Baseline {
+	return NewBaseline(DefaultRegions, DefaultRegion)
+}
+
+// RegionOf returns the region of the point, the first one containing it or the fallback region
+func (b *Baseline) RegionOf(p model.Coordinate) Region {
+	for _, r := range b.regions {
+		if r.Contains(p) {
+			return r
+		}
+	}
+	return b.fallback
+}
+
+func (b *Baseline) Estimate(from, to model.Coordinate, at time.Time) (Estimate, error) {
+	region := b.RegionOf(from)
+	return estimate(from, to, region.SpeedKmh, region.DetourFactor), nil
+}
+
+// estimate drives the straight line distance stretched by the detour at the given speed
+func estimate(from, to model.Coordinate, speedKmh, detour float64) Estimate {
+	distance := util.CalculateDistance(from.Lat, from.Lon, to.Lat, to.Lon) * detour
+	if speedKmh <= 0 {
+		speedKmh = DefaultRegion.SpeedKmh
+	}
+	return Estimate{
+		DistanceKm: distance,
+		Duration:   time.Duration(distance / speedKmh * float64(time.Hour)),
+	}
+}
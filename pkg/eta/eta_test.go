@@ -0,0 +1,245 @@
+package eta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/routing"
+	"github.com/OscarMoya/Glubber/pkg/trail"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	madrid = model.Coordinate{Lat: 40.4168, Lon: -3.7038}
+	// monday is a weekday at 9:00
+	monday = time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+)
+
+// east returns the point km kilometers east of p
+func east(p model.Coordinate, km float64) model.Coordinate {
+	lat, lon := util.AddKM(p.Lat, p.Lon, km, 90)
+	return model.Coordinate{Lat: lat, Lon: lon}
+}
+
+func TestShouldEstimateWithTheRegionOfTheOrigin(t *testing.T) {
+	baseline := NewBaseline([]Region{
+		{Name: "city", MinLat: 40, MinLon: -4, MaxLat: 41, MaxLon: -3, SpeedKmh: 20, DetourFactor: 1.5},
+	}, Region{Name: "default", SpeedKmh: 60, DetourFactor: 1})
+
+	tests := []struct {
+		name         string
+		from         model.Coordinate
+		wantDistance float64
+		wantDuration time.Duration
+	}{
+		{"inside the region", madrid, 15, 45 * time.Minute},
+		{"out of every region", model.Coordinate{Lat: 10, Lon: 10}, 10, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate, err := baseline.Estimate(tt.from, east(tt.from, 10), monday)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.wantDistance, estimate.DistanceKm, 0.01)
+			assert.InDelta(t, tt.wantDuration.Seconds(), estimate.Duration.Seconds(), 5)
+		})
+	}
+}
+
+func TestShouldEstimateRouteLegByLeg(t *testing.T) {
+	baseline := NewBaseline(nil, Region{SpeedKmh: 30, DetourFactor: 1})
+	route := []model.Coordinate{madrid, east(madrid, 5), east(madrid, 10)}
+
+	estimate, err := EstimateRoute(baseline, route, monday)
+	require.NoError(t, err)
+	assert.InDelta(t, 10, estimate.DistanceKm, 0.01)
+	assert.InDelta(t, 1200, estimate.Seconds(), 2)
+
+	_, err = EstimateRoute(baseline, route[:1], monday)
+	assert.ErrorIs(t, err, ErrEmptyRoute)
+}
+
+// samples returns n rides starting at the given time driven at speedKmh with the given detour
+func samples(n int, at time.Time, speedKmh, detour float64) []Sample {
+	result := make([]Sample, n)
+	for i := range result {
+		distance := 5 * detour
+		result[i] = Sample{
+			From:       madrid,
+			To:         east(madrid, 5),
+			StartedAt:  at,
+			DistanceKm: distance,
+			Duration:   time.Duration(distance / speedKmh * float64(time.Hour)),
+		}
+	}
+	return result
+}
+
+func TestShouldLearnSpeedsByHour(t *testing.T) {
+	baseline := NewDefaultBaseline()
+	var training []Sample
+	// Slow rush hour, fast nights and too few weekend rides
+	training = append(training, samples(30, monday, 12, 1.5)...)
+	training = append(training, samples(30, monday.Add(14*time.Hour), 40, 1.2)...)
+	training = append(training, samples(3, monday.Add(5*24*time.Hour), 60, 1.1)...)
+
+	model := Train(training, baseline, monday)
+	learned := NewLearned(model, baseline, 20)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantKm     float64
+		wantMinute float64
+	}{
+		{"learned hour", monday.Add(30 * time.Minute), 7.5, 37.5},
+		{"other learned hour", monday.Add(14 * time.Hour), 6, 9},
+		// The weekdays with no rides at that hour use the whole day, half the rides each
+		{"whole day", monday.Add(3 * time.Hour), 5 * 1.35, 5 * 1.35 / (7.5 + 6) * 60 * (7.5/12 + 6.0/40)},
+		// The weekend has too few rides, the baseline is used
+		{"baseline", monday.Add(5 * 24 * time.Hour), 5 * 1.35, 5 * 1.35 / 24 * 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate, err := learned.Estimate(madrid, east(madrid, 5), tt.at)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.wantKm, estimate.DistanceKm, 0.01)
+			assert.InDelta(t, tt.wantMinute, estimate.Duration.Minutes(), 0.05)
+		})
+	}
+}
+
+func TestShouldSaveAndLoadModel(t *testing.T) {
+	model := Train(samples(25, monday, 12, 1.5), NewDefaultBaseline(), monday)
+	path := filepath.Join(t.TempDir(), "eta.json")
+	var buf bytes.Buffer
+	require.NoError(t, model.Save(&buf))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	estimator, err := LoadEstimator(path)
+	require.NoError(t, err)
+	estimate, err := estimator.Estimate(madrid, east(madrid, 5), monday)
+	require.NoError(t, err)
+	assert.InDelta(t, 37.5, estimate.Duration.Minutes(), 0.05)
+
+	estimator, err = LoadEstimator("")
+	require.NoError(t, err)
+	assert.IsType(t, &Baseline{}, estimator)
+	_, err = LoadEstimator(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestShouldMeasureSampleOnTrail(t *testing.T) {
+	var points []model.TrailPoint
+	// 3km east in 6 minutes, a fix every 10 seconds
+	for i := 0; i <= 36; i++ {
+		p := east(madrid, 3*float64(i)/36)
+		points = append(points, model.TrailPoint{Latitude: p.Lat, Longitude: p.Lon, Accuracy: 5, RecordedAt: monday.Add(time.Duration(i) * 10 * time.Second)})
+	}
+
+	sample, ok := NewSample(points, trail.Options{})
+	require.True(t, ok)
+	assert.InDelta(t, 3, sample.DistanceKm, 0.1)
+	assert.Equal(t, 6*time.Minute, sample.Duration)
+	assert.Equal(t, monday, sample.StartedAt)
+
+	// A ride going nowhere is not a sample
+	_, ok = NewSample(points[:2], trail.Options{})
+	assert.False(t, ok)
+
+	// The pickup is driven from the east and the trip starts at the last fix at the pickup
+	pickup := &model.Ride{SrcLat: points[12].Latitude, SrcLon: points[12].Longitude}
+	var rideTrail []model.TrailPoint
+	for i := 24; i > 12; i-- {
+		rideTrail = append(rideTrail, points[i])
+	}
+	rideTrail = append(rideTrail, points[12], points[12])
+	rideTrail = append(rideTrail, points[12:]...)
+	assert.Equal(t, points[12:], TripTrail(rideTrail, pickup))
+}
+
+// fakeLocator places the drivers at fixed points
+type fakeLocator struct {
+	drivers map[string]model.Coordinate
+	order   []string
+}
+
+func (f *fakeLocator) GetNearbyDriversByClass(ctx context.Context, latitude, longitude, radius float64, class string) ([]string, error) {
+	return f.order, nil
+}
+
+func (f *fakeLocator) GetDriverLocation(ctx context.Context, driverID string) (float64, float64, error) {
+	p, ok := f.drivers[driverID]
+	if !ok {
+		return 0, 0, errors.New("driver not found")
+	}
+	return p.Lat, p.Lon, nil
+}
+
+func TestShouldSortNearbyDriversByETA(t *testing.T) {
+	locator := &fakeLocator{
+		drivers: map[string]model.Coordinate{"far": east(madrid, 3), "near": east(madrid, 1)},
+		order:   []string{"far", "gone", "near"},
+	}
+	baseline := NewBaseline(nil, Region{SpeedKmh: 30, DetourFactor: 1})
+
+	drivers, err := NearbyDrivers(context.Background(), locator, baseline, madrid, 5, "", monday)
+	require.NoError(t, err)
+	require.Len(t, drivers, 2)
+	assert.Equal(t, "near", drivers[0].DriverID)
+	assert.InDelta(t, 120, drivers[0].ETASeconds, 1)
+	assert.Equal(t, "far", drivers[1].DriverID)
+	assert.InDelta(t, 3, drivers[1].DistanceKm, 0.01)
+}
+
+func TestShouldEstimateRide(t *testing.T) {
+	locator := &fakeLocator{drivers: map[string]model.Coordinate{"near": east(madrid, 1)}, order: []string{"near"}}
+	baseline := NewBaseline(nil, Region{SpeedKmh: 30, DetourFactor: 1})
+	dst := east(madrid, 10)
+	ride := &model.Ride{SrcLat: madrid.Lat, SrcLon: madrid.Lon, DstLat: dst.Lat, DstLon: dst.Lon}
+
+	require.NoError(t, EstimateRide(context.Background(), baseline, locator, ride, 5, monday))
+	require.NotNil(t, ride.ETA)
+	assert.InDelta(t, 1200, ride.ETA.TripSeconds, 2)
+	require.NotNil(t, ride.ETA.PickupSeconds)
+	assert.InDelta(t, 120, *ride.ETA.PickupSeconds, 1)
+
+	// Scheduled rides have no driver around yet
+	later := monday.Add(time.Hour)
+	ride.ScheduledAt = &later
+	require.NoError(t, EstimateRide(context.Background(), baseline, locator, ride, 5, monday))
+	assert.Nil(t, ride.ETA.PickupSeconds)
+}
+
+// lineRouter only has the road from madrid to the east, at 60km/h
+type lineRouter struct{}
+
+func (lineRouter) Route(from, to model.Coordinate) (routing.Route, error) {
+	if from != madrid {
+		return routing.Route{}, routing.ErrNoRoute
+	}
+	distance := util.CalculateDistance(from.Lat, from.Lon, to.Lat, to.Lon)
+	return routing.Route{DistanceKm: distance, Duration: time.Duration(distance / 60 * float64(time.Hour))}, nil
+}
+
+func TestShouldEstimateAlongRoads(t *testing.T) {
+	estimator := NewRouted(lineRouter{}, NewBaseline(nil, Region{SpeedKmh: 30, DetourFactor: 1.5}))
+
+	estimate, err := estimator.Estimate(madrid, east(madrid, 10), monday)
+	require.NoError(t, err)
+	assert.InDelta(t, 10, estimate.DistanceKm, 0.01)
+	assert.InDelta(t, 600, estimate.Seconds(), 1)
+
+	// Out of the roads the baseline is used
+	estimate, err = estimator.Estimate(east(madrid, 1), east(madrid, 11), monday)
+	require.NoError(t, err)
+	assert.InDelta(t, 15, estimate.DistanceKm, 0.01)
+	assert.InDelta(t, 1800, estimate.Seconds(), 1)
+}
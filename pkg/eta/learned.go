@@ -0,0 +1,227 @@
+package eta
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/trail"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+const (
+	// DefaultMinSamples is the number of rides a bucket needs to be trusted
+	DefaultMinSamples = 20
+	// minSampleKm drops the rides too short to measure a detour, the GPS error is as long as the ride
+	minSampleKm = 0.3
+	// maxSampleSpeedKmh and maxSampleDetour drop the rides with a broken trail
+	maxSampleSpeedKmh = 150.0
+	maxSampleDetour   = 4.0
+	// allHours is the hour of the buckets measured over the whole day
+	allHours = -1
+)
+
+// Sample is a completed ride as measured on its trail
+type Sample struct {
+	From      model.Coordinate
+	To        model.Coordinate
+	StartedAt time.Time
+	// DistanceKm is the distance driven and Duration the time it took
+	DistanceKm float64
+	Duration   time.Duration
+}
+
+// NewSample measures a ride on its trail, the points must be in time order. It returns false when the
+// trail is too short or too broken to learn from it.
+func NewSample(points []model.TrailPoint, opts trail.Options) (Sample, bool) {
+	if len(points) < 2 {
+		return Sample{}, false
+	}
+	// The ends are taken from the cleaned path too, the smoothing lags behind the raw fixes
+	path := trail.Process(points, opts)
+	if len(path.Points) < 2 {
+		return Sample{}, false
+	}
+	sample := Sample{
+		From:       path.Points[0],
+		To:         path.Points[len(path.Points)-1],
+		StartedAt:  points[0].RecordedAt,
+		DistanceKm: path.DistanceKm,
+		Duration:   points[len(points)-1].RecordedAt.Sub(points[0].RecordedAt),
+	}
+	straight := sample.straightKm()
+	if straight < minSampleKm || sample.Duration <= 0 {
+		return Sample{}, false
+	}
+	speed := sample.DistanceKm / sample.Duration.Hours()
+	detour := sample.DistanceKm / straight
+	if speed > maxSampleSpeedKmh || detour < 1 || detour > maxSampleDetour {
+		return Sample{}, false
+	}
+	return sample, true
+}
+
+// TripTrail drops the pickup from the trail of a ride, the trail starts when the driver accepts the ride. The
+// trip starts at the last point closest to the pickup, where the driver waited for the passenger.
+func TripTrail(points []model.TrailPoint, ride *model.Ride) []model.TrailPoint {
+	start, closest := 0, math.Inf(1)
+	for i, p := range points {
+		if d := util.CalculateDistance(p.Latitude, p.Longitude, ride.SrcLat, ride.SrcLon); d <= closest {
+			start, closest = i, d
+		}
+	}
+	return points[start:]
+}
+
+func (s Sample) straightKm() float64 {
+	return util.CalculateDistance(s.From.Lat, s.From.Lon, s.To.Lat, s.To.Lon)
+}
+
+// Bucket is the speed and detour learned for a region on weekdays or weekends at an hour of the day, the
+// buckets with Hour -1 are measured over the whole day
+type Bucket struct {
+	Region       string  `json:"region"`
+	Weekend      bool    `json:"weekend"`
+	Hour         int     `json:"hour"`
+	SpeedKmh     float64 `json:"speed_kmh"`
+	DetourFactor float64 `json:"detour_factor"`
+	Samples      int     `json:"samples"`
+}
+
+type bucketKey struct {
+	region  string
+	weekend bool
+	hour    int
+}
+
+func keyOf(region string, at time.Time, hour int) bucketKey {
+	weekday := at.Weekday()
+	return bucketKey{region: region, weekend: weekday == time.Saturday || weekday == time.Sunday, hour: hour}
+}
+
+// Model is the result of a training, it is stored as JSON
+type Model struct {
+	TrainedAt time.Time `json:"trained_at"`
+	Buckets   []Bucket  `json:"buckets"`
+}
+
+// Train learns the speeds and detours of the samples, the samples are grouped by the region of the baseline
+// their origin is in. The hours are the ones of the location of StartedAt.
+func Train(samples []Sample, baseline *Baseline, now time.Time) *Model {
+	type totals struct {
+		straightKm, drivenKm, hours float64
+		samples                     int
+	}
+	sums := make(map[bucketKey]*totals)
+	add := func(key bucketKey, s Sample) {
+		t, ok := sums[key]
+		if !ok {
+			t = &totals{}
+			sums[key] = t
+		}
+		t.straightKm += s.straightKm()
+		t.drivenKm += s.DistanceKm
+		t.hours += s.Duration.Hours()
+		t.samples++
+	}
+	for _, s := range samples {
+		region := baseline.RegionOf(s.From).Name
+		add(keyOf(region, s.StartedAt, s.StartedAt.Hour()), s)
+		add(keyOf(region, s.StartedAt, allHours), s)
+	}
+
+	m := &Model{TrainedAt: now}
+	for key, t := range sums {
+		if t.straightKm <= 0 || t.hours <= 0 {
+			continue
+		}
+		m.Buckets = append(m.Buckets, Bucket{
+			Region:       key.region,
+			Weekend:      key.weekend,
+			Hour:         key.hour,
+			SpeedKmh:     t.drivenKm / t.hours,
+			DetourFactor: t.drivenKm / t.straightKm,
+			Samples:      t.samples,
+		})
+	}
+	sort.Slice(m.Buckets, func(i, j int) bool {
+		a, b := m.Buckets[i], m.Buckets[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.Weekend != b.Weekend {
+			return !a.Weekend
+		}
+		return a.Hour < b.Hour
+	})
+	return m
+}
+
+// Save writes the model as JSON
+func (m *Model) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// LoadModel reads a model saved as JSON
+func LoadModel(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m Model
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Learned estimates with the speed and detour learned for the region, day and hour of the departure. A bucket
+// with less than minSamples rides falls back to the whole day of the region and then to the baseline.
+type Learned struct {
+	baseline   *Baseline
+	buckets    map[bucketKey]Bucket
+	minSamples int
+}
+
+// NewLearned creates an estimator from a trained model, minSamples defaults to DefaultMinSamples
+func NewLearned(model *Model, baseline *Baseline, minSamples int) *Learned {
+	if minSamples <= 0 {
+		minSamples = DefaultMinSamples
+	}
+	buckets := make(map[bucketKey]Bucket, len(model.Buckets))
+	for _, b := range model.Buckets {
+		buckets[bucketKey{region: b.Region, weekend: b.Weekend, hour: b.Hour}] = b
+	}
+	return &Learned{baseline: baseline, buckets: buckets, minSamples: minSamples}
+}
+
+func (l *Learned) Estimate(from, to model.Coordinate, at time.Time) (Estimate, error) {
+	region := l.baseline.RegionOf(from).Name
+	for _, key := range []bucketKey{keyOf(region, at, at.Hour()), keyOf(region, at, allHours)} {
+		if b, ok := l.buckets[key]; ok && b.Samples >= l.minSamples {
+			return estimate(from, to, b.SpeedKmh, b.DetourFactor), nil
+		}
+	}
+	return l.baseline.Estimate(from, to, at)
+}
+
+// LoadEstimator returns the learned estimator of the model in path over the default baseline, or the default
+// baseline when there is no model
+func LoadEstimator(path string) (Estimator, error) {
+	baseline := NewDefaultBaseline()
+	if path == "" {
+		return baseline, nil
+	}
+	m, err := LoadModel(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLearned(m, baseline, DefaultMinSamples), nil
+}
@@ -0,0 +1,77 @@
+package eta
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// DriverLocator finds the drivers around a point, see location.LocationManager
+type DriverLocator interface {
+	GetNearbyDriversByClass(ctx context.Context, latitude, longitude, radius float64, class string) ([]string, error)
+	GetDriverLocation(ctx context.Context, driverID string) (float64, float64, error)
+}
+
+// NearbyDrivers returns the drivers of the class within radiusKm of the pickup with the time each one needs to
+// reach it, the closest in time first. The drivers gone since the search are skipped.
+func NearbyDrivers(ctx context.Context, locator DriverLocator, estimator Estimator, pickup model.Coordinate, radiusKm float64, class string, at time.Time) ([]model.NearbyDriver, error) {
+	ids, err := locator.GetNearbyDriversByClass(ctx, pickup.Lat, pickup.Lon, radiusKm, class)
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]model.NearbyDriver, 0, len(ids))
+	for _, id := range ids {
+		lat, lon, err := locator.GetDriverLocation(ctx, id)
+		if err != nil {
+			log.Printf("location of nearby driver %s: %v\n", id, err)
+			continue
+		}
+		estimate, err := estimator.Estimate(model.Coordinate{Lat: lat, Lon: lon}, pickup, at)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, model.NearbyDriver{
+			DriverID:   id,
+			Latitude:   lat,
+			Longitude:  lon,
+			DistanceKm: util.CalculateDistance(lat, lon, pickup.Lat, pickup.Lon),
+			ETASeconds: estimate.Seconds(),
+		})
+	}
+	sort.SliceStable(drivers, func(i, j int) bool {
+		return drivers[i].ETASeconds < drivers[j].ETASeconds
+	})
+	return drivers, nil
+}
+
+// EstimateRide fills the ETA of the ride: the trip along its route departing at the scheduled time or now, and
+// the pickup by the closest driver when a locator is given and the ride is not scheduled
+func EstimateRide(ctx context.Context, estimator Estimator, locator DriverLocator, ride *model.Ride, radiusKm float64, now time.Time) error {
+	departure := now
+	if ride.IsScheduled(now) {
+		departure = *ride.ScheduledAt
+	}
+	trip, err := EstimateRoute(estimator, ride.Route(), departure)
+	if err != nil {
+		return err
+	}
+	ride.ETA = &model.RideETA{TripSeconds: trip.Seconds(), TripDistanceKm: trip.DistanceKm}
+
+	if locator == nil || ride.IsScheduled(now) {
+		return nil
+	}
+	pickup := model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}
+	drivers, err := NearbyDrivers(ctx, locator, estimator, pickup, radiusKm, string(ride.VehicleClass), now)
+	if err != nil {
+		return err
+	}
+	if len(drivers) > 0 {
+		ride.ETA.PickupSeconds = &drivers[0].ETASeconds
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package eta
+
+import (
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/routing"
+)
+
+// Routed estimates with the drive along the roads of the router, the points out of the roads use the
+// fallback estimator
+type Routed struct {
+	router   routing.Router
+	fallback Estimator
+}
+
+// NewRouted creates an estimator on the router, usually the baseline or the learned estimator as fallback
+func NewRouted(router routing.Router, fallback Estimator) *Routed {
+	return &Routed{router: router, fallback: fallback}
+}
+
+func (r *Routed) Estimate(from, to model.Coordinate, at time.Time) (Estimate, error) {
+	route, err := r.router.Route(from, to)
+	if err != nil {
+		return r.fallback.Estimate(from, to, at)
+	}
+	return Estimate{DistanceKm: route.DistanceKm, Duration: route.Duration}, nil
+}
@@ -9,8 +9,17 @@ import (
 // SaveDriverLocation saves the location of a driver given its ID and coordinates
 // RemoveDriverLocation removes the location of a driver given its ID
 // GetNearbyDrivers returns the IDs of the drivers that are near a given location and a radius in kilometers
+// SetDriverVehicleClass records the class of the vehicle of a driver so the search can be filtered by class,
+// an empty class forgets it
+// GetNearbyDriversByClass returns the nearby drivers whose vehicle is of the given class
+// GetDriverLocation returns the last known coordinates of a driver
+// FlagDriverLocation records that the locations of a driver look spoofed, for the operators to review
 type LocationManager interface {
 	SaveDriverLocation(ctx context.Context, driverID string, latitude, longitude float64) error
 	RemoveDriverLocation(ctx context.Context, driverID string) error
 	GetNearbyDrivers(ctx context.Context, latitude, longitude, radius float64) ([]string, error)
+	SetDriverVehicleClass(ctx context.Context, driverID string, class string) error
+	GetNearbyDriversByClass(ctx context.Context, latitude, longitude, radius float64, class string) ([]string, error)
+	GetDriverLocation(ctx context.Context, driverID string) (float64, float64, error)
+	FlagDriverLocation(ctx context.Context, driverID string, reason string) error
 }
@@ -0,0 +1,80 @@
+package location
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// rideOffersKeyPrefix is the prefix of the sorted set of the drivers offered every ride, the drivers are
+// scored by the expiration of their offer
+const rideOffersKeyPrefix = "ride_offers:"
+
+// OfferRegistry records the drivers a ride was offered to, so only them can accept it while the offer lasts
+// RecordOffer records the offer of the ride to the driver until expiresAt, offering it again extends it
+// HasOffer returns true when the driver has an offer of the ride that did not expire
+// DeclineOffer withdraws the offer of the ride to the driver, the driver declined it
+// ClearOffers forgets the offers of the ride, once a driver took it
+type OfferRegistry interface {
+	RecordOffer(ctx context.Context, rideID int, driverID string, expiresAt time.Time) error
+	HasOffer(ctx context.Context, rideID int, driverID string) (bool, error)
+	DeclineOffer(ctx context.Context, rideID int, driverID string) error
+	ClearOffers(ctx context.Context, rideID int) error
+}
+
+// recordOfferScript records the offer and keeps the set until the last offer expires
+var recordOfferScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+return 0
+`)
+
+// RedisOfferRegistry is an OfferRegistry with a sorted set per ride, so the offers sent by any instance of the
+// driver service can be accepted in any other
+type RedisOfferRegistry struct {
+	redisClient *redis.Client
+}
+
+// NewRedisOfferRegistry creates a RedisOfferRegistry
+func NewRedisOfferRegistry(redisAddr string) *RedisOfferRegistry {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisOfferRegistry{redisClient: rdb}
+}
+
+func rideOffersKey(rideID int) string {
+	return rideOffersKeyPrefix + strconv.Itoa(rideID)
+}
+
+// RecordOffer records the offer of the ride to the driver until expiresAt
+func (o *RedisOfferRegistry) RecordOffer(ctx context.Context, rideID int, driverID string, expiresAt time.Time) error {
+	return recordOfferScript.Run(ctx, o.redisClient, []string{rideOffersKey(rideID)}, driverID, expiresAt.UnixMilli()).Err()
+}
+
+// HasOffer returns true when the offer of the ride to the driver did not expire
+func (o *RedisOfferRegistry) HasOffer(ctx context.Context, rideID int, driverID string) (bool, error) {
+	expiresAt, err := o.redisClient.ZScore(ctx, rideOffersKey(rideID), driverID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return int64(expiresAt) > time.Now().UnixMilli(), nil
+}
+
+// DeclineOffer removes the offer of the ride to the driver
+func (o *RedisOfferRegistry) DeclineOffer(ctx context.Context, rideID int, driverID string) error {
+	return o.redisClient.ZRem(ctx, rideOffersKey(rideID), driverID).Err()
+}
+
+// ClearOffers removes the offers of the ride
+func (o *RedisOfferRegistry) ClearOffers(ctx context.Context, rideID int) error {
+	return o.redisClient.Del(ctx, rideOffersKey(rideID)).Err()
+}
@@ -0,0 +1,55 @@
+package location
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldOnlyAcceptOfferedDrivers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 9)
+	offers := &RedisOfferRegistry{redisClient: rdb}
+
+	now := time.Now()
+	require.NoError(t, offers.RecordOffer(ctx, 1, "driver1", now.Add(time.Minute)))
+	require.NoError(t, offers.RecordOffer(ctx, 1, "driver2", now.Add(-time.Second)))
+
+	tests := []struct {
+		name     string
+		rideID   int
+		driverID string
+		expected bool
+	}{
+		{name: "Offered driver", rideID: 1, driverID: "driver1", expected: true},
+		{name: "Expired offer", rideID: 1, driverID: "driver2", expected: false},
+		{name: "Driver never offered", rideID: 1, driverID: "driver3", expected: false},
+		{name: "Offer of another ride", rideID: 2, driverID: "driver1", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offered, err := offers.HasOffer(ctx, tt.rideID, tt.driverID)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, offered)
+		})
+	}
+
+	// The set lives as long as the last offer
+	ttl, err := rdb.PTTL(ctx, rideOffersKey(1)).Result()
+	require.NoError(t, err)
+	require.Greater(t, ttl, 50*time.Second)
+
+	require.NoError(t, offers.RecordOffer(ctx, 1, "driver3", now.Add(time.Minute)))
+	require.NoError(t, offers.DeclineOffer(ctx, 1, "driver3"))
+	offered, err := offers.HasOffer(ctx, 1, "driver3")
+	require.NoError(t, err)
+	require.False(t, offered)
+
+	require.NoError(t, offers.ClearOffers(ctx, 1))
+	offered, err = offers.HasOffer(ctx, 1, "driver1")
+	require.NoError(t, err)
+	require.False(t, offered)
+}
@@ -2,6 +2,8 @@ package location
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -11,6 +13,10 @@ const (
 	// for now there will be only one key devoted for drivers, we will see if there
 	// is a need to split this into multiple keys in the future
 	driverLocationKey = "driver_location"
+	// driverVehicleClassKey is the hash holding the vehicle class of every driver
+	driverVehicleClassKey = "driver_vehicle_class"
+	// driverLocationFlagsKey is the hash holding the last spoofing flag of every flagged driver
+	driverLocationFlagsKey = "driver_location_flags"
 )
 
 // RedisLocationService is a struct that implements the LocationManager interface
@@ -111,3 +117,61 @@ func (r *RedisLocationService) RemoveDriverLocation(ctx context.Context, driverI
 
 	return nil
 }
+
+// SetDriverVehicleClass records the class of the vehicle of a driver, an empty class removes it so a driver
+// without vehicle is not found by class
+func (r *RedisLocationService) SetDriverVehicleClass(ctx context.Context, driverID string, class string) error {
+	if class == "" {
+		return r.redisClient.HDel(ctx, driverVehicleClassKey, driverID).Err()
+	}
+	_, err := r.redisClient.HSet(ctx, driverVehicleClassKey, driverID, class).Result()
+	return err
+}
+
+// GetNearbyDriversByClass returns the IDs of the nearby drivers whose vehicle is of the given class, an empty
+// class returns all the nearby drivers. The drivers are kept in a single geo key and filtered afterwards
+// with the class hash, so the location updates don't need to know the vehicle of the driver.
+func (r *RedisLocationService) GetNearbyDriversByClass(
+	ctx context.Context,
+	passengerLatitude,
+	passengerLongitude,
+	radius float64,
+	class string,
+) ([]string, error) {
+	drivers, err := r.GetNearbyDrivers(ctx, passengerLatitude, passengerLongitude, radius)
+	if err != nil || class == "" || len(drivers) == 0 {
+		return drivers, err
+	}
+
+	classes, err := r.redisClient.HMGet(ctx, driverVehicleClassKey, drivers...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []string
+	for i, driverClass := range classes {
+		if c, ok := driverClass.(string); ok && c == class {
+			filtered = append(filtered, drivers[i])
+		}
+	}
+	return filtered, nil
+}
+
+// GetDriverLocation returns the last known latitude and longitude of a driver
+func (r *RedisLocationService) GetDriverLocation(ctx context.Context, driverID string) (float64, float64, error) {
+	res, err := r.redisClient.GeoPos(ctx, driverLocationKey, driverID).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) == 0 || res[0] == nil {
+		return 0, 0, fmt.Errorf("location not found for driver %s", driverID)
+	}
+	return res[0].Latitude, res[0].Longitude, nil
+}
+
+// FlagDriverLocation records the time and the reason of the last spoofing flag of a driver, the flags are kept
+// until the operators clear them
+func (r *RedisLocationService) FlagDriverLocation(ctx context.Context, driverID string, reason string) error {
+	value := time.Now().UTC().Format(time.RFC3339) + " " + reason
+	return r.redisClient.HSet(ctx, driverLocationFlagsKey, driverID, value).Err()
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/OscarMoya/Glubber/pkg/model"
 	"github.com/OscarMoya/Glubber/pkg/util"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
@@ -212,3 +213,215 @@ func TestShouldGetNearbyDrivers(t *testing.T) {
 		})
 	}
 }
+
+// TestShouldGetNearbyDriversByClass tests the GetNearbyDriversByClass method of the RedisLocationService
+func TestShouldGetNearbyDriversByClass(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 4)
+	service := &RedisLocationService{redisClient: rdb}
+
+	baseLatitude := 40.7128
+	baseLongitude := -74.0
+
+	// Three drivers close to the base location, two economy and one XL
+	drivers := map[string]string{
+		"driver1": "economy",
+		"driver2": "xl",
+		"driver3": "economy",
+	}
+	for driverID, class := range drivers {
+		lat, lon := util.AddKM(baseLatitude, baseLongitude, 1, 90)
+		require.NoError(t, service.SaveDriverLocation(ctx, driverID, lat, lon))
+		require.NoError(t, service.SetDriverVehicleClass(ctx, driverID, class))
+	}
+
+	tests := []struct {
+		name            string
+		class           string
+		expectedDrivers int
+	}{
+		{
+			name:            "Any class",
+			class:           "",
+			expectedDrivers: 3,
+		},
+		{
+			name:            "Economy",
+			class:           "economy",
+			expectedDrivers: 2,
+		},
+		{
+			name:            "XL",
+			class:           "xl",
+			expectedDrivers: 1,
+		},
+		{
+			name:            "Premium",
+			class:           "premium",
+			expectedDrivers: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := service.GetNearbyDriversByClass(ctx, baseLatitude, baseLongitude, 5, tt.class)
+			require.NoError(t, err)
+			assert.Len(t, result, tt.expectedDrivers)
+		})
+	}
+}
+
+// TestShouldGetDriverLocation tests the GetDriverLocation method of the RedisLocationService
+func TestShouldGetDriverLocation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 5)
+	service := &RedisLocationService{redisClient: rdb}
+
+	err := service.SaveDriverLocation(ctx, "driver1", 40.7128, -74.0060)
+	require.NoError(t, err)
+
+	lat, lon, err := service.GetDriverLocation(ctx, "driver1")
+	require.NoError(t, err)
+	// Redis stores the coordinates as a geohash so there is a small precision loss
+	assert.InDelta(t, 40.7128, lat, 0.0001)
+	assert.InDelta(t, -74.0060, lon, 0.0001)
+
+	_, _, err = service.GetDriverLocation(ctx, "driver2")
+	assert.Error(t, err)
+}
+
+func TestShouldFlagDriverLocation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 6)
+	service := &RedisLocationService{redisClient: rdb}
+
+	err := service.FlagDriverLocation(ctx, "driver1", "impossible speed between fixes")
+	require.NoError(t, err)
+
+	flag, err := rdb.HGet(ctx, driverLocationFlagsKey, "driver1").Result()
+	require.NoError(t, err)
+	assert.Contains(t, flag, "impossible speed between fixes")
+}
+
+// sliceSink keeps the flushed points in memory
+type sliceSink struct {
+	points []model.TrailPoint
+}
+
+func (s *sliceSink) SaveTrailPoints(ctx context.Context, points []model.TrailPoint) error {
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func TestShouldAppendAndFlushTrail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 7)
+	store := &RedisTrailStore{redisClient: rdb, retention: time.Hour}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err := store.AppendFixes(ctx, "1", []model.DriverLocationFix{{Latitude: 40.41, Longitude: -3.70, Accuracy: 5, Timestamp: now}})
+	require.NoError(t, err)
+	err = store.SetActiveRide(ctx, "1", 10)
+	require.NoError(t, err)
+	err = store.AppendFixes(ctx, "1", []model.DriverLocationFix{{Latitude: 40.42, Longitude: -3.71, Timestamp: now.Add(time.Second)}})
+	require.NoError(t, err)
+	// Another ride was assigned meanwhile, the clear of the old one is ignored
+	err = store.ClearActiveRide(ctx, "1", 9)
+	require.NoError(t, err)
+
+	sink := &sliceSink{}
+	err = store.Flush(ctx, sink)
+	require.NoError(t, err)
+	require.Len(t, sink.points, 2)
+	assert.Nil(t, sink.points[0].RideID)
+	assert.Equal(t, 5.0, sink.points[0].Accuracy)
+	assert.True(t, now.Equal(sink.points[0].RecordedAt))
+	require.NotNil(t, sink.points[1].RideID)
+	assert.Equal(t, 10, *sink.points[1].RideID)
+
+	// Only the new fixes are flushed the next time
+	err = store.ClearActiveRide(ctx, "1", 10)
+	require.NoError(t, err)
+	err = store.AppendFixes(ctx, "1", []model.DriverLocationFix{{Latitude: 40.43, Longitude: -3.72, Timestamp: now.Add(2 * time.Second)}})
+	require.NoError(t, err)
+	err = store.Flush(ctx, sink)
+	require.NoError(t, err)
+	require.Len(t, sink.points, 3)
+	assert.Nil(t, sink.points[2].RideID)
+}
+
+func TestShouldTrimTrailsOfOfflineDrivers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 7)
+	store := &RedisTrailStore{redisClient: rdb, retention: 50 * time.Millisecond}
+
+	err := store.AppendFixes(ctx, "1", []model.DriverLocationFix{{Latitude: 40.41, Longitude: -3.70, Timestamp: time.Now()}})
+	require.NoError(t, err)
+	// The driver sends nothing else, the flush trims the stream once the retention expires
+	time.Sleep(100 * time.Millisecond)
+	sink := &sliceSink{}
+	err = store.Flush(ctx, sink)
+	require.NoError(t, err)
+	assert.Len(t, sink.points, 1)
+
+	length, err := rdb.XLen(ctx, driverTrailKeyPrefix+"1").Result()
+	require.NoError(t, err)
+	assert.Zero(t, length)
+	drivers, err := rdb.SMembers(ctx, driverTrailDriversKey).Result()
+	require.NoError(t, err)
+	assert.Empty(t, drivers)
+}
+
+func TestShouldQueueDriversInStagingZones(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rdb := setupTestRedis(ctx, 8)
+	queue := &RedisStagingQueue{redisClient: rdb}
+	service := &RedisLocationService{redisClient: rdb}
+
+	for i, driverID := range []string{"driver1", "driver2", "driver3"} {
+		position, err := queue.Enter(ctx, 1, driverID)
+		require.NoError(t, err)
+		assert.Equal(t, i, position)
+		time.Sleep(2 * time.Millisecond)
+	}
+	// Entering again keeps the spot
+	position, err := queue.Enter(ctx, 1, "driver1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, position)
+
+	require.NoError(t, service.SetDriverVehicleClass(ctx, "driver2", "xl"))
+	drivers, err := queue.Drivers(ctx, 1, "xl")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver2"}, drivers)
+
+	// Leaving loses the spot, coming back queues the driver at the end
+	require.NoError(t, queue.Leave(ctx, "driver1"))
+	position, err = queue.Enter(ctx, 1, "driver1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, position)
+
+	// Entering another zone leaves the first one
+	_, err = queue.Enter(ctx, 2, "driver2")
+	require.NoError(t, err)
+	drivers, err = queue.Drivers(ctx, 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver3", "driver1"}, drivers)
+
+	// Busy drivers are out of the queues until their ride ends
+	require.NoError(t, queue.SetBusy(ctx, "driver3", true))
+	_, err = queue.Enter(ctx, 1, "driver3")
+	assert.ErrorIs(t, err, ErrDriverBusy)
+	drivers, err = queue.Drivers(ctx, 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"driver1"}, drivers)
+	require.NoError(t, queue.SetBusy(ctx, "driver3", false))
+	position, err = queue.Enter(ctx, 1, "driver3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, position)
+}
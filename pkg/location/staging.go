@@ -0,0 +1,137 @@
+package location
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// stagingQueueKeyPrefix is the prefix of the sorted set of every staging zone, the drivers are scored by
+	// the time they entered
+	stagingQueueKeyPrefix = "staging_queue:"
+	// stagingDriverZoneKey is the hash holding the staging zone of every queued driver
+	stagingDriverZoneKey = "staging_driver_zone"
+	// stagingBusyKeyPrefix prefixes the keys marking the drivers with a ride, they are not queued until the
+	// ride ends
+	stagingBusyKeyPrefix = "staging_busy:"
+	// stagingBusyTTL outlasts any ride, a driver whose ride end was missed is queued again once it expires.
+	// Every change of the ride refreshes it.
+	stagingBusyTTL = 4 * time.Hour
+)
+
+// ErrDriverBusy is returned when a driver with a ride enters a staging zone
+var ErrDriverBusy = errors.New("driver busy with a ride")
+
+// StagingQueue keeps the drivers waiting in the staging zones in the order they entered
+// Enter queues the driver at the end of the zone, or keeps its spot if it was already there, and returns its
+// position counting from 0. Entering another zone leaves the previous one.
+// Leave removes the driver from its queue, it loses its spot
+// SetBusy keeps the drivers with a ride out of the queues, a busy driver leaves its queue
+// Drivers returns the queue of the zone in order, filtered by vehicle class when the class is not empty
+type StagingQueue interface {
+	Enter(ctx context.Context, zoneID int, driverID string) (int, error)
+	Leave(ctx context.Context, driverID string) error
+	SetBusy(ctx context.Context, driverID string, busy bool) error
+	Drivers(ctx context.Context, zoneID int, class string) ([]string, error)
+}
+
+// enterStagingScript queues a driver that is not busy and keeps the spot of a driver already queued, the
+// driver leaves the queue of any other zone. It returns the position or -1 for busy drivers.
+var enterStagingScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return -1
+end
+local current = redis.call('HGET', KEYS[2], ARGV[1])
+if current and current ~= ARGV[2] then
+	redis.call('ZREM', ARGV[4] .. current, ARGV[1])
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[1], 'NX', ARGV[3], ARGV[1])
+return redis.call('ZRANK', KEYS[1], ARGV[1])
+`)
+
+// leaveStagingScript removes a driver from the queue of its zone
+var leaveStagingScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if current then
+	redis.call('ZREM', ARGV[2] .. current, ARGV[1])
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// RedisStagingQueue is a StagingQueue with a sorted set per zone, so the queues survive the restarts of the
+// driver service and are shared by its instances
+type RedisStagingQueue struct {
+	redisClient *redis.Client
+}
+
+// NewRedisStagingQueue creates a RedisStagingQueue
+func NewRedisStagingQueue(redisAddr string) *RedisStagingQueue {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisStagingQueue{redisClient: rdb}
+}
+
+func stagingQueueKey(zoneID int) string {
+	return stagingQueueKeyPrefix + strconv.Itoa(zoneID)
+}
+
+// Enter queues the driver in the zone, busy drivers return ErrDriverBusy
+func (q *RedisStagingQueue) Enter(ctx context.Context, zoneID int, driverID string) (int, error) {
+	keys := []string{stagingQueueKey(zoneID), stagingDriverZoneKey, stagingBusyKeyPrefix + driverID}
+	position, err := enterStagingScript.Run(ctx, q.redisClient, keys,
+		driverID, zoneID, time.Now().UnixMilli(), stagingQueueKeyPrefix).Int()
+	if err != nil {
+		return 0, err
+	}
+	if position < 0 {
+		return 0, ErrDriverBusy
+	}
+	return position, nil
+}
+
+// Leave removes the driver from its queue, it does nothing when the driver is not queued
+func (q *RedisStagingQueue) Leave(ctx context.Context, driverID string) error {
+	return leaveStagingScript.Run(ctx, q.redisClient, []string{stagingDriverZoneKey}, driverID, stagingQueueKeyPrefix).Err()
+}
+
+// SetBusy marks the driver busy or available, the busy mark is set before leaving so the driver can not enter
+// again meanwhile. The mark expires after stagingBusyTTL.
+func (q *RedisStagingQueue) SetBusy(ctx context.Context, driverID string, busy bool) error {
+	if !busy {
+		return q.redisClient.Del(ctx, stagingBusyKeyPrefix+driverID).Err()
+	}
+	if err := q.redisClient.Set(ctx, stagingBusyKeyPrefix+driverID, 1, stagingBusyTTL).Err(); err != nil {
+		return err
+	}
+	return q.Leave(ctx, driverID)
+}
+
+// Drivers returns the drivers queued in the zone, the first one entered first. The vehicle classes are the ones
+// recorded by RedisLocationService.SetDriverVehicleClass.
+func (q *RedisStagingQueue) Drivers(ctx context.Context, zoneID int, class string) ([]string, error) {
+	drivers, err := q.redisClient.ZRange(ctx, stagingQueueKey(zoneID), 0, -1).Result()
+	if err != nil || class == "" || len(drivers) == 0 {
+		return drivers, err
+	}
+
+	classes, err := q.redisClient.HMGet(ctx, driverVehicleClassKey, drivers...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var filtered []string
+	for i, driverClass := range classes {
+		if c, ok := driverClass.(string); ok && c == class {
+			filtered = append(filtered, drivers[i])
+		}
+	}
+	return filtered, nil
+}
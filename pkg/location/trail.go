@@ -0,0 +1,214 @@
+package location
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// driverTrailKeyPrefix is the stream with the recent fixes of a driver
+	driverTrailKeyPrefix = "driver_trail:"
+	// driverActiveRideKeyPrefix holds the ride the driver is doing, the fixes appended meanwhile carry it
+	driverActiveRideKeyPrefix = "driver_active_ride:"
+	// driverTrailDriversKey is the set of drivers whose stream has fixes, the flush walks it
+	driverTrailDriversKey = "driver_trail_drivers"
+	// driverTrailFlushedKey is the hash with the ID of the last flushed fix of every driver
+	driverTrailFlushedKey = "driver_trail_flushed"
+
+	// activeRideTTL bounds a ride that is never cleared, no ride lasts that long
+	activeRideTTL = 24 * time.Hour
+	// trailFlushBatch is the number of fixes read from a stream at once while flushing
+	trailFlushBatch = 500
+)
+
+// appendTrailScript appends a fix with the active ride of the driver, trimming the fixes older than the
+// retention, and registers the driver for the flush
+var appendTrailScript = redis.NewScript(`
+local ride = redis.call('GET', KEYS[2])
+redis.call('SADD', KEYS[3], ARGV[1])
+return redis.call('XADD', KEYS[1], 'MINID', '~', ARGV[2], '*',
+	'lat', ARGV[3], 'lon', ARGV[4], 'acc', ARGV[5], 'ts', ARGV[6], 'ride', ride or '')
+`)
+
+// clearActiveRideScript clears the active ride only if it is still the given one
+var clearActiveRideScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// forgetTrailScript trims the fixes older than the retention, the stream of a driver who stopped sending is
+// not trimmed by the appends, and drops the driver from the flush once its stream is empty, atomically so a
+// fix appended meanwhile keeps it
+var forgetTrailScript = redis.NewScript(`
+redis.call('XTRIM', KEYS[1], 'MINID', ARGV[2])
+if redis.call('XLEN', KEYS[1]) == 0 then
+	redis.call('SREM', KEYS[2], ARGV[1])
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+return 0
+`)
+
+// TrailRecorder records the fixes of the drivers along with the ride they are doing
+type TrailRecorder interface {
+	AppendFixes(ctx context.Context, driverID string, fixes []model.DriverLocationFix) error
+	SetActiveRide(ctx context.Context, driverID string, rideID int) error
+	ClearActiveRide(ctx context.Context, driverID string, rideID int) error
+}
+
+// TrailSink stores the fixes flushed from the trail streams, it must skip the points already stored since a
+// flush interrupted halfway is retried
+type TrailSink interface {
+	SaveTrailPoints(ctx context.Context, points []model.TrailPoint) error
+}
+
+// RedisTrailStore keeps the recent fixes of every driver in a Redis stream trimmed to the retention window,
+// they are flushed periodically to a TrailSink which keeps the whole history
+type RedisTrailStore struct {
+	redisClient *redis.Client
+	retention   time.Duration
+}
+
+// NewRedisTrailStore creates a RedisTrailStore keeping the fixes of the last retention in Redis
+func NewRedisTrailStore(redisAddr string, retention time.Duration) *RedisTrailStore {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisTrailStore{redisClient: rdb, retention: retention}
+}
+
+// AppendFixes appends the fixes to the trail of the driver, in a single round trip
+func (r *RedisTrailStore) AppendFixes(ctx context.Context, driverID string, fixes []model.DriverLocationFix) error {
+	minID := strconv.FormatInt(time.Now().Add(-r.retention).UnixMilli(), 10)
+	keys := []string{driverTrailKeyPrefix + driverID, driverActiveRideKeyPrefix + driverID, driverTrailDriversKey}
+
+	pipe := r.redisClient.Pipeline()
+	for _, fix := range fixes {
+		// EVALSHA can't fall back to EVAL inside a pipeline, the script is sent with every fix
+		appendTrailScript.Eval(ctx, pipe, keys,
+			driverID,
+			minID,
+			strconv.FormatFloat(fix.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(fix.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(fix.Accuracy, 'f', -1, 64),
+			strconv.FormatInt(fix.Timestamp.UnixMilli(), 10),
+		)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetActiveRide attaches the fixes appended from now on to the ride
+func (r *RedisTrailStore) SetActiveRide(ctx context.Context, driverID string, rideID int) error {
+	return r.redisClient.Set(ctx, driverActiveRideKeyPrefix+driverID, rideID, activeRideTTL).Err()
+}
+
+// ClearActiveRide stops attaching the fixes to the ride, unless the driver moved to another ride meanwhile
+func (r *RedisTrailStore) ClearActiveRide(ctx context.Context, driverID string, rideID int) error {
+	return clearActiveRideScript.Run(ctx, r.redisClient, []string{driverActiveRideKeyPrefix + driverID}, rideID).Err()
+}
+
+// Flush moves the fixes not flushed yet to the sink. The streams keep the fixes until the retention expires,
+// so the flush only remembers the last flushed ID of every driver.
+func (r *RedisTrailStore) Flush(ctx context.Context, sink TrailSink) error {
+	drivers, err := r.redisClient.SMembers(ctx, driverTrailDriversKey).Result()
+	if err != nil {
+		return err
+	}
+	// A driver failing does not hold back the rest, the first error is returned
+	var firstErr error
+	for _, driverID := range drivers {
+		if err := r.flushDriver(ctx, sink, driverID); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("flush trail of driver %s: %w", driverID, err)
+		}
+	}
+	return firstErr
+}
+
+func (r *RedisTrailStore) flushDriver(ctx context.Context, sink TrailSink, driverID string) error {
+	id, err := strconv.Atoi(driverID)
+	if err != nil {
+		return err
+	}
+	key := driverTrailKeyPrefix + driverID
+
+	last, err := r.redisClient.HGet(ctx, driverTrailFlushedKey, driverID).Result()
+	if err == redis.Nil {
+		last = "0"
+	} else if err != nil {
+		return err
+	}
+
+	for {
+		messages, err := r.redisClient.XRangeN(ctx, key, "("+last, "+", trailFlushBatch).Result()
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		points := make([]model.TrailPoint, 0, len(messages))
+		for _, msg := range messages {
+			points = append(points, trailPoint(id, msg))
+		}
+		if err := sink.SaveTrailPoints(ctx, points); err != nil {
+			return err
+		}
+		last = messages[len(messages)-1].ID
+		if err := r.redisClient.HSet(ctx, driverTrailFlushedKey, driverID, last).Err(); err != nil {
+			return err
+		}
+		if len(messages) < trailFlushBatch {
+			break
+		}
+	}
+
+	// The fixes are flushed up to last, the ones past the retention can go
+	minID := strconv.FormatInt(time.Now().Add(-r.retention).UnixMilli(), 10)
+	keys := []string{key, driverTrailDriversKey, driverTrailFlushedKey}
+	return forgetTrailScript.Run(ctx, r.redisClient, keys, driverID, minID).Err()
+}
+
+// RunFlusher flushes the trails every interval until the context is done
+func (r *RedisTrailStore) RunFlusher(ctx context.Context, sink TrailSink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx, sink); err != nil {
+				log.Println("flush trails:", err)
+			}
+		}
+	}
+}
+
+// trailPoint parses a fix of the stream, the fields are written by appendTrailScript
+func trailPoint(driverID int, msg redis.XMessage) model.TrailPoint {
+	field := func(name string) string {
+		value, _ := msg.Values[name].(string)
+		return value
+	}
+	p := model.TrailPoint{DriverID: driverID, StreamID: msg.ID}
+	p.Latitude, _ = strconv.ParseFloat(field("lat"), 64)
+	p.Longitude, _ = strconv.ParseFloat(field("lon"), 64)
+	p.Accuracy, _ = strconv.ParseFloat(field("acc"), 64)
+	ts, _ := strconv.ParseInt(field("ts"), 10, 64)
+	p.RecordedAt = time.UnixMilli(ts).UTC()
+	if rideID, err := strconv.Atoi(field("ride")); err == nil {
+		p.RideID = &rideID
+	}
+	return p
+}
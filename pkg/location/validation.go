@@ -0,0 +1,132 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+var (
+	// ErrInvalidCoordinates is returned for fixes out of the latitude and longitude ranges
+	ErrInvalidCoordinates = errors.New("coordinates out of range")
+	// ErrInaccurateFix is returned for fixes whose accuracy radius is too large to be useful
+	ErrInaccurateFix = errors.New("fix too inaccurate")
+	// ErrStaleFix is returned for fixes not newer than the last accepted one, they arrive out of order
+	ErrStaleFix = errors.New("fix older than the last one")
+	// ErrFutureFix is returned for fixes taken after the time they are received
+	ErrFutureFix = errors.New("fix taken in the future")
+	// ErrImpossibleSpeed is returned for fixes too far from the last accepted one for the time between them
+	ErrImpossibleSpeed = errors.New("impossible speed between fixes")
+)
+
+// FixValidatorOpts are the limits of a FixValidator, the zero values take the defaults
+type FixValidatorOpts struct {
+	// MaxSpeedKmh is the highest speed believable between two fixes, 250km/h by default
+	MaxSpeedKmh float64
+	// MaxAccuracy is the largest accuracy radius in meters accepted, 500m by default
+	MaxAccuracy float64
+	// MaxClockSkew is how far in the future the clock of the device can be, 30s by default
+	MaxClockSkew time.Duration
+	// SpoofSignals within SpoofWindow flag the driver, 3 signals in 10 minutes by default
+	SpoofSignals int
+	SpoofWindow  time.Duration
+	// ReanchorAfter consecutive fixes rejected for their speed replace the last accepted fix with the latest of
+	// them, 5 by default. A wrong fix accepted once does not reject every later fix of the driver.
+	ReanchorAfter int
+}
+
+// FixValidator checks the fixes of a driver one after the other. Fixes out of range or too inaccurate are
+// rejected, and so are the ones implying an impossible speed since the last accepted fix or taken in the future,
+// which are also counted as spoofing signals. After ReanchorAfter fixes in a row rejected for their speed the
+// latest one is accepted, a driver that keeps jumping is flagged meanwhile. The accuracy of both fixes is
+// subtracted from the distance so the jitter of a stopped phone is not mistaken for a jump.
+// A FixValidator is not safe for concurrent use, it is meant to be owned by the session of the driver.
+type FixValidator struct {
+	opts    FixValidatorOpts
+	last    *model.DriverLocationFix
+	signals []time.Time
+	// rejected counts the fixes rejected for their speed since the last accepted one
+	rejected int
+}
+
+// NewFixValidator creates a FixValidator with the given limits
+func NewFixValidator(opts FixValidatorOpts) *FixValidator {
+	if opts.MaxSpeedKmh <= 0 {
+		opts.MaxSpeedKmh = 250
+	}
+	if opts.MaxAccuracy <= 0 {
+		opts.MaxAccuracy = 500
+	}
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = 30 * time.Second
+	}
+	if opts.SpoofSignals <= 0 {
+		opts.SpoofSignals = 3
+	}
+	if opts.SpoofWindow <= 0 {
+		opts.SpoofWindow = 10 * time.Minute
+	}
+	if opts.ReanchorAfter <= 0 {
+		opts.ReanchorAfter = 5
+	}
+	return &FixValidator{opts: opts}
+}
+
+// Validate returns nil when the fix is believable and remembers it as the last accepted fix. now is the time the
+// fix was received.
+func (v *FixValidator) Validate(fix model.DriverLocationFix, now time.Time) error {
+	if fix.Latitude < -90 || fix.Latitude > 90 || fix.Longitude < -180 || fix.Longitude > 180 {
+		return fmt.Errorf("%w: %f,%f", ErrInvalidCoordinates, fix.Latitude, fix.Longitude)
+	}
+	if fix.Accuracy < 0 || fix.Accuracy > v.opts.MaxAccuracy {
+		return fmt.Errorf("%w: %.0fm", ErrInaccurateFix, fix.Accuracy)
+	}
+	if fix.Timestamp.After(now.Add(v.opts.MaxClockSkew)) {
+		v.signal(now)
+		return fmt.Errorf("%w: %s ahead", ErrFutureFix, fix.Timestamp.Sub(now))
+	}
+
+	if v.last != nil {
+		elapsed := fix.Timestamp.Sub(v.last.Timestamp)
+		if elapsed <= 0 {
+			return ErrStaleFix
+		}
+		distance := util.CalculateDistance(v.last.Latitude, v.last.Longitude, fix.Latitude, fix.Longitude)
+		distance -= (v.last.Accuracy + fix.Accuracy) / 1000
+		if speed := distance / elapsed.Hours(); distance > 0 && speed > v.opts.MaxSpeedKmh {
+			v.signal(now)
+			v.rejected++
+			if v.rejected < v.opts.ReanchorAfter {
+				return fmt.Errorf("%w: %.0fkm/h", ErrImpossibleSpeed, speed)
+			}
+			// The fixes keep disagreeing with the last accepted one, it was the wrong one
+		}
+	}
+
+	v.last = &fix
+	v.rejected = 0
+	return nil
+}
+
+// Flagged returns true when the driver sent enough spoofing signals within the window
+func (v *FixValidator) Flagged(now time.Time) bool {
+	v.expire(now)
+	return len(v.signals) >= v.opts.SpoofSignals
+}
+
+func (v *FixValidator) signal(now time.Time) {
+	v.expire(now)
+	v.signals = append(v.signals, now)
+}
+
+// expire forgets the signals older than the window
+func (v *FixValidator) expire(now time.Time) {
+	i := 0
+	for i < len(v.signals) && now.Sub(v.signals[i]) > v.opts.SpoofWindow {
+		i++
+	}
+	v.signals = v.signals[i:]
+}
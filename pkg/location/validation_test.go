@@ -0,0 +1,87 @@
+package location
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldValidateFixes(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	lat, lon := 40.4168, -3.7038
+	// 500m north in 30 seconds is 60km/h
+	nextLat, nextLon := util.AddKM(lat, lon, 0.5, 0)
+	// 20km east in 30 seconds is 2400km/h
+	jumpLat, jumpLon := util.AddKM(lat, lon, 20, 90)
+
+	tests := []struct {
+		name    string
+		fix     model.DriverLocationFix
+		wantErr error
+	}{
+		{name: "Latitude out of range", fix: model.DriverLocationFix{Latitude: 91, Longitude: lon, Timestamp: start}, wantErr: ErrInvalidCoordinates},
+		{name: "Longitude out of range", fix: model.DriverLocationFix{Latitude: lat, Longitude: -181, Timestamp: start}, wantErr: ErrInvalidCoordinates},
+		{name: "Inaccurate", fix: model.DriverLocationFix{Latitude: lat, Longitude: lon, Accuracy: 2000, Timestamp: start}, wantErr: ErrInaccurateFix},
+		{name: "First fix", fix: model.DriverLocationFix{Latitude: lat, Longitude: lon, Accuracy: 10, Timestamp: start}},
+		{name: "Driving", fix: model.DriverLocationFix{Latitude: nextLat, Longitude: nextLon, Accuracy: 10, Timestamp: start.Add(30 * time.Second)}},
+		{name: "Out of order", fix: model.DriverLocationFix{Latitude: nextLat, Longitude: nextLon, Timestamp: start.Add(10 * time.Second)}, wantErr: ErrStaleFix},
+		{name: "Teleport", fix: model.DriverLocationFix{Latitude: jumpLat, Longitude: jumpLon, Timestamp: start.Add(time.Minute)}, wantErr: ErrImpossibleSpeed},
+		// The jump was not accepted, so the next fix is compared with the last good one
+		{name: "Stopped with jitter", fix: model.DriverLocationFix{Latitude: nextLat + 0.0002, Longitude: nextLon, Accuracy: 30, Timestamp: start.Add(61 * time.Second)}},
+		{name: "Future", fix: model.DriverLocationFix{Latitude: nextLat, Longitude: nextLon, Timestamp: start.Add(time.Hour)}, wantErr: ErrFutureFix},
+	}
+
+	validator := NewFixValidator(FixValidatorOpts{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The fixes are received right after they are taken
+			err := validator.Validate(tt.fix, start.Add(2*time.Minute))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestShouldFlagRepeatedSpoofing(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	validator := NewFixValidator(FixValidatorOpts{SpoofSignals: 2, SpoofWindow: time.Minute})
+	assert.NoError(t, validator.Validate(model.DriverLocationFix{Latitude: 1, Longitude: 1, Timestamp: start}, start))
+
+	// Two jumps far apart in time are not enough
+	assert.Error(t, validator.Validate(model.DriverLocationFix{Latitude: 5, Longitude: 5, Timestamp: start.Add(time.Second)}, start.Add(time.Second)))
+	assert.False(t, validator.Flagged(start.Add(time.Second)))
+	now := start.Add(5 * time.Minute)
+	assert.Error(t, validator.Validate(model.DriverLocationFix{Latitude: 6, Longitude: 6, Timestamp: now}, now))
+	assert.False(t, validator.Flagged(now))
+
+	// A fix from the future right after is
+	assert.ErrorIs(t, validator.Validate(model.DriverLocationFix{Latitude: 1, Longitude: 1, Timestamp: now.Add(time.Hour)}, now), ErrFutureFix)
+	assert.True(t, validator.Flagged(now))
+	assert.False(t, validator.Flagged(now.Add(2*time.Minute)))
+}
+
+func TestShouldReanchorAfterRepeatedRejections(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	validator := NewFixValidator(FixValidatorOpts{ReanchorAfter: 3})
+	// A wrong first fix far away from where the driver really is
+	assert.NoError(t, validator.Validate(model.DriverLocationFix{Latitude: 10, Longitude: 10, Timestamp: start}, start))
+
+	lat, lon := 40.4168, -3.7038
+	for i := 1; i <= 2; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		assert.ErrorIs(t, validator.Validate(model.DriverLocationFix{Latitude: lat, Longitude: lon, Timestamp: at}, at), ErrImpossibleSpeed)
+	}
+	// The third rejection in a row replaces the anchor, the next fixes are compared with it
+	at := start.Add(3 * time.Second)
+	assert.NoError(t, validator.Validate(model.DriverLocationFix{Latitude: lat, Longitude: lon, Timestamp: at}, at))
+	nextLat, nextLon := util.AddKM(lat, lon, 0.1, 0)
+	at = start.Add(13 * time.Second)
+	assert.NoError(t, validator.Validate(model.DriverLocationFix{Latitude: nextLat, Longitude: nextLon, Timestamp: at}, at))
+	assert.True(t, validator.Flagged(at))
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/gorilla/mux"
+)
+
+// Rule requires a permission to call a route, Path is the mux path template of the route. SelfPermission,
+// when set, also grants access to the callers whose ID is the {id} of the route.
+type Rule struct {
+	Method         string
+	Path           string
+	Permission     authentication.Permission
+	SelfPermission authentication.Permission
+}
+
+// Policy maps the routes and methods to the permissions they require, routes without rule only need a
+// valid token
+type Policy struct {
+	rules map[string]Rule
+}
+
+// NewPolicy creates a new Policy from the rules
+func NewPolicy(rules ...Rule) *Policy {
+	p := &Policy{rules: make(map[string]Rule, len(rules))}
+	for _, rule := range rules {
+		p.rules[rule.Method+" "+rule.Path] = rule
+	}
+	return p
+}
+
+// Rule returns the rule of the route and method
+func (p *Policy) Rule(method, path string) (Rule, bool) {
+	rule, ok := p.rules[method+" "+path]
+	return rule, ok
+}
+
+// AuthorizationMiddleware checks the roles stored in the request context by the JWT middlewares against the
+// rule of the matched route, so it must be registered inside them and in a mux route. Denied requests get a
+// 403 with an AuthorizationErrorResponse.
+func AuthorizationMiddleware(next http.Handler, policy *Policy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			writeAuthorizationError(w, r, "", "", "route not found")
+			return
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			writeAuthorizationError(w, r, "", "", err.Error())
+			return
+		}
+		rule, ok := policy.Rule(r.Method, path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		roles, callerID := callerRoles(r)
+		if roles == nil {
+			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+			return
+		}
+		if authentication.HasPermission(roles, rule.Permission) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rule.SelfPermission != "" && authentication.HasPermission(roles, rule.SelfPermission) &&
+			callerID != "" && mux.Vars(r)["id"] == callerID {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeAuthorizationError(w, r, path, rule.Permission, "missing permission")
+	})
+}
+
+// callerRoles returns the roles and the ID of the caller from the claims in the request context, nil roles
+// when there are no claims
+func callerRoles(r *http.Request) ([]authentication.Role, string) {
+	if claims := StaffClaimsFromContext(r.Context()); claims != nil {
+		return claims.StaffRoles(), ""
+	}
+	if claims := DriverClaimsFromContext(r.Context()); claims != nil {
+		return claims.DriverRoles(), claims.DriverID
+	}
+	if claims := PassengerClaimsFromContext(r.Context()); claims != nil {
+		return claims.PassengerRoles(), claims.PassengerID
+	}
+	return nil, ""
+}
+
+// CallerHasPermission returns true when the roles of the caller grant the permission
+func CallerHasPermission(r *http.Request, permission authentication.Permission) bool {
+	roles, _ := callerRoles(r)
+	return authentication.HasPermission(roles, permission)
+}
+
+func writeAuthorizationError(w http.ResponseWriter, r *http.Request, path string, permission authentication.Permission, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(model.AuthorizationErrorResponse{
+		Code:       http.StatusForbidden,
+		Reason:     reason,
+		Method:     r.Method,
+		Route:      path,
+		Permission: string(permission),
+	})
+}
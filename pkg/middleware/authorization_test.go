@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/authentication"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldAuthorizeByRole(t *testing.T) {
+	auth := authentication.NewJWTDriverAuthenticationService(&authentication.KeySet{Current: authentication.NewHMACKey("test", []byte("secret"))})
+	staff := &authentication.JWTStaffAuthenticationService{}
+	policy := NewPolicy(
+		Rule{Method: "GET", Path: "/drivers", Permission: authentication.PermDriversList},
+		Rule{Method: "GET", Path: "/drivers/{id}", Permission: authentication.PermDriversRead, SelfPermission: authentication.PermDriversSelf},
+		Rule{Method: "DELETE", Path: "/drivers/{id}", Permission: authentication.PermDriversDelete},
+	)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r := mux.NewRouter()
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/drivers"}, {"GET", "/drivers/{id}"}, {"DELETE", "/drivers/{id}"}, {"PUT", "/drivers/{id}"},
+	} {
+		r.Handle(route.path, StaffMiddleware(JWTMiddleware(AuthorizationMiddleware(ok, policy), auth), staff)).Methods(route.method)
+	}
+
+	adminToken, err := staff.GenerateStaffJWT("staff-1", authentication.RoleAdmin)
+	require.NoError(t, err)
+	opsToken, err := staff.GenerateStaffJWT("staff-2", authentication.RoleOps)
+	require.NoError(t, err)
+	driverToken, err := auth.GenerateDriverJWT("7")
+	require.NoError(t, err)
+	// A driver token claiming the admin role is still a driver token
+	forgedToken, err := auth.Keys.Sign(authentication.DriverClaims{DriverID: "7", Roles: []authentication.Role{authentication.RoleAdmin}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "Admin deletes driver", method: "DELETE", path: "/drivers/7", token: adminToken, status: http.StatusOK},
+		{name: "Ops can't delete driver", method: "DELETE", path: "/drivers/7", token: opsToken, status: http.StatusForbidden},
+		{name: "Driver claiming admin can't delete", method: "DELETE", path: "/drivers/7", token: forgedToken, status: http.StatusForbidden},
+		{name: "Driver can't delete itself", method: "DELETE", path: "/drivers/7", token: driverToken, status: http.StatusForbidden},
+		{name: "Ops lists drivers", method: "GET", path: "/drivers", token: opsToken, status: http.StatusOK},
+		{name: "Driver can't list drivers", method: "GET", path: "/drivers", token: driverToken, status: http.StatusForbidden},
+		{name: "Driver reads itself", method: "GET", path: "/drivers/7", token: driverToken, status: http.StatusOK},
+		{name: "Driver can't read others", method: "GET", path: "/drivers/8", token: driverToken, status: http.StatusForbidden},
+		{name: "Route without rule", method: "PUT", path: "/drivers/8", token: driverToken, status: http.StatusOK},
+		{name: "Missing token", method: "GET", path: "/drivers", token: "", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+
+			if tt.status == http.StatusForbidden {
+				var resp model.AuthorizationErrorResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, http.StatusForbidden, resp.Code)
+				assert.Equal(t, tt.method, resp.Method)
+				assert.NotEmpty(t, resp.Permission)
+			}
+		})
+	}
+}
@@ -9,10 +9,35 @@ import (
 
 type ClaimsKeyType string
 
-const ClaimsKey ClaimsKeyType = "claims"
+const (
+	// ClaimsKey holds the driver claims in the request context
+	ClaimsKey ClaimsKeyType = "claims"
+	// PassengerClaimsKey holds the passenger claims in the request context
+	PassengerClaimsKey ClaimsKeyType = "passenger_claims"
+	// StaffClaimsKey holds the staff claims in the request context
+	StaffClaimsKey ClaimsKeyType = "staff_claims"
+)
+
+// StaffMiddleware stores the claims of the staff tokens in the request context, the rest of the tokens are
+// left to the driver and passenger middlewares registered inside it, which let the staff requests through
+func StaffMiddleware(next http.Handler, authenticator authentication.StaffAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := r.Header.Get("Authorization")
+		if tokenString != "" {
+			if claims, err := authenticator.ValidateStaffJWT(tokenString); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), StaffClaimsKey, claims))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
 func JWTMiddleware(next http.Handler, authenticator authentication.DriverAuthenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if StaffClaimsFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
 			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
@@ -28,3 +53,72 @@ func JWTMiddleware(next http.Handler, authenticator authentication.DriverAuthent
 		next.ServeHTTP(w, r)
 	})
 }
+
+// PassengerJWTMiddleware validates the passenger token and stores the claims in the request context
+func PassengerJWTMiddleware(next http.Handler, authenticator authentication.PassengerAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if StaffClaimsFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" {
+			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+			return
+		}
+		claims, err := authenticator.ValidatePassengerJWT(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), PassengerClaimsKey, claims)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RideParticipantMiddleware accepts either a passenger or a driver token, the claims of whichever is valid
+// are stored in the request context so the handlers can check the ownership of the rides
+func RideParticipantMiddleware(next http.Handler, passengers authentication.PassengerAuthenticator, drivers authentication.DriverAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if StaffClaimsFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" {
+			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+			return
+		}
+		if claims, err := passengers.ValidatePassengerJWT(tokenString); err == nil {
+			ctx := context.WithValue(r.Context(), PassengerClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		claims, err := drivers.ValidateDriverJWT(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// DriverClaimsFromContext returns the driver claims stored by the middlewares, nil if there are none
+func DriverClaimsFromContext(ctx context.Context) *authentication.DriverClaims {
+	claims, _ := ctx.Value(ClaimsKey).(*authentication.DriverClaims)
+	return claims
+}
+
+// StaffClaimsFromContext returns the staff claims stored by StaffMiddleware, nil if there are none
+func StaffClaimsFromContext(ctx context.Context) *authentication.StaffClaims {
+	claims, _ := ctx.Value(StaffClaimsKey).(*authentication.StaffClaims)
+	return claims
+}
+
+// PassengerClaimsFromContext returns the passenger claims stored by the middlewares, nil if there are none
+func PassengerClaimsFromContext(ctx context.Context) *authentication.PassengerClaims {
+	claims, _ := ctx.Value(PassengerClaimsKey).(*authentication.PassengerClaims)
+	return claims
+}
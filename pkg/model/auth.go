@@ -0,0 +1,29 @@
+package model
+
+// TokenResponse is returned when a token is issued, the refresh token is only issued to drivers
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// RefreshTokenRequest is the body used to refresh or revoke a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// DriverCredentials is the body used by drivers to log in and to set their password
+type DriverCredentials struct {
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+	// CurrentPassword is required when the drivers change their own password
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+// AuthorizationErrorResponse is returned when the caller lacks the permission required by the route
+type AuthorizationErrorResponse struct {
+	Code       int    `json:"code"`
+	Reason     string `json:"reason"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	Permission string `json:"permission"`
+}
@@ -4,6 +4,39 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const (
+	// DriverStatusActive is the status of the drivers allowed to work, any other status logs the driver out
+	DriverStatusActive = "active"
+	// DriverStatusSuspended is the status of the drivers banned from working
+	DriverStatusSuspended = "suspended"
+	// DriverStatusUnverified is the status of the drivers whose documents were not verified yet
+	DriverStatusUnverified = "unverified"
+)
+
+type DriverChangeKind string
+
+const (
+	// DriverChangeUpdated is sent when the data or the status of the driver changes
+	DriverChangeUpdated DriverChangeKind = "updated"
+	// DriverChangeDeleted is sent when the driver is removed
+	DriverChangeDeleted DriverChangeKind = "deleted"
+	// DriverChangeVehicle is sent when the vehicle of the driver is saved or removed
+	DriverChangeVehicle DriverChangeKind = "vehicle"
+	// DriverChangeResync is sent by the watcher after listening again, the changes notified while it was not
+	// listening are lost so the drivers held have to be read again. It has no driver.
+	DriverChangeResync DriverChangeKind = "resync"
+)
+
+// DriverChangeEvent notifies the services holding driver data that the driver changed. Status and Region are
+// set for updates and VehicleClass for vehicle changes, empty when the vehicle was removed.
+type DriverChangeEvent struct {
+	Kind         DriverChangeKind `json:"kind"`
+	DriverID     int              `json:"driver_id"`
+	Status       string           `json:"status,omitempty"`
+	Region       string           `json:"region,omitempty"`
+	VehicleClass VehicleClass     `json:"vehicle_class,omitempty"`
+}
+
 type Driver struct {
 	ID            int    `json:"id"`
 	Name          string `json:"name"`
@@ -23,3 +56,8 @@ func (d *Driver) Scan(row pgx.Row) error {
 		&d.Status,
 	)
 }
+
+// CanWork returns true when the driver is allowed to connect and get rides
+func (d *Driver) CanWork() bool {
+	return d.Status == DriverStatusActive
+}
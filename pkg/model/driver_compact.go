@@ -0,0 +1,160 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The compact encoding is a binary frame made of a kind byte followed by the fields of the message as varints.
+// A location batch is:
+//
+//	kind (1 byte) | id length (uvarint) | id | fixes (uvarint) | fixes...
+//
+// where every fix is the latitude and longitude in millionths of a degree and the timestamp in milliseconds,
+// all of them zigzag varints relative to the previous fix (the first one relative to zero), followed by the
+// accuracy in decimeters as an uvarint. Consecutive fixes are close to each other, so a fix usually takes 7 to
+// 10 bytes instead of the ~110 of its JSON.
+
+const (
+	compactLocationBatch byte = 1
+
+	// compactCoordinateScale is the precision of the coordinates, a millionth of a degree is about 11cm
+	compactCoordinateScale = 1e6
+	// compactAccuracyScale is the precision of the accuracy, decimeters
+	compactAccuracyScale = 10
+	// compactMaxIDLength bounds the message IDs so a corrupt length can't allocate much
+	compactMaxIDLength = 64
+)
+
+// ErrInvalidCompactMessage is returned when a compact frame is truncated or malformed
+var ErrInvalidCompactMessage = errors.New("invalid compact message")
+
+// EncodeCompactDriverMessage encodes a message with the compact encoding, only the location batches have one
+func EncodeCompactDriverMessage(msg DriverMessage) ([]byte, error) {
+	batch, ok := msg.(*DriverLocationBatchRequest)
+	if !ok {
+		return nil, fmt.Errorf("message %T has no compact encoding", msg)
+	}
+	if len(batch.ID) > compactMaxIDLength {
+		return nil, fmt.Errorf("message ID longer than %d", compactMaxIDLength)
+	}
+	if len(batch.Fixes) > MaxDriverLocationFixes {
+		return nil, fmt.Errorf("more than %d fixes", MaxDriverLocationFixes)
+	}
+
+	buf := make([]byte, 0, 2+len(batch.ID)+binary.MaxVarintLen64*(1+4*len(batch.Fixes)))
+	buf = append(buf, compactLocationBatch)
+	buf = binary.AppendUvarint(buf, uint64(len(batch.ID)))
+	buf = append(buf, batch.ID...)
+	buf = binary.AppendUvarint(buf, uint64(len(batch.Fixes)))
+
+	var lat, lon, ts int64
+	for _, fix := range batch.Fixes {
+		fixLat := int64(math.Round(fix.Latitude * compactCoordinateScale))
+		fixLon := int64(math.Round(fix.Longitude * compactCoordinateScale))
+		fixTS := fix.Timestamp.UnixMilli()
+		buf = binary.AppendVarint(buf, fixLat-lat)
+		buf = binary.AppendVarint(buf, fixLon-lon)
+		buf = binary.AppendVarint(buf, fixTS-ts)
+		buf = binary.AppendUvarint(buf, uint64(math.Round(math.Max(fix.Accuracy, 0)*compactAccuracyScale)))
+		lat, lon, ts = fixLat, fixLon, fixTS
+	}
+	return buf, nil
+}
+
+// DecodeCompactDriverMessage decodes a binary frame sent by a client with the compact encoding
+func DecodeCompactDriverMessage(payload []byte) (DriverMessage, error) {
+	if len(payload) == 0 {
+		return nil, ErrInvalidCompactMessage
+	}
+	if payload[0] != compactLocationBatch {
+		return nil, fmt.Errorf("%w: kind %d", ErrUnknownDriverMessageType, payload[0])
+	}
+	r := compactReader{buf: payload[1:]}
+
+	batch := &DriverLocationBatchRequest{}
+	batch.Type = DriverLocationBatchMsgType
+	idLength := r.uvarint()
+	if r.err == nil && idLength > compactMaxIDLength {
+		return nil, fmt.Errorf("%w: message ID longer than %d", ErrInvalidCompactMessage, compactMaxIDLength)
+	}
+	batch.ID = string(r.bytes(int(idLength)))
+
+	count := r.uvarint()
+	if r.err == nil && count > MaxDriverLocationFixes {
+		return nil, fmt.Errorf("%w: more than %d fixes", ErrInvalidCompactMessage, MaxDriverLocationFixes)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	batch.Fixes = make([]DriverLocationFix, 0, count)
+	var lat, lon, ts int64
+	for i := uint64(0); i < count; i++ {
+		lat += r.varint()
+		lon += r.varint()
+		ts += r.varint()
+		accuracy := r.uvarint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		batch.Fixes = append(batch.Fixes, DriverLocationFix{
+			Latitude:  float64(lat) / compactCoordinateScale,
+			Longitude: float64(lon) / compactCoordinateScale,
+			Accuracy:  float64(accuracy) / compactAccuracyScale,
+			Timestamp: time.UnixMilli(ts).UTC(),
+		})
+	}
+	if len(r.buf) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidCompactMessage, len(r.buf))
+	}
+	return batch, nil
+}
+
+// compactReader reads the fields of a compact frame, the first error is kept and the following reads are no-ops
+type compactReader struct {
+	buf []byte
+	err error
+}
+
+func (r *compactReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = ErrInvalidCompactMessage
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *compactReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = ErrInvalidCompactMessage
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *compactReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = ErrInvalidCompactMessage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
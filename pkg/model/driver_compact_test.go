@@ -0,0 +1,105 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldRoundTripCompactLocationBatch(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	batch := &DriverLocationBatchRequest{
+		BaseMessage: BaseMessage{Type: DriverLocationBatchMsgType, ID: "42"},
+		Fixes: []DriverLocationFix{
+			{Latitude: 40.416775, Longitude: -3.703790, Accuracy: 4.5, Timestamp: start},
+			{Latitude: 40.416812, Longitude: -3.703702, Accuracy: 12, Timestamp: start.Add(time.Second)},
+			// Going south and west gives negative deltas, a late fix a negative time delta
+			{Latitude: 40.416101, Longitude: -3.704450, Timestamp: start.Add(500 * time.Millisecond)},
+		},
+	}
+
+	payload, err := EncodeCompactDriverMessage(batch)
+	require.NoError(t, err)
+	decoded, err := DecodeCompactDriverMessage(payload)
+	require.NoError(t, err)
+	assert.Equal(t, batch, decoded)
+
+	jsonPayload, err := EncodeDriverMessage(batch)
+	require.NoError(t, err)
+	assert.Less(t, len(payload)*4, len(jsonPayload))
+
+	// The JSON form is still accepted
+	decoded, err = DecodeDriverMessage(jsonPayload)
+	require.NoError(t, err)
+	assert.Equal(t, batch, decoded)
+}
+
+func TestShouldRejectInvalidCompactMessages(t *testing.T) {
+	batch := &DriverLocationBatchRequest{
+		BaseMessage: BaseMessage{Type: DriverLocationBatchMsgType},
+		Fixes:       []DriverLocationFix{{Latitude: 1, Longitude: 2, Timestamp: time.UnixMilli(1000).UTC()}},
+	}
+	payload, err := EncodeCompactDriverMessage(batch)
+	require.NoError(t, err)
+
+	for i := 0; i < len(payload); i++ {
+		_, err = DecodeCompactDriverMessage(payload[:i])
+		assert.Error(t, err, "truncated at %d", i)
+	}
+	_, err = DecodeCompactDriverMessage(append(payload, 0))
+	assert.ErrorIs(t, err, ErrInvalidCompactMessage)
+	_, err = DecodeCompactDriverMessage([]byte{99})
+	assert.ErrorIs(t, err, ErrUnknownDriverMessageType)
+
+	// A huge fix count is rejected before allocating
+	_, err = DecodeCompactDriverMessage([]byte{compactLocationBatch, 0, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	assert.ErrorIs(t, err, ErrInvalidCompactMessage)
+
+	_, err = EncodeCompactDriverMessage(&DriverAckResponse{})
+	assert.Error(t, err)
+}
+
+func TestShouldNegotiateDriverEncoding(t *testing.T) {
+	assert.Equal(t, DriverEncodingJSON, NegotiateDriverEncoding(nil))
+	assert.Equal(t, DriverEncodingCompact, NegotiateDriverEncoding([]DriverEncoding{"protobuf", DriverEncodingCompact, DriverEncodingJSON}))
+	assert.Equal(t, DriverEncodingJSON, NegotiateDriverEncoding([]DriverEncoding{"protobuf"}))
+
+	var hello DriverHelloRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"driver_hello","versions":[1,2],"encodings":["compact"]}`), &hello))
+	assert.Equal(t, []DriverEncoding{DriverEncodingCompact}, hello.Encodings)
+}
+
+func TestShouldReturnLatestFix(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	batch := &DriverLocationBatchRequest{Fixes: []DriverLocationFix{
+		{Latitude: 1, Timestamp: start.Add(time.Second)},
+		{Latitude: 2, Timestamp: start},
+	}}
+	assert.Equal(t, 1.0, batch.Latest().Latitude)
+	assert.Nil(t, (&DriverLocationBatchRequest{}).Latest())
+}
+
+func TestShouldDefaultBatchTimestamps(t *testing.T) {
+	received := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	batch := &DriverLocationBatchRequest{Fixes: []DriverLocationFix{
+		{Latitude: 1, Timestamp: received.Add(-time.Second)},
+		{Latitude: 2},
+	}}
+	fixes := batch.FixesAt(received)
+	assert.Equal(t, received.Add(-time.Second), fixes[0].Timestamp)
+	assert.Equal(t, received, fixes[1].Timestamp)
+	// The batch is left as sent
+	assert.True(t, batch.Fixes[1].Timestamp.IsZero())
+}
+
+func TestShouldBuildFixOfLocationRequest(t *testing.T) {
+	received := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	req := &DriverLocationRequest{Latitude: 1, Longitude: 2, Accuracy: 5}
+	assert.Equal(t, DriverLocationFix{Latitude: 1, Longitude: 2, Accuracy: 5, Timestamp: received}, req.Fix(received))
+
+	req.Timestamp = received.Add(-time.Second)
+	assert.Equal(t, req.Timestamp, req.Fix(received).Timestamp)
+}
@@ -1,11 +1,52 @@
 package model
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
 type DriverMsgType string
 
+// DriverEncoding is the encoding of the messages of a driver session
+type DriverEncoding string
+
+const (
+	// DriverProtocolVersion1 is the first version of the driver protocol
+	DriverProtocolVersion1 = 1
+	// DriverProtocolVersion2 adds the location batches and the compact encoding
+	DriverProtocolVersion2 = 2
+	// DriverProtocolVersion is the latest version of the driver protocol
+	DriverProtocolVersion = DriverProtocolVersion2
+)
+
+const (
+	// DriverEncodingJSON is the default encoding, every message is a JSON text frame
+	DriverEncodingJSON DriverEncoding = "json"
+	// DriverEncodingCompact sends the location batches as binary frames, see EncodeCompactDriverMessage. The
+	// rest of the messages stay JSON text frames.
+	DriverEncodingCompact DriverEncoding = "compact"
+)
+
+// SupportedDriverProtocolVersions are the versions of the driver protocol the server speaks
+var SupportedDriverProtocolVersions = []int{DriverProtocolVersion1, DriverProtocolVersion2}
+
+// SupportedDriverEncodings are the encodings the server speaks
+var SupportedDriverEncodings = []DriverEncoding{DriverEncodingJSON, DriverEncodingCompact}
+
+var (
+	// ErrUnknownDriverMessageType is returned when decoding a message with a type that is not part of the protocol
+	ErrUnknownDriverMessageType = errors.New("unknown message type")
+	// ErrNoCommonProtocolVersion is returned when the client does not speak any supported version
+	ErrNoCommonProtocolVersion = errors.New("no common protocol version")
+)
+
 const (
 	// DriverLocationMsgType is the message type for driver location updates
 	DriverLocationMsgType DriverMsgType = "driver_location"
 	// DriverRequestMsgType is the message type for passenger ride requests
+	// Deprecated: rides are offered to the drivers with DriverRideOfferMsgType
 	DriverRequestMsgType DriverMsgType = "driver_request"
 	// DriverErrorResponseMsgType is the message type for error responses
 	DriverErrorResponseMsgType DriverMsgType = "driver_error"
@@ -13,21 +54,51 @@ const (
 	DriverHelloMsgType DriverMsgType = "driver_hello"
 	// DriverGoodByeMsgType is the message type for driver goodbye messages
 	DriverGoodByeMsgType DriverMsgType = "driver_goodbye"
+	// DriverStartRideMsgType is the message type for drivers starting a ride once the passenger is on board
+	DriverStartRideMsgType DriverMsgType = "driver_start_ride"
+	// DriverAckMsgType is the message type for the acknowledgements of the client messages
+	DriverAckMsgType DriverMsgType = "driver_ack"
+	// DriverRideOfferMsgType is the message type for rides offered to a driver
+	DriverRideOfferMsgType DriverMsgType = "driver_ride_offer"
+	// DriverOfferAcceptMsgType is the message type for drivers accepting a ride offer
+	DriverOfferAcceptMsgType DriverMsgType = "driver_offer_accept"
+	// DriverOfferDeclineMsgType is the message type for drivers declining a ride offer
+	DriverOfferDeclineMsgType DriverMsgType = "driver_offer_decline"
+	// DriverRideStatusMsgType is the message type for the status changes of the rides of a driver
+	DriverRideStatusMsgType DriverMsgType = "driver_ride_status"
+	// DriverLocationBatchMsgType is the message type for several timestamped driver locations at once
+	DriverLocationBatchMsgType DriverMsgType = "driver_location_batch"
+	// DriverQueuePositionMsgType is the message type for the position of a driver in the queue of a staging zone
+	DriverQueuePositionMsgType DriverMsgType = "driver_queue_position"
+	// DriverArrivedMsgType is the message type for drivers reaching the pickup of a ride
+	DriverArrivedMsgType DriverMsgType = "driver_arrived"
+	// DriverCompleteRideMsgType is the message type for drivers dropping off the passenger of a ride
+	DriverCompleteRideMsgType DriverMsgType = "driver_complete_ride"
 )
 
+// MaxDriverLocationFixes is the maximum number of fixes in a location batch
+const MaxDriverLocationFixes = 64
+
 type (
 
 	// BaseMessage is the base structure for all messages with a Type field
+	// ID is set by the client and echoed in the reply to the message, messages without ID are only
+	// replied when they fail or when they have their own response
 	BaseMessage struct {
 		Type DriverMsgType `json:"type"`
+		ID   string        `json:"id,omitempty"`
 	}
 
 	// DriverLocation represents the location message from a driver
 	// This message is sent from the Client to the Server
+	// Accuracy is the radius in meters of the fix reported by the device and Timestamp when the fix was taken,
+	// zero when the client does not send them
 	DriverLocationRequest struct {
 		BaseMessage
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
+		Latitude  float64   `json:"latitude"`
+		Longitude float64   `json:"longitude"`
+		Accuracy  float64   `json:"accuracy,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
 	}
 
 	// DriveRequest represents a ride request message from a passenger
@@ -40,16 +111,36 @@ type (
 		DropLng   float64 `json:"drop_longitude"`
 	}
 
-	// DriverHelloRequest represents a driver hello message
+	// DriverLocationFix is a GPS fix, Accuracy is the radius in meters reported by the device, zero if unknown
+	DriverLocationFix struct {
+		Latitude  float64   `json:"latitude"`
+		Longitude float64   `json:"longitude"`
+		Accuracy  float64   `json:"accuracy,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	// DriverLocationBatchRequest carries the fixes taken since the last message, oldest first
+	// This message is sent from the Client to the Server
+	DriverLocationBatchRequest struct {
+		BaseMessage
+		Fixes []DriverLocationFix `json:"fixes"`
+	}
+
+	// DriverHelloRequest represents a driver hello message with the protocol versions and the encodings the
+	// client speaks, the encodings in order of preference
 	// This message is sent from the Client to the Server
 	DriverHelloRequest struct {
 		BaseMessage
+		Versions  []int            `json:"versions"`
+		Encodings []DriverEncoding `json:"encodings,omitempty"`
 	}
 
-	// DriverHelloResponse represents a driver hello response message
+	// DriverHelloResponse represents a driver hello response message with the negotiated version and encoding
 	// This message is sent from the Server to the Client
 	DriverHelloResponse struct {
 		BaseMessage
+		Version  int            `json:"version"`
+		Encoding DriverEncoding `json:"encoding,omitempty"`
 	}
 
 	// DriverGoodByeRequest represents a driver goodbye message
@@ -59,9 +150,95 @@ type (
 	}
 
 	// DriverGoodByeResponse represents a driver goodbye response message
-	// This message is sent from the Server to the Client
+	// This message is sent from the Server to the Client, also when the server closes the session, with the
+	// reason why
 	DriverGoodByeResponse struct {
 		BaseMessage
+		Reason string `json:"reason,omitempty"`
+	}
+
+	// DriverStartRideRequest represents a driver starting a ride with the PIN shown by the passenger
+	// This message is sent from the Client to the Server
+	DriverStartRideRequest struct {
+		BaseMessage
+		RideID int    `json:"ride_id"`
+		PIN    string `json:"pin"`
+	}
+
+	// DriverArrivedRequest tells that the driver is waiting at the pickup of the ride
+	// This message is sent from the Client to the Server
+	DriverArrivedRequest struct {
+		BaseMessage
+		RideID int `json:"ride_id"`
+	}
+
+	// DriverCompleteRideRequest tells that the driver dropped off the passenger of the ride
+	// This message is sent from the Client to the Server
+	DriverCompleteRideRequest struct {
+		BaseMessage
+		RideID int `json:"ride_id"`
+	}
+
+	// DriverStartRideResponse represents the ride status after a driver starts a ride
+	// This message is sent from the Server to the Client
+	DriverStartRideResponse struct {
+		BaseMessage
+		RideID int        `json:"ride_id"`
+		Status RideStatus `json:"status"`
+	}
+
+	// DriverAckResponse acknowledges a client message without its own response
+	// This message is sent from the Server to the Client
+	DriverAckResponse struct {
+		BaseMessage
+		OriginalMessageType DriverMsgType `json:"original_message_type"`
+	}
+
+	// DriverRideOffer offers a ride to the driver until ExpiresAt
+	// This message is sent from the Server to the Client
+	DriverRideOffer struct {
+		BaseMessage
+		Ride      *Ride     `json:"ride"`
+		ExpiresAt time.Time `json:"expires_at"`
+		// PickupETASeconds is the time for the driver to reach the pickup and TripETASeconds the time of the ride
+		PickupETASeconds int `json:"pickup_eta_seconds,omitempty"`
+		TripETASeconds   int `json:"trip_eta_seconds,omitempty"`
+	}
+
+	// DriverOfferAcceptRequest accepts a ride offer, the first driver accepting gets the ride
+	// This message is sent from the Client to the Server
+	DriverOfferAcceptRequest struct {
+		BaseMessage
+		RideID int `json:"ride_id"`
+	}
+
+	// DriverOfferDeclineRequest declines a ride offer
+	// This message is sent from the Client to the Server
+	DriverOfferDeclineRequest struct {
+		BaseMessage
+		RideID int    `json:"ride_id"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	// DriverRideStatusResponse notifies the driver a status change of one of its rides
+	// This message is sent from the Server to the Client
+	DriverRideStatusResponse struct {
+		BaseMessage
+		RideID int        `json:"ride_id"`
+		Status RideStatus `json:"status"`
+		Ride   *Ride      `json:"ride,omitempty"`
+		// StopSeq is the intermediate stop of the stop events
+		StopSeq int `json:"stop_seq,omitempty"`
+	}
+
+	// DriverQueuePosition tells the driver its position in the queue of a staging zone, the first position is 1
+	// and 0 means the driver left the queue
+	// This message is sent from the Server to the Client
+	DriverQueuePosition struct {
+		BaseMessage
+		ZoneID   int    `json:"zone_id"`
+		Zone     string `json:"zone"`
+		Position int    `json:"position"`
 	}
 
 	// DriverErrorResponse represents an error response message
@@ -73,3 +250,148 @@ type (
 		Reason              string        `json:"reason"`
 	}
 )
+
+// DriverMessage is any message of the driver protocol
+type DriverMessage interface {
+	Base() *BaseMessage
+}
+
+// Base returns the common fields of the message
+func (m *BaseMessage) Base() *BaseMessage {
+	return m
+}
+
+// Reply returns the base of the reply to the message, it echoes the message ID
+func (m *BaseMessage) Reply(msgType DriverMsgType) BaseMessage {
+	return BaseMessage{Type: msgType, ID: m.ID}
+}
+
+// driverMessageFactories builds the message of every type of the protocol
+var driverMessageFactories = map[DriverMsgType]func() DriverMessage{
+	DriverLocationMsgType:      func() DriverMessage { return &DriverLocationRequest{} },
+	DriverRequestMsgType:       func() DriverMessage { return &DriveRequest{} },
+	DriverErrorResponseMsgType: func() DriverMessage { return &DriverErrorResponse{} },
+	DriverHelloMsgType:         func() DriverMessage { return &DriverHelloRequest{} },
+	DriverGoodByeMsgType:       func() DriverMessage { return &DriverGoodByeRequest{} },
+	DriverStartRideMsgType:     func() DriverMessage { return &DriverStartRideRequest{} },
+	DriverAckMsgType:           func() DriverMessage { return &DriverAckResponse{} },
+	DriverRideOfferMsgType:     func() DriverMessage { return &DriverRideOffer{} },
+	DriverOfferAcceptMsgType:   func() DriverMessage { return &DriverOfferAcceptRequest{} },
+	DriverOfferDeclineMsgType:  func() DriverMessage { return &DriverOfferDeclineRequest{} },
+	DriverRideStatusMsgType:    func() DriverMessage { return &DriverRideStatusResponse{} },
+	DriverLocationBatchMsgType: func() DriverMessage { return &DriverLocationBatchRequest{} },
+	DriverQueuePositionMsgType: func() DriverMessage { return &DriverQueuePosition{} },
+	DriverArrivedMsgType:       func() DriverMessage { return &DriverArrivedRequest{} },
+	DriverCompleteRideMsgType:  func() DriverMessage { return &DriverCompleteRideRequest{} },
+}
+
+// driverServerMessageFactories overrides the messages whose type is shared by both directions, the server
+// decodes what the client sends and the client what the server sends
+var driverServerMessageFactories = map[DriverMsgType]func() DriverMessage{
+	DriverHelloMsgType:     func() DriverMessage { return &DriverHelloResponse{} },
+	DriverGoodByeMsgType:   func() DriverMessage { return &DriverGoodByeResponse{} },
+	DriverStartRideMsgType: func() DriverMessage { return &DriverStartRideResponse{} },
+}
+
+// EncodeDriverMessage encodes a message of the protocol
+func EncodeDriverMessage(msg DriverMessage) ([]byte, error) {
+	if msg.Base().Type == "" {
+		return nil, fmt.Errorf("message %T without type", msg)
+	}
+	return json.Marshal(msg)
+}
+
+// DecodeDriverMessage decodes a message sent by a client into the struct of its type. When the type is
+// unknown the base of the message is returned with ErrUnknownDriverMessageType, so the error can be replied.
+func DecodeDriverMessage(payload []byte) (DriverMessage, error) {
+	return decodeDriverMessage(payload, nil)
+}
+
+// DecodeDriverServerMessage decodes a message sent by the server into the struct of its type
+func DecodeDriverServerMessage(payload []byte) (DriverMessage, error) {
+	return decodeDriverMessage(payload, driverServerMessageFactories)
+}
+
+func decodeDriverMessage(payload []byte, overrides map[DriverMsgType]func() DriverMessage) (DriverMessage, error) {
+	base := &BaseMessage{}
+	if err := json.Unmarshal(payload, base); err != nil {
+		return nil, err
+	}
+	factory, ok := overrides[base.Type]
+	if !ok {
+		factory, ok = driverMessageFactories[base.Type]
+	}
+	if !ok {
+		return base, fmt.Errorf("%w: %q", ErrUnknownDriverMessageType, base.Type)
+	}
+	msg := factory()
+	if err := json.Unmarshal(payload, msg); err != nil {
+		return base, err
+	}
+	return msg, nil
+}
+
+// NegotiateDriverProtocolVersion returns the latest version supported by both sides, clients that don't send
+// their versions speak the first one
+func NegotiateDriverProtocolVersion(versions []int) (int, error) {
+	if len(versions) == 0 {
+		return DriverProtocolVersion1, nil
+	}
+	best := 0
+	for _, version := range versions {
+		for _, supported := range SupportedDriverProtocolVersions {
+			if version == supported && version > best {
+				best = version
+			}
+		}
+	}
+	if best == 0 {
+		return 0, ErrNoCommonProtocolVersion
+	}
+	return best, nil
+}
+
+// NegotiateDriverEncoding returns the first encoding of the client supported by the server, JSON when there is
+// none since every client speaks it
+func NegotiateDriverEncoding(encodings []DriverEncoding) DriverEncoding {
+	for _, encoding := range encodings {
+		for _, supported := range SupportedDriverEncodings {
+			if encoding == supported {
+				return encoding
+			}
+		}
+	}
+	return DriverEncodingJSON
+}
+
+// Latest returns the newest fix of the batch, nil when the batch is empty
+func (b *DriverLocationBatchRequest) Latest() *DriverLocationFix {
+	var latest *DriverLocationFix
+	for i := range b.Fixes {
+		if latest == nil || !b.Fixes[i].Timestamp.Before(latest.Timestamp) {
+			latest = &b.Fixes[i]
+		}
+	}
+	return latest
+}
+
+// FixesAt returns the fixes of the batch, received is used for the fixes sent without timestamp like Fix does
+func (b *DriverLocationBatchRequest) FixesAt(received time.Time) []DriverLocationFix {
+	fixes := make([]DriverLocationFix, len(b.Fixes))
+	for i, fix := range b.Fixes {
+		if fix.Timestamp.IsZero() {
+			fix.Timestamp = received
+		}
+		fixes[i] = fix
+	}
+	return fixes
+}
+
+// Fix returns the fix of the location message, received is used when the client did not send the timestamp
+func (r *DriverLocationRequest) Fix(received time.Time) DriverLocationFix {
+	fix := DriverLocationFix{Latitude: r.Latitude, Longitude: r.Longitude, Accuracy: r.Accuracy, Timestamp: r.Timestamp}
+	if fix.Timestamp.IsZero() {
+		fix.Timestamp = received
+	}
+	return fix
+}
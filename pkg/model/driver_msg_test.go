@@ -0,0 +1,124 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldRoundTripDriverMessages(t *testing.T) {
+	driverID := 7
+	expires := time.Date(2024, 6, 1, 10, 0, 30, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		msg    DriverMessage
+		server bool
+	}{
+		{
+			name: "Hello",
+			msg:  &DriverHelloRequest{BaseMessage: BaseMessage{Type: DriverHelloMsgType, ID: "1"}, Versions: []int{1, 2}},
+		},
+		{
+			name:   "Hello response",
+			msg:    &DriverHelloResponse{BaseMessage: BaseMessage{Type: DriverHelloMsgType, ID: "1"}, Version: 1},
+			server: true,
+		},
+		{
+			name: "Location",
+			msg:  &DriverLocationRequest{BaseMessage: BaseMessage{Type: DriverLocationMsgType, ID: "2"}, Latitude: 40.4, Longitude: -3.7},
+		},
+		{
+			name:   "Ack",
+			msg:    &DriverAckResponse{BaseMessage: BaseMessage{Type: DriverAckMsgType, ID: "2"}, OriginalMessageType: DriverLocationMsgType},
+			server: true,
+		},
+		{
+			name: "Ride offer",
+			msg: &DriverRideOffer{
+				BaseMessage: BaseMessage{Type: DriverRideOfferMsgType},
+				Ride:        &Ride{ID: 3, PassengerID: 4, Price: 12.5, Status: RideStatusPassengerAccepted, SrcLat: 1, SrcLon: 2, DstLat: 3, DstLon: 4, Stops: []RideStop{{Seq: 1, Lat: 2, Lon: 3}}},
+				ExpiresAt:   expires,
+			},
+			server: true,
+		},
+		{
+			name:   "Queue position",
+			msg:    &DriverQueuePosition{BaseMessage: BaseMessage{Type: DriverQueuePositionMsgType}, ZoneID: 7, Zone: "barajas t4", Position: 3},
+			server: true,
+		},
+		{
+			name: "Offer accept",
+			msg:  &DriverOfferAcceptRequest{BaseMessage: BaseMessage{Type: DriverOfferAcceptMsgType, ID: "3"}, RideID: 3},
+		},
+		{
+			name: "Arrived",
+			msg:  &DriverArrivedRequest{BaseMessage: BaseMessage{Type: DriverArrivedMsgType, ID: "6"}, RideID: 3},
+		},
+		{
+			name: "Complete ride",
+			msg:  &DriverCompleteRideRequest{BaseMessage: BaseMessage{Type: DriverCompleteRideMsgType, ID: "7"}, RideID: 3},
+		},
+		{
+			name: "Offer decline",
+			msg:  &DriverOfferDeclineRequest{BaseMessage: BaseMessage{Type: DriverOfferDeclineMsgType, ID: "4"}, RideID: 3, Reason: "too far"},
+		},
+		{
+			name: "Ride status",
+			msg: &DriverRideStatusResponse{
+				BaseMessage: BaseMessage{Type: DriverRideStatusMsgType},
+				RideID:      3,
+				Status:      RideStatusDriverAccepted,
+				Ride:        &Ride{ID: 3, DriverID: &driverID, Status: RideStatusDriverAccepted},
+			},
+			server: true,
+		},
+		{
+			name:   "Error",
+			msg:    &DriverErrorResponse{BaseMessage: BaseMessage{Type: DriverErrorResponseMsgType, ID: "5"}, OriginalMessageType: DriverOfferAcceptMsgType, Code: 409, Reason: "ride not offered"},
+			server: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := EncodeDriverMessage(tt.msg)
+			require.NoError(t, err)
+
+			decode := DecodeDriverMessage
+			if tt.server {
+				decode = DecodeDriverServerMessage
+			}
+			decoded, err := decode(payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, decoded)
+		})
+	}
+}
+
+func TestShouldRejectUnknownDriverMessage(t *testing.T) {
+	msg, err := DecodeDriverMessage([]byte(`{"type":"driver_teleport","id":"9"}`))
+	assert.ErrorIs(t, err, ErrUnknownDriverMessageType)
+	// The base is kept so the error can echo the message
+	require.NotNil(t, msg)
+	assert.Equal(t, "9", msg.Base().ID)
+	assert.Equal(t, DriverMsgType("driver_teleport"), msg.Base().Type)
+
+	_, err = EncodeDriverMessage(&DriverAckResponse{})
+	assert.Error(t, err)
+}
+
+func TestShouldNegotiateDriverProtocolVersion(t *testing.T) {
+	version, err := NegotiateDriverProtocolVersion(nil)
+	require.NoError(t, err)
+	assert.Equal(t, DriverProtocolVersion1, version)
+
+	version, err = NegotiateDriverProtocolVersion([]int{DriverProtocolVersion, 99})
+	require.NoError(t, err)
+	assert.Equal(t, DriverProtocolVersion, version)
+
+	_, err = NegotiateDriverProtocolVersion([]int{99})
+	assert.ErrorIs(t, err, ErrNoCommonProtocolVersion)
+}
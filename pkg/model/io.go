@@ -4,6 +4,8 @@ import "github.com/OscarMoya/Glubber/pkg/authentication"
 
 type InputMessage struct {
 	Payload []byte
+	// Binary is set for binary frames, the text frames are JSON
+	Binary bool
 }
 
 type OutputMessage struct {
@@ -6,3 +6,10 @@ type Passenger struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
+
+// PassengerCredentials is the body used by passengers to sign up and log in, the name is only used on signup
+type PassengerCredentials struct {
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
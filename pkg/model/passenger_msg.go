@@ -0,0 +1,63 @@
+package model
+
+type PassengerMsgType string
+
+const (
+	// PassengerRideStatusMsgType is the message type for ride status changes
+	PassengerRideStatusMsgType PassengerMsgType = "passenger_ride_status"
+	// PassengerDriverLocationMsgType is the message type for the live position of the assigned driver
+	PassengerDriverLocationMsgType PassengerMsgType = "passenger_driver_location"
+	// PassengerCancelRideMsgType is the message type for passengers cancelling a ride
+	PassengerCancelRideMsgType PassengerMsgType = "passenger_cancel_ride"
+	// PassengerErrorResponseMsgType is the message type for error responses
+	PassengerErrorResponseMsgType PassengerMsgType = "passenger_error"
+)
+
+type (
+
+	// PassengerBaseMessage is the base structure for all passenger messages with a Type field
+	PassengerBaseMessage struct {
+		Type PassengerMsgType `json:"type"`
+	}
+
+	// PassengerRideStatusResponse represents a change in the status of a ride of the passenger
+	// This message is sent from the Server to the Client
+	PassengerRideStatusResponse struct {
+		PassengerBaseMessage
+		Ride *Ride `json:"ride"`
+		// PickupPIN is the PIN to show to the driver, only sent when the driver is matched
+		PickupPIN string `json:"pickup_pin,omitempty"`
+		// Event is set for the events that don't change the status of the ride, the ride changes and the
+		// intermediate stops reached or left, StopSeq is the stop
+		Event   RideStatus `json:"event,omitempty"`
+		StopSeq int        `json:"stop_seq,omitempty"`
+	}
+
+	// PassengerDriverLocationResponse represents the live position of the driver assigned to a ride
+	// and the estimated time to reach the next point of the ride, the pickup or the destination
+	// This message is sent from the Server to the Client
+	PassengerDriverLocationResponse struct {
+		PassengerBaseMessage
+		RideID     int     `json:"ride_id"`
+		DriverID   int     `json:"driver_id"`
+		Latitude   float64 `json:"latitude"`
+		Longitude  float64 `json:"longitude"`
+		ETASeconds int     `json:"eta_seconds"`
+	}
+
+	// PassengerCancelRideRequest represents a passenger cancelling a ride
+	// This message is sent from the Client to the Server
+	PassengerCancelRideRequest struct {
+		PassengerBaseMessage
+		RideID int `json:"ride_id"`
+	}
+
+	// PassengerErrorResponse represents an error response message
+	// This message is sent from the Server to the Client
+	PassengerErrorResponse struct {
+		PassengerBaseMessage
+		OriginalMessageType PassengerMsgType `json:"original_message_type"`
+		Code                int              `json:"code"`
+		Reason              string           `json:"reason"`
+	}
+)
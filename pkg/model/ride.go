@@ -2,6 +2,8 @@ package model
 
 import (
 	"database/sql"
+	"math"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 )
@@ -9,6 +11,7 @@ import (
 type RideStatus string
 
 const (
+	RideStatusScheduled          RideStatus = "scheduled"
 	RideStatusPending            RideStatus = "requested"
 	RideStatusPassengerAccepted  RideStatus = "passenger_accepted"
 	RideStatusPassengerDenied    RideStatus = "passenger_denied"
@@ -20,8 +23,46 @@ const (
 	RideStatusDriverCancelled    RideStatus = "driver_cancelled"
 	RideStatusErrored            RideStatus = "errored"
 	RideStatusDeleted            RideStatus = "deleted"
+
+	// RideEventStopArrived and RideEventStopDeparted are only emitted through the outbox when the driver
+	// reaches or leaves an intermediate stop, they are never stored as the status of a ride
+	RideEventStopArrived  RideStatus = "stop_arrived"
+	RideEventStopDeparted RideStatus = "stop_departed"
+	// RideEventUpdated is emitted when the passenger changes the route or the vehicle of the ride, it only
+	// reaches the passenger and the matched driver
+	RideEventUpdated RideStatus = "ride_updated"
 )
 
+// Ended returns true when the ride can not change anymore
+func (s RideStatus) Ended() bool {
+	switch s {
+	case RideStatusPassengerDenied, RideStatusCompleted, RideStatusPassengerCancelled, RideStatusDriverCancelled,
+		RideStatusErrored, RideStatusDeleted:
+		return true
+	}
+	return false
+}
+
+// Event returns true for the events that are never stored as the status of a ride
+func (s RideStatus) Event() bool {
+	return s == RideEventStopArrived || s == RideEventStopDeparted || s == RideEventUpdated
+}
+
+// CancellableRideStatuses are the statuses the passenger can cancel, the rides in transit have to be completed
+var CancellableRideStatuses = []RideStatus{
+	RideStatusScheduled, RideStatusPending, RideStatusPassengerAccepted, RideStatusDriverAccepted, RideStatusPickingUp,
+}
+
+// Cancellable returns true for the statuses the passenger can cancel
+func (s RideStatus) Cancellable() bool {
+	for _, status := range CancellableRideStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Ride represents a ride in the system
 // Rides are created by passengers and are the main object that will contain the workflow to match a driver with a passenger
 // and to calculate the price of the ride-
@@ -35,11 +76,40 @@ type Ride struct {
 	SrcLon      float64    `json:"src_lon" db:"src_lon"`
 	DstLat      float64    `json:"dst_lat" db:"dst_lat"`
 	DstLon      float64    `json:"dst_lon" db:"dst_lon"`
+
+	// PickupPINHash and PickupPINAttempts gate the start of the ride. The PIN is generated when a driver
+	// is matched, only its hash is stored, and the number of failed attempts is bounded.
+	PickupPINHash     string `json:"-" db:"pickup_pin_hash"`
+	PickupPINAttempts int    `json:"-" db:"pickup_pin_attempts"`
+
+	// ScheduledAt is the requested pickup time for rides booked for later, nil for immediate rides
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty" db:"scheduled_at"`
+
+	// Pooled is set when the passenger accepts sharing the vehicle, PoolID groups the rides sharing it
+	Pooled bool `json:"pooled" db:"pooled"`
+	PoolID *int `json:"pool_id,omitempty" db:"pool_id"`
+
+	// VehicleClass and Seats are the vehicle requested by the passenger, an empty class matches any vehicle
+	VehicleClass VehicleClass `json:"vehicle_class,omitempty" db:"vehicle_class"`
+	Seats        int          `json:"seats,omitempty" db:"seats"`
+
+	// Stops are the ordered intermediate stops between the source and the destination, they are stored
+	// in a child table
+	Stops []RideStop `json:"stops,omitempty"`
+
+	// ETA is filled with the estimate of the price, it is not stored
+	ETA *RideETA `json:"eta,omitempty"`
+
+	// PickupPIN is the clear PIN, it is only filled when the driver is matched. The passenger gets it from the
+	// PIN store, it is never persisted in the ride nor serialized with it.
+	PickupPIN string `json:"-"`
 }
 
 // Scan is a method that allows us to convert a row from the database into a Ride struct
 func (r *Ride) Scan(row pgx.Row) error {
 	var driverID sql.NullInt64
+	var scheduledAt sql.NullTime
+	var poolID sql.NullInt64
 	err := row.Scan(
 		&r.ID,
 		&r.PassengerID,
@@ -50,6 +120,13 @@ func (r *Ride) Scan(row pgx.Row) error {
 		&r.SrcLon,
 		&r.DstLat,
 		&r.DstLon,
+		&r.PickupPINHash,
+		&r.PickupPINAttempts,
+		&scheduledAt,
+		&r.Pooled,
+		&poolID,
+		&r.VehicleClass,
+		&r.Seats,
 	)
 	if err != nil {
 		return err
@@ -62,6 +139,115 @@ func (r *Ride) Scan(row pgx.Row) error {
 		r.DriverID = nil
 	}
 
+	if scheduledAt.Valid {
+		r.ScheduledAt = &scheduledAt.Time
+	} else {
+		r.ScheduledAt = nil
+	}
+
+	if poolID.Valid {
+		pool := int(poolID.Int64)
+		r.PoolID = &pool
+	} else {
+		r.PoolID = nil
+	}
+
+	return nil
+}
+
+// Route returns the ordered points of the ride: source, intermediate stops and destination
+func (r *Ride) Route() []Coordinate {
+	route := make([]Coordinate, 0, len(r.Stops)+2)
+	route = append(route, Coordinate{Lat: r.SrcLat, Lon: r.SrcLon})
+	for _, stop := range r.Stops {
+		route = append(route, Coordinate{Lat: stop.Lat, Lon: stop.Lon})
+	}
+	route = append(route, Coordinate{Lat: r.DstLat, Lon: r.DstLon})
+	return route
+}
+
+// IsScheduled returns true when the ride is booked for a pickup time in the future
+func (r *Ride) IsScheduled(now time.Time) bool {
+	return r.ScheduledAt != nil && r.ScheduledAt.After(now)
+}
+
+// RideUpdateRequest is the body used by passengers to change their ride, the fields left empty are kept. The
+// pickup and the vehicle can only change until a driver is matched, the destination until the ride ends.
+type RideUpdateRequest struct {
+	SrcLat       *float64      `json:"src_lat,omitempty"`
+	SrcLon       *float64      `json:"src_lon,omitempty"`
+	DstLat       *float64      `json:"dst_lat,omitempty"`
+	DstLon       *float64      `json:"dst_lon,omitempty"`
+	VehicleClass *VehicleClass `json:"vehicle_class,omitempty"`
+	Seats        *int          `json:"seats,omitempty"`
+}
+
+// ChangesMatch returns true when the update changes the pickup or the vehicle, which the matched driver
+// relies on
+func (u *RideUpdateRequest) ChangesMatch() bool {
+	return u.SrcLat != nil || u.SrcLon != nil || u.VehicleClass != nil || u.Seats != nil
+}
+
+// Apply copies the fields of the update to the ride
+func (u *RideUpdateRequest) Apply(ride *Ride) {
+	if u.SrcLat != nil {
+		ride.SrcLat = *u.SrcLat
+	}
+	if u.SrcLon != nil {
+		ride.SrcLon = *u.SrcLon
+	}
+	if u.DstLat != nil {
+		ride.DstLat = *u.DstLat
+	}
+	if u.DstLon != nil {
+		ride.DstLon = *u.DstLon
+	}
+	if u.VehicleClass != nil {
+		ride.VehicleClass = *u.VehicleClass
+	}
+	if u.Seats != nil {
+		ride.Seats = *u.Seats
+	}
+}
+
+// StartRideRequest is the body used by drivers to start a ride, the PIN is the one shown by the passenger
+type StartRideRequest struct {
+	PIN string `json:"pin"`
+}
+
+// Coordinate is a point on Earth in degrees
+type Coordinate struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// RideStop is an intermediate stop of a ride, Seq gives the order in which the stops are visited
+// starting at 1. ArrivedAt and DepartedAt are set when the driver reaches and leaves the stop.
+type RideStop struct {
+	ID         int        `json:"id" db:"id"`
+	RideID     int        `json:"ride_id" db:"ride_id"`
+	Seq        int        `json:"seq" db:"seq"`
+	Lat        float64    `json:"lat" db:"lat"`
+	Lon        float64    `json:"lon" db:"lon"`
+	ArrivedAt  *time.Time `json:"arrived_at,omitempty" db:"arrived_at"`
+	DepartedAt *time.Time `json:"departed_at,omitempty" db:"departed_at"`
+}
+
+// Scan is a method that allows us to convert a row from the database into a RideStop struct
+func (s *RideStop) Scan(row pgx.Row) error {
+	var arrivedAt, departedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.RideID, &s.Seq, &s.Lat, &s.Lon, &arrivedAt, &departedAt)
+	if err != nil {
+		return err
+	}
+	s.ArrivedAt = nil
+	if arrivedAt.Valid {
+		s.ArrivedAt = &arrivedAt.Time
+	}
+	s.DepartedAt = nil
+	if departedAt.Valid {
+		s.DepartedAt = &departedAt.Time
+	}
 	return nil
 }
 
@@ -69,11 +255,16 @@ type RideOutbox struct {
 	ID     int        `json:"id" db:"id"`
 	RideID int        `json:"ride_id" db:"ride_id"`
 	Status RideStatus `json:"status" db:"status"`
+	// StopSeq is the stop the event refers to for stop events, 0 otherwise
+	StopSeq int `json:"stop_seq,omitempty" db:"stop_seq"`
+
+	// Ride is filled before the outbox is sent to the drivers so the offer shows the whole route
+	Ride *Ride `json:"ride,omitempty"`
 }
 
 // Scan is a method that allows us to convert a row from the database into a RideOutbox struct
 func (r *RideOutbox) Scan(row pgx.Row) error {
-	return row.Scan(&r.ID, &r.RideID, &r.Status)
+	return row.Scan(&r.ID, &r.RideID, &r.Status, &r.StopSeq)
 }
 
 // NewRideOutbox creates a new RideOutbox struct
@@ -83,3 +274,41 @@ func NewRideOutbox(ride *Ride) *RideOutbox {
 		Status: ride.Status,
 	}
 }
+
+// RideETA is the estimated duration of a ride, it is computed with the estimate of the price and never stored
+type RideETA struct {
+	TripSeconds    int     `json:"trip_seconds"`
+	TripDistanceKm float64 `json:"trip_distance_km"`
+	// PickupSeconds is the time for the closest driver to reach the pickup, nil when no driver is around
+	PickupSeconds *int `json:"pickup_seconds,omitempty"`
+}
+
+// NearbyDriver is a driver around a pickup point and the time to reach it
+type NearbyDriver struct {
+	DriverID   string  `json:"driver_id"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	DistanceKm float64 `json:"distance_km"`
+	ETASeconds int     `json:"eta_seconds"`
+}
+
+// nearbyDriverDecimals are the decimals of the positions of the drivers shown to the passengers, about 100 m
+const nearbyDriverDecimals = 3
+
+// NearbyDriverView is what a passenger sees of a nearby driver, the time to reach the pickup and a coarse
+// position to draw it. The ID and the exact position of the driver are not shown.
+type NearbyDriverView struct {
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	ETASeconds int     `json:"eta_seconds"`
+}
+
+// View returns the driver as seen by the passengers
+func (d NearbyDriver) View() NearbyDriverView {
+	scale := math.Pow(10, nearbyDriverDecimals)
+	return NearbyDriverView{
+		Latitude:   math.Round(d.Latitude*scale) / scale,
+		Longitude:  math.Round(d.Longitude*scale) / scale,
+		ETASeconds: d.ETASeconds,
+	}
+}
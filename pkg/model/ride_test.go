@@ -0,0 +1,57 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldApplyRideUpdate(t *testing.T) {
+	ride := &Ride{ID: 1, PassengerID: 2, Price: 10, Status: RideStatusInTransit, SrcLat: 1, SrcLon: 2, DstLat: 3, DstLon: 4, Seats: 1}
+
+	// The fields out of the update are ignored
+	var update RideUpdateRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"dst_lat": 5, "dst_lon": 6, "price": 0, "status": "passenger_dropped"}`), &update))
+	assert.False(t, update.ChangesMatch())
+	update.Apply(ride)
+	assert.Equal(t, &Ride{ID: 1, PassengerID: 2, Price: 10, Status: RideStatusInTransit, SrcLat: 1, SrcLon: 2, DstLat: 5, DstLon: 6, Seats: 1}, ride)
+
+	update = RideUpdateRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{"seats": 4}`), &update))
+	assert.True(t, update.ChangesMatch())
+	update.Apply(ride)
+	assert.Equal(t, 4, ride.Seats)
+
+	assert.True(t, RideStatusCompleted.Ended())
+	assert.False(t, RideStatusPickingUp.Ended())
+}
+
+func TestShouldCancelRidesBeforeTransit(t *testing.T) {
+	for _, status := range []RideStatus{RideStatusScheduled, RideStatusPending, RideStatusPassengerAccepted, RideStatusDriverAccepted, RideStatusPickingUp} {
+		assert.True(t, status.Cancellable(), status)
+	}
+	for _, status := range []RideStatus{RideStatusInTransit, RideStatusCompleted, RideStatusPassengerCancelled, RideStatusDriverCancelled, RideStatusErrored} {
+		assert.False(t, status.Cancellable(), status)
+	}
+}
+
+func TestShouldTellEventsFromStatuses(t *testing.T) {
+	for _, status := range []RideStatus{RideEventStopArrived, RideEventStopDeparted, RideEventUpdated} {
+		assert.True(t, status.Event(), status)
+	}
+	for _, status := range []RideStatus{RideStatusPending, RideStatusPassengerAccepted, RideStatusInTransit, RideStatusDeleted} {
+		assert.False(t, status.Event(), status)
+	}
+}
+
+func TestShouldHideNearbyDrivers(t *testing.T) {
+	driver := NearbyDriver{DriverID: "7", Latitude: 40.416775, Longitude: -3.703790, DistanceKm: 1.2, ETASeconds: 240}
+	view := driver.View()
+	assert.Equal(t, NearbyDriverView{Latitude: 40.417, Longitude: -3.704, ETASeconds: 240}, view)
+
+	encoded, err := json.Marshal(view)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "driver_id")
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// TrailPoint is a fix of the trail of a driver, RideID is the ride the driver was doing when it was taken
+type TrailPoint struct {
+	DriverID   int       `json:"driver_id"`
+	RideID     *int      `json:"ride_id,omitempty"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Accuracy   float64   `json:"accuracy,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+	// StreamID identifies the fix in the trail stream of the driver, it makes the flushes idempotent
+	StreamID string `json:"-"`
+}
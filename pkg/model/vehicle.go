@@ -0,0 +1,50 @@
+package model
+
+import (
+	"github.com/jackc/pgx/v4"
+)
+
+type VehicleClass string
+
+const (
+	VehicleClassEconomy VehicleClass = "economy"
+	VehicleClassXL      VehicleClass = "xl"
+	VehicleClassPremium VehicleClass = "premium"
+)
+
+// DefaultVehicleSeats is the number of seats of the vehicles the drivers register before the staff sets them
+const DefaultVehicleSeats = 4
+
+// Vehicle represents the vehicle a driver uses to serve rides
+// The class and the seats are used to match the rides with the right vehicles and the accessibility
+// features are free form tags such as "wheelchair" or "child_seat"
+type Vehicle struct {
+	ID            int          `json:"id"`
+	DriverID      int          `json:"driver_id"`
+	Make          string       `json:"make"`
+	Plate         string       `json:"plate"`
+	Class         VehicleClass `json:"class"`
+	Seats         int          `json:"seats"`
+	Accessibility []string     `json:"accessibility"`
+}
+
+func (v *Vehicle) Scan(row pgx.Row) error {
+	return row.Scan(
+		&v.ID,
+		&v.DriverID,
+		&v.Make,
+		&v.Plate,
+		&v.Class,
+		&v.Seats,
+		&v.Accessibility,
+	)
+}
+
+// Fits returns true when the vehicle can serve a request for the given class and number of seats,
+// an empty class matches any vehicle
+func (v *Vehicle) Fits(class VehicleClass, seats int) bool {
+	if class != "" && v.Class != class {
+		return false
+	}
+	return v.Seats >= seats
+}
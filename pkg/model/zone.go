@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type ZoneKind string
+
+const (
+	// ZoneKindServed is an operational zone, the rides must start and end in one of them
+	ZoneKindServed ZoneKind = "served"
+	// ZoneKindRestricted is a zone such as an airport where the pickups are only allowed at designated points
+	ZoneKindRestricted ZoneKind = "restricted"
+	// ZoneKindStaging is where the drivers wait in line for the pickups of a restricted zone such as an airport
+	ZoneKindStaging ZoneKind = "staging"
+)
+
+// Zone is an area of a region defined by a GeoJSON polygon, multipolygon or a feature holding one of them
+type Zone struct {
+	ID     int             `json:"id"`
+	Region string          `json:"region"`
+	Name   string          `json:"name"`
+	Kind   ZoneKind        `json:"kind"`
+	Area   json.RawMessage `json:"area"`
+	// PickupPoints are the designated pickup points of a restricted zone
+	PickupPoints []Coordinate `json:"pickup_points,omitempty"`
+	// Surcharge is added to the price of the rides starting or ending in the zone
+	Surcharge float64 `json:"surcharge,omitempty"`
+	// PickupZoneID is the zone whose rides are offered to the queue of a staging zone
+	PickupZoneID int `json:"pickup_zone_id,omitempty"`
+}
+
+// Scan is a method that allows us to convert a row from the database into a Zone struct, the area and the
+// pickup points are stored as JSON
+func (z *Zone) Scan(row pgx.Row) error {
+	var area, pickupPoints []byte
+	err := row.Scan(&z.ID, &z.Region, &z.Name, &z.Kind, &area, &pickupPoints, &z.Surcharge, &z.PickupZoneID)
+	if err != nil {
+		return err
+	}
+	z.Area = area
+	z.PickupPoints = nil
+	if len(pickupPoints) > 0 {
+		return json.Unmarshal(pickupPoints, &z.PickupPoints)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package pooling matches ride requests with in-progress pooled rides going the same direction.
+//
+// A pool is a vehicle shared by several rides, it keeps the ordered list of pending pickups and
+// dropoffs. A new ride is inserted in the pool whose route grows the least, as long as the detour
+// stays within the configured limits and the vehicle has free seats along the whole route.
+package pooling
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/OscarMoya/Glubber/pkg/billing"
+	"github.com/OscarMoya/Glubber/pkg/location"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+type StopKind string
+
+const (
+	// StopPickup is the stop where a passenger boards the vehicle
+	StopPickup StopKind = "pickup"
+	// StopDropoff is the stop where a passenger leaves the vehicle
+	StopDropoff StopKind = "dropoff"
+)
+
+// Stop is a pending pickup or dropoff of a ride in the pool
+type Stop struct {
+	RideID int              `json:"ride_id"`
+	Kind   StopKind         `json:"kind"`
+	Point  model.Coordinate `json:"point"`
+}
+
+// Pool is a vehicle shared by several rides. Position is the last known position of the driver,
+// Driven the pickups of the passengers on board in the order they boarded, and Stops the pickups and
+// dropoffs still to be done, in order.
+type Pool struct {
+	ID       int
+	DriverID string
+	Capacity int
+	Position model.Coordinate
+	Rides    []*model.Ride
+	Driven   []model.Coordinate
+	Stops    []Stop
+}
+
+// Route returns the remaining route of the pool starting at the driver position
+func (p *Pool) Route() []model.Coordinate {
+	return routeOf(p.Position, p.Stops)
+}
+
+// FullRoute returns the route of the pool from the first pickup of the passengers on board, the legs
+// already driven are billed with the remaining ones
+func (p *Pool) FullRoute() []model.Coordinate {
+	route := make([]model.Coordinate, 0, len(p.Driven)+len(p.Stops)+1)
+	route = append(route, p.Driven...)
+	return append(route, p.Route()...)
+}
+
+// onBoard returns the number of seats taken in the vehicle, the ones of the rides with a pending
+// dropoff but no pending pickup
+func (p *Pool) onBoard(seats map[int]int) int {
+	pickups := map[int]bool{}
+	for _, stop := range p.Stops {
+		if stop.Kind == StopPickup {
+			pickups[stop.RideID] = true
+		}
+	}
+	n := 0
+	for _, stop := range p.Stops {
+		if stop.Kind == StopDropoff && !pickups[stop.RideID] {
+			n += seats[stop.RideID]
+		}
+	}
+	return n
+}
+
+// seats returns the seats taken by every ride of the pool and by the new ride
+func (p *Pool) seats(ride *model.Ride) map[int]int {
+	seats := make(map[int]int, len(p.Rides)+1)
+	for _, pooled := range p.Rides {
+		seats[pooled.ID] = seatsOf(pooled)
+	}
+	seats[ride.ID] = seatsOf(ride)
+	return seats
+}
+
+// seatsOf returns the seats of a ride, a ride without seats takes one
+func seatsOf(ride *model.Ride) int {
+	return max(ride.Seats, 1)
+}
+
+// MatcherOpts configures the Matcher
+// SearchRadiusKm is the radius around the pickup where the drivers of the candidate pools are searched
+// MaxDetourKm bounds how much longer the remaining route of a pool can get with the new ride
+// MaxDetourRatio bounds the extra distance travelled by the new passenger compared to a solo ride
+type MatcherOpts struct {
+	Locations      location.LocationManager
+	Biller         billing.PoolBiller
+	SearchRadiusKm float64
+	MaxDetourKm    float64
+	MaxDetourRatio float64
+}
+
+type Matcher struct {
+	MatcherOpts
+}
+
+// NewMatcher creates a new Matcher
+func NewMatcher(opts MatcherOpts) *Matcher {
+	return &Matcher{MatcherOpts: opts}
+}
+
+// Match looks for the pool that can take the ride with the smallest detour. When found, the ride is
+// assigned to the pool, the pickups and dropoffs are reordered and the fare is split between all the
+// rides of the pool. When no pool can take the ride, nil is returned.
+func (m *Matcher) Match(ctx context.Context, pools []*Pool, ride *model.Ride) (*Pool, error) {
+	if !ride.Pooled {
+		return nil, fmt.Errorf("ride %d did not request pooling", ride.ID)
+	}
+
+	nearby, err := m.Locations.GetNearbyDriversByClass(ctx, ride.SrcLat, ride.SrcLon, m.SearchRadiusKm, string(ride.VehicleClass))
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]bool, len(nearby))
+	for _, driverID := range nearby {
+		candidates[driverID] = true
+	}
+
+	var best *Pool
+	var bestStops []Stop
+	bestDetour := math.Inf(1)
+	for _, pool := range pools {
+		if !candidates[pool.DriverID] {
+			continue
+		}
+		stops, detour, ok := m.insert(pool, ride)
+		if ok && detour < bestDetour {
+			best, bestStops, bestDetour = pool, stops, detour
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	best.Stops = bestStops
+	best.Rides = append(best.Rides, ride)
+	poolID := best.ID
+	ride.PoolID = &poolID
+
+	err = m.Biller.SplitPooledFare(best.Rides, best.FullRoute())
+	if err != nil {
+		return nil, err
+	}
+	return best, nil
+}
+
+// insert tries every position of the pickup and the dropoff of the ride in the pending stops of the
+// pool, and returns the stops with the smallest detour that satisfies the constraints.
+func (m *Matcher) insert(pool *Pool, ride *model.Ride) ([]Stop, float64, bool) {
+	pickup := Stop{RideID: ride.ID, Kind: StopPickup, Point: model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}}
+	dropoff := Stop{RideID: ride.ID, Kind: StopDropoff, Point: model.Coordinate{Lat: ride.DstLat, Lon: ride.DstLon}}
+
+	seats := pool.seats(ride)
+	onBoard := pool.onBoard(seats)
+	current := billing.RouteDistance(pool.Route())
+	solo := util.CalculateDistance(ride.SrcLat, ride.SrcLon, ride.DstLat, ride.DstLon)
+
+	var best []Stop
+	bestDetour := math.Inf(1)
+	for i := 0; i <= len(pool.Stops); i++ {
+		for j := i; j <= len(pool.Stops); j++ {
+			stops := make([]Stop, 0, len(pool.Stops)+2)
+			stops = append(stops, pool.Stops[:i]...)
+			stops = append(stops, pickup)
+			stops = append(stops, pool.Stops[i:j]...)
+			stops = append(stops, dropoff)
+			stops = append(stops, pool.Stops[j:]...)
+
+			if !fitsCapacity(onBoard, pool.Capacity, stops, seats) {
+				continue
+			}
+			detour := billing.RouteDistance(routeOf(pool.Position, stops)) - current
+			if detour > m.MaxDetourKm {
+				continue
+			}
+			// The new passenger travels from its pickup (position i) to its dropoff (position j+1)
+			travelled := billing.RouteDistance(routeOf(pickup.Point, stops[i+1:j+2]))
+			if travelled > solo*(1+m.MaxDetourRatio) {
+				continue
+			}
+			if detour < bestDetour {
+				best, bestDetour = stops, detour
+			}
+		}
+	}
+	return best, bestDetour, best != nil
+}
+
+// fitsCapacity checks that the seats taken in the vehicle never exceed the capacity
+func fitsCapacity(onBoard, capacity int, stops []Stop, seats map[int]int) bool {
+	load := onBoard
+	for _, stop := range stops {
+		switch stop.Kind {
+		case StopPickup:
+			load += seats[stop.RideID]
+		case StopDropoff:
+			load -= seats[stop.RideID]
+		}
+		if load > capacity {
+			return false
+		}
+	}
+	return true
+}
+
+func routeOf(start model.Coordinate, stops []Stop) []model.Coordinate {
+	route := make([]model.Coordinate, 0, len(stops)+1)
+	route = append(route, start)
+	for _, stop := range stops {
+		route = append(route, stop.Point)
+	}
+	return route
+}
@@ -0,0 +1,205 @@
+package pooling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/billing"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticLocations is a LocationManager returning always the same drivers, so the matcher can be
+// tested with synthetic trips
+type staticLocations struct {
+	drivers []string
+}
+
+func (s *staticLocations) SaveDriverLocation(ctx context.Context, driverID string, latitude, longitude float64) error {
+	return nil
+}
+
+func (s *staticLocations) RemoveDriverLocation(ctx context.Context, driverID string) error {
+	return nil
+}
+
+func (s *staticLocations) GetNearbyDrivers(ctx context.Context, latitude, longitude, radius float64) ([]string, error) {
+	return s.drivers, nil
+}
+
+func (s *staticLocations) SetDriverVehicleClass(ctx context.Context, driverID string, class string) error {
+	return nil
+}
+
+func (s *staticLocations) GetDriverLocation(ctx context.Context, driverID string) (float64, float64, error) {
+	return 0, 0, nil
+}
+
+func (s *staticLocations) GetNearbyDriversByClass(ctx context.Context, latitude, longitude, radius float64, class string) ([]string, error) {
+	return s.drivers, nil
+}
+
+func (s *staticLocations) FlagDriverLocation(ctx context.Context, driverID string, reason string) error {
+	return nil
+}
+
+// syntheticRide builds a ride starting startKm east of the base point and going lengthKm in the
+// given bearing
+func syntheticRide(id int, startKm, lengthKm, bearing float64) *model.Ride {
+	baseLat, baseLon := 40.7128, -74.0
+	srcLat, srcLon := util.AddKM(baseLat, baseLon, startKm, 90)
+	dstLat, dstLon := util.AddKM(srcLat, srcLon, lengthKm, bearing)
+	return &model.Ride{ID: id, Pooled: true, SrcLat: srcLat, SrcLon: srcLon, DstLat: dstLat, DstLon: dstLon}
+}
+
+// withSeats sets the seats requested by the ride
+func withSeats(ride *model.Ride, seats int) *model.Ride {
+	ride.Seats = seats
+	return ride
+}
+
+func newTestPool(capacity int) *Pool {
+	first := syntheticRide(1, 0, 10, 90)
+	return &Pool{
+		ID:       100,
+		DriverID: "driver1",
+		Capacity: capacity,
+		Position: model.Coordinate{Lat: first.SrcLat, Lon: first.SrcLon},
+		Rides:    []*model.Ride{first},
+		Stops: []Stop{
+			{RideID: first.ID, Kind: StopDropoff, Point: model.Coordinate{Lat: first.DstLat, Lon: first.DstLon}},
+		},
+	}
+}
+
+func TestShouldMatchPooledRides(t *testing.T) {
+	matcher := NewMatcher(MatcherOpts{
+		Locations:      &staticLocations{drivers: []string{"driver1"}},
+		Biller:         billing.NewSimpleBiller(2.0, 1.0),
+		SearchRadiusKm: 5,
+		MaxDetourKm:    2,
+		MaxDetourRatio: 0.5,
+	})
+
+	tests := []struct {
+		name          string
+		capacity      int
+		ride          *model.Ride
+		expectedMatch bool
+		expectedStops []StopKind
+	}{
+		{
+			name:          "Same direction inside the route",
+			capacity:      3,
+			ride:          syntheticRide(2, 2, 5, 90),
+			expectedMatch: true,
+			expectedStops: []StopKind{StopPickup, StopDropoff, StopDropoff},
+		},
+		{
+			name:          "Same direction going further",
+			capacity:      3,
+			ride:          syntheticRide(2, 5, 6, 90),
+			expectedMatch: true,
+			expectedStops: []StopKind{StopPickup, StopDropoff, StopDropoff},
+		},
+		{
+			name:          "Opposite direction",
+			capacity:      3,
+			ride:          syntheticRide(2, 2, 5, 270),
+			expectedMatch: false,
+		},
+		{
+			name:          "No free seats",
+			capacity:      1,
+			ride:          syntheticRide(2, 2, 5, 90),
+			expectedMatch: false,
+		},
+		{
+			name:          "Group fitting the free seats",
+			capacity:      3,
+			ride:          withSeats(syntheticRide(2, 2, 5, 90), 2),
+			expectedMatch: true,
+			expectedStops: []StopKind{StopPickup, StopDropoff, StopDropoff},
+		},
+		{
+			name:          "Group larger than the free seats",
+			capacity:      3,
+			ride:          withSeats(syntheticRide(2, 2, 5, 90), 3),
+			expectedMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(tt.capacity)
+			matched, err := matcher.Match(context.Background(), []*Pool{pool}, tt.ride)
+			require.NoError(t, err)
+			if !tt.expectedMatch {
+				assert.Nil(t, matched)
+				assert.Nil(t, tt.ride.PoolID)
+				return
+			}
+			require.NotNil(t, matched)
+			require.NotNil(t, tt.ride.PoolID)
+			assert.Equal(t, pool.ID, *tt.ride.PoolID)
+			assert.Len(t, matched.Rides, 2)
+
+			kinds := make([]StopKind, 0, len(matched.Stops))
+			for _, stop := range matched.Stops {
+				kinds = append(kinds, stop.Kind)
+			}
+			assert.Equal(t, tt.expectedStops, kinds)
+
+			// Every passenger pays less than riding alone
+			for _, ride := range matched.Rides {
+				solo := 2.0 + billing.RouteDistance(ride.Route())
+				assert.Less(t, ride.Price, solo)
+			}
+		})
+	}
+}
+
+func TestShouldSkipPoolsOfFarDrivers(t *testing.T) {
+	matcher := NewMatcher(MatcherOpts{
+		Locations:      &staticLocations{},
+		Biller:         billing.NewSimpleBiller(2.0, 1.0),
+		SearchRadiusKm: 5,
+		MaxDetourKm:    2,
+		MaxDetourRatio: 0.5,
+	})
+
+	matched, err := matcher.Match(context.Background(), []*Pool{newTestPool(3)}, syntheticRide(2, 2, 5, 90))
+	require.NoError(t, err)
+	assert.Nil(t, matched)
+}
+
+func TestShouldBillTheDistanceAlreadyDriven(t *testing.T) {
+	matcher := NewMatcher(MatcherOpts{
+		Locations:      &staticLocations{drivers: []string{"driver1"}},
+		Biller:         billing.NewSimpleBiller(2.0, 1.0),
+		SearchRadiusKm: 5,
+		MaxDetourKm:    2,
+		MaxDetourRatio: 0.5,
+	})
+
+	// The first passenger boarded at the start of the route and the driver is 4 km further
+	pool := newTestPool(3)
+	first := pool.Rides[0]
+	pool.Driven = []model.Coordinate{{Lat: first.SrcLat, Lon: first.SrcLon}}
+	lat, lon := util.AddKM(first.SrcLat, first.SrcLon, 4, 90)
+	pool.Position = model.Coordinate{Lat: lat, Lon: lon}
+
+	matched, err := matcher.Match(context.Background(), []*Pool{pool}, syntheticRide(2, 5, 3, 90))
+	require.NoError(t, err)
+	require.NotNil(t, matched)
+
+	// The whole route is billed once, not only the part left
+	assert.InDelta(t, 10.0, billing.RouteDistance(matched.FullRoute()), 0.01)
+	total := 0.0
+	for _, ride := range matched.Rides {
+		total += ride.Price
+	}
+	assert.InDelta(t, 2.0+10.0, total, 0.01)
+}
@@ -7,6 +7,13 @@ import (
 	"github.com/IBM/sarama"
 )
 
+// Consumer is the interface that wraps the consumption of messages from a set of topics
+type Consumer interface {
+	ConsumeMessages(ctx context.Context, topics []string) error
+	Messages() <-chan *sarama.ConsumerMessage
+	Close() error
+}
+
 type SaramaKafkaConsumer struct {
 	consumerGroup sarama.ConsumerGroup
 	messages      chan *sarama.ConsumerMessage
@@ -55,6 +62,11 @@ func (sc *SaramaKafkaConsumer) ConsumeMessages(ctx context.Context, topics []str
 	return nil
 }
 
+// Messages returns the channel where the consumed messages are delivered
+func (sc *SaramaKafkaConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return sc.messages
+}
+
 func (sc *SaramaKafkaConsumer) handleErrors() {
 	for err := range sc.consumerGroup.Errors() {
 		log.Printf("consumer error: %v", err)
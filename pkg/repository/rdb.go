@@ -31,9 +31,13 @@ type Transaction interface {
 // DBRepository represent a PostgreSQL repository.
 type DBRepository struct {
 	db       *sql.DB
+	connStr  string
 	listener *pq.Listener
 }
 
+// NewDBRepository connects to the database and listens to the channel. An empty channel does not listen,
+// for the services that only write: a listening session that never reads the notifications stops Postgres
+// from trimming its notification queue, until every NOTIFY fails.
 func NewDBRepository(connStr string, channel string) (Repository, error) {
 	/*
 		connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d sslmode=%s",
@@ -49,22 +53,17 @@ func NewDBRepository(connStr string, channel string) (Repository, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
-		if err != nil {
-			log.Printf("Listener error: %v", err)
-		}
-	})
-
-	err = listener.Listen(channel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to listen to channel: %w", err)
+	repo := &DBRepository{db: db, connStr: connStr}
+	if channel == "" {
+		return repo, nil
 	}
-
-	if err := listener.Ping(); err != nil {
+	if err := repo.Listen(context.Background(), channel); err != nil {
+		return nil, err
+	}
+	if err := repo.listener.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to initialize listener: %w", err)
 	}
-
-	return &DBRepository{db: db, listener: listener}, nil
+	return repo, nil
 }
 
 func (repo *DBRepository) CreateTable(ctx context.Context, createStmt string) error {
@@ -81,8 +80,15 @@ func (repo *DBRepository) BeginTransaction(ctx context.Context) (Transaction, er
 	return &DBTransaction{tx: tx}, nil
 }
 
-// Start a new Listen request to a channel
+// Start a new Listen request to a channel, the listener is created with the first one
 func (repo *DBRepository) Listen(ctx context.Context, channel string) error {
+	if repo.listener == nil {
+		repo.listener = pq.NewListener(repo.connStr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Listener error: %v", err)
+			}
+		})
+	}
 	err := repo.listener.Listen(channel)
 	if err != nil {
 		return fmt.Errorf("error listening to channel %s: %w", channel, err)
@@ -92,12 +98,16 @@ func (repo *DBRepository) Listen(ctx context.Context, channel string) error {
 	return nil
 }
 
-// Creates new notification channel and returns it
+// Creates new notification channel and returns it, it gets nothing until a channel is listened
 func (repo *DBRepository) Notifications(ctx context.Context) <-chan *pq.Notification {
 	notificationChan := make(chan *pq.Notification)
 
 	go func() {
 		defer close(notificationChan)
+		if repo.listener == nil {
+			<-ctx.Done()
+			return
+		}
 		for {
 			select {
 			case <-ctx.Done():
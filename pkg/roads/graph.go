@@ -0,0 +1,178 @@
+// Package roads holds the road network of a region loaded from a local OpenStreetMap extract, so points can
+// be matched to the roads without calling an external service.
+package roads
+
+import (
+	"math"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// gridCellDegrees is the size of the cells of the snapping index, about 550m of latitude
+const gridCellDegrees = 0.005
+
+// Node is a junction or a shape point of a road
+type Node struct {
+	ID  int64
+	Lat float64
+	Lon float64
+}
+
+// Edge is a piece of road between two consecutive nodes of a way, in the direction it can be driven
+type Edge struct {
+	To         int
+	DistanceKm float64
+	// Highway is the OSM highway class of the road, e.g. residential or primary
+	Highway string
+	// SpeedKmh is the speed limit of the road or the usual speed of its class
+	SpeedKmh float64
+}
+
+// Hours returns the time to drive the edge
+func (e Edge) Hours() float64 {
+	return e.DistanceKm / e.SpeedKmh
+}
+
+// highwaySpeedsKmh are the speeds of the roads without speed limit in the extract
+var highwaySpeedsKmh = map[string]float64{
+	"motorway": 100, "motorway_link": 60,
+	"trunk": 80, "trunk_link": 50,
+	"primary": 50, "primary_link": 40,
+	"secondary": 40, "secondary_link": 35,
+	"tertiary": 35, "tertiary_link": 30,
+	"unclassified": 30, "residential": 25, "living_street": 10, "service": 15,
+}
+
+// defaultSpeedKmh is the speed of the highway classes without speed
+const defaultSpeedKmh = 25.0
+
+// HighwaySpeedKmh returns the usual speed of a highway class
+func HighwaySpeedKmh(highway string) float64 {
+	if speed, ok := highwaySpeedsKmh[highway]; ok {
+		return speed
+	}
+	return defaultSpeedKmh
+}
+
+// segment is an undirected piece of road, indexed for snapping
+type segment struct {
+	from, to int
+}
+
+type cell struct {
+	lat, lon int
+}
+
+// Graph is a directed road graph, nodes are referenced by their index in Nodes
+type Graph struct {
+	Nodes []Node
+	// Edges are the edges leaving every node, by node index
+	Edges [][]Edge
+
+	segments []segment
+	grid     map[cell][]int
+}
+
+// NewGraph creates an empty graph
+func NewGraph() *Graph {
+	return &Graph{grid: make(map[cell][]int)}
+}
+
+// AddNode adds a node and returns its index
+func (g *Graph) AddNode(node Node) int {
+	g.Nodes = append(g.Nodes, node)
+	g.Edges = append(g.Edges, nil)
+	return len(g.Nodes) - 1
+}
+
+// AddRoad links two nodes, in both directions unless oneway, at the usual speed of the highway class
+func (g *Graph) AddRoad(from, to int, highway string, oneway bool) {
+	g.AddRoadWithSpeed(from, to, highway, HighwaySpeedKmh(highway), oneway)
+}
+
+// AddRoadWithSpeed links two nodes, in both directions unless oneway, at the given speed
+func (g *Graph) AddRoadWithSpeed(from, to int, highway string, speedKmh float64, oneway bool) {
+	if speedKmh <= 0 {
+		speedKmh = HighwaySpeedKmh(highway)
+	}
+	a, b := g.Nodes[from], g.Nodes[to]
+	distance := util.CalculateDistance(a.Lat, a.Lon, b.Lat, b.Lon)
+	g.Edges[from] = append(g.Edges[from], Edge{To: to, DistanceKm: distance, Highway: highway, SpeedKmh: speedKmh})
+	if !oneway {
+		g.Edges[to] = append(g.Edges[to], Edge{To: from, DistanceKm: distance, Highway: highway, SpeedKmh: speedKmh})
+	}
+	g.index(segment{from: from, to: to})
+}
+
+// Edge returns the edge driving from one node to the other, false when the road does not go that way
+func (g *Graph) Edge(from, to int) (Edge, bool) {
+	for _, e := range g.Edges[from] {
+		if e.To == to {
+			return e, true
+		}
+	}
+	return Edge{}, false
+}
+
+// index adds the segment to every cell its bounding box covers
+func (g *Graph) index(s segment) {
+	id := len(g.segments)
+	g.segments = append(g.segments, s)
+	a, b := g.Nodes[s.from], g.Nodes[s.to]
+	minCell := cellOf(math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon))
+	maxCell := cellOf(math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon))
+	for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+		for lon := minCell.lon; lon <= maxCell.lon; lon++ {
+			c := cell{lat: lat, lon: lon}
+			g.grid[c] = append(g.grid[c], id)
+		}
+	}
+}
+
+func cellOf(lat, lon float64) cell {
+	return cell{lat: int(math.Floor(lat / gridCellDegrees)), lon: int(math.Floor(lon / gridCellDegrees))}
+}
+
+// Snap is a point matched to the closest road
+type Snap struct {
+	Point model.Coordinate
+	// DistanceKm is the distance from the original point to the road
+	DistanceKm float64
+	// From and To are the nodes of the segment and Fraction how far along it the point is, from 0 to 1
+	From, To int
+	Fraction float64
+}
+
+// Snap returns the closest point on a road within maxDistanceKm, false when there is none
+func (g *Graph) Snap(p model.Coordinate, maxDistanceKm float64) (Snap, bool) {
+	// The cells within the distance around the cell of the point, a degree of longitude is shorter than one of
+	// latitude away from the equator
+	latCells := int(math.Ceil(maxDistanceKm / (gridCellDegrees * 110.574)))
+	lonCells := int(math.Ceil(maxDistanceKm / (gridCellDegrees * 111.320 * math.Max(math.Cos(p.Lat*math.Pi/180), 0.01))))
+	center := cellOf(p.Lat, p.Lon)
+
+	best := Snap{DistanceKm: math.Inf(1)}
+	seen := make(map[int]bool)
+	for lat := center.lat - latCells; lat <= center.lat+latCells; lat++ {
+		for lon := center.lon - lonCells; lon <= center.lon+lonCells; lon++ {
+			for _, id := range g.grid[cell{lat: lat, lon: lon}] {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				s := g.segments[id]
+				a, b := g.Nodes[s.from], g.Nodes[s.to]
+				point, fraction := util.ProjectToSegment(p, model.Coordinate{Lat: a.Lat, Lon: a.Lon}, model.Coordinate{Lat: b.Lat, Lon: b.Lon})
+				distance := util.CalculateDistance(p.Lat, p.Lon, point.Lat, point.Lon)
+				if distance < best.DistanceKm {
+					best = Snap{Point: point, DistanceKm: distance, From: s.from, To: s.to, Fraction: fraction}
+				}
+			}
+		}
+	}
+	if best.DistanceKm > maxDistanceKm {
+		return Snap{}, false
+	}
+	return best, true
+}
@@ -0,0 +1,201 @@
+package roads
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// drivableHighways are the OSM highway classes cars can drive on
+var drivableHighways = map[string]bool{
+	"motorway": true, "motorway_link": true,
+	"trunk": true, "trunk_link": true,
+	"primary": true, "primary_link": true,
+	"secondary": true, "secondary_link": true,
+	"tertiary": true, "tertiary_link": true,
+	"unclassified": true, "residential": true, "living_street": true, "service": true,
+}
+
+// osmWay is a way of the extract with the tags needed to build the graph
+type osmWay struct {
+	Refs     []int64
+	Highway  string
+	Oneway   string
+	MaxSpeed string
+}
+
+// graphBuilder turns the nodes and the ways of an extract into a graph, keeping only the nodes of roads
+type graphBuilder struct {
+	coords map[int64][2]float64
+	ways   []osmWay
+}
+
+func newGraphBuilder() *graphBuilder {
+	return &graphBuilder{coords: make(map[int64][2]float64)}
+}
+
+func (b *graphBuilder) addNode(id int64, lat, lon float64) {
+	b.coords[id] = [2]float64{lat, lon}
+}
+
+func (b *graphBuilder) addWay(way osmWay) {
+	if drivableHighways[way.Highway] && len(way.Refs) > 1 {
+		b.ways = append(b.ways, way)
+	}
+}
+
+func (b *graphBuilder) build() (*Graph, error) {
+	g := NewGraph()
+	indexes := make(map[int64]int)
+	nodeIndex := func(id int64) (int, error) {
+		if i, ok := indexes[id]; ok {
+			return i, nil
+		}
+		coord, ok := b.coords[id]
+		if !ok {
+			return 0, fmt.Errorf("way references missing node %d", id)
+		}
+		i := g.AddNode(Node{ID: id, Lat: coord[0], Lon: coord[1]})
+		indexes[id] = i
+		return i, nil
+	}
+
+	for _, way := range b.ways {
+		// Roads cut at the border of the extract reference nodes out of it, they are skipped
+		speed := parseMaxSpeed(way.MaxSpeed)
+		prev := -1
+		for _, ref := range way.Refs {
+			i, err := nodeIndex(ref)
+			if err != nil {
+				prev = -1
+				continue
+			}
+			if prev >= 0 {
+				switch way.Oneway {
+				case "yes", "true", "1":
+					g.AddRoadWithSpeed(prev, i, way.Highway, speed, true)
+				case "-1", "reverse":
+					g.AddRoadWithSpeed(i, prev, way.Highway, speed, true)
+				default:
+					g.AddRoadWithSpeed(prev, i, way.Highway, speed, way.Highway == "motorway")
+				}
+			}
+			prev = i
+		}
+	}
+	if len(g.Nodes) == 0 {
+		return nil, fmt.Errorf("no roads in the extract")
+	}
+	return g, nil
+}
+
+// LoadOSMXML builds the road graph from an OSM XML extract, as exported by osmium or the OSM website
+func LoadOSMXML(r io.Reader) (*Graph, error) {
+	b := newGraphBuilder()
+	decoder := xml.NewDecoder(r)
+	var way *osmWay
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "node":
+				var node struct {
+					ID  int64   `xml:"id,attr"`
+					Lat float64 `xml:"lat,attr"`
+					Lon float64 `xml:"lon,attr"`
+				}
+				if err := decoder.DecodeElement(&node, &t); err != nil {
+					return nil, err
+				}
+				b.addNode(node.ID, node.Lat, node.Lon)
+			case "way":
+				way = &osmWay{}
+			case "nd":
+				if way != nil {
+					ref, err := int64Attr(t, "ref")
+					if err != nil {
+						return nil, err
+					}
+					way.Refs = append(way.Refs, ref)
+				}
+			case "tag":
+				if way != nil {
+					switch attr(t, "k") {
+					case "highway":
+						way.Highway = attr(t, "v")
+					case "oneway":
+						way.Oneway = attr(t, "v")
+					case "maxspeed":
+						way.MaxSpeed = attr(t, "v")
+					}
+				}
+			case "relation":
+				// Relations are not needed for the road graph
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "way" && way != nil {
+				b.addWay(*way)
+				way = nil
+			}
+		}
+	}
+	return b.build()
+}
+
+// LoadOSMFile builds the road graph from an OSM file, PBF when the name ends in .pbf and XML otherwise
+func LoadOSMFile(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".pbf") {
+		return LoadOSMPBF(bufio.NewReader(f))
+	}
+	return LoadOSMXML(f)
+}
+
+// parseMaxSpeed returns the speed limit of a maxspeed tag in km/h, 0 when there is none or it is not a number
+// like "none" or "signals"
+func parseMaxSpeed(value string) float64 {
+	value = strings.TrimSpace(value)
+	factor := 1.0
+	if strings.HasSuffix(value, "mph") {
+		value = strings.TrimSpace(strings.TrimSuffix(value, "mph"))
+		factor = 1.609344
+	}
+	speed, err := strconv.ParseFloat(value, 64)
+	if err != nil || speed <= 0 {
+		return 0
+	}
+	return speed * factor
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func int64Attr(e xml.StartElement, name string) (int64, error) {
+	return strconv.ParseInt(attr(e, name), 10, 64)
+}
@@ -0,0 +1,390 @@
+package roads
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The PBF format is a sequence of blobs, each one a length prefixed BlobHeader followed by a Blob holding a
+// protocol buffers block, see https://wiki.openstreetmap.org/wiki/PBF_Format. Only the messages and fields
+// needed for the road graph are decoded.
+
+const (
+	// maxBlobHeaderSize and maxBlobSize are the limits of the format
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+// ErrInvalidPBF is returned when the extract is not a valid PBF file
+var ErrInvalidPBF = errors.New("invalid OSM PBF extract")
+
+// supportedPBFFeatures are the required features of the extract the decoder understands
+var supportedPBFFeatures = map[string]bool{"OsmSchema-V0.6": true, "DenseNodes": true}
+
+// Protocol buffers wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// LoadOSMPBF builds the road graph from an OSM PBF extract, the format of the planet and Geofabrik downloads
+func LoadOSMPBF(r io.Reader) (*Graph, error) {
+	b := newGraphBuilder()
+	var sizeBuf [4]byte
+	for {
+		if _, err := io.ReadFull(r, sizeBuf[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		headerSize := binary.BigEndian.Uint32(sizeBuf[:])
+		if headerSize > maxBlobHeaderSize {
+			return nil, fmt.Errorf("%w: blob header of %d bytes", ErrInvalidPBF, headerSize)
+		}
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		blobType, blobSize, err := decodeBlobHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if blobSize > maxBlobSize {
+			return nil, fmt.Errorf("%w: blob of %d bytes", ErrInvalidPBF, blobSize)
+		}
+		blob := make([]byte, blobSize)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return nil, err
+		}
+		data, err := decodeBlob(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		switch blobType {
+		case "OSMHeader":
+			err = checkHeaderBlock(data)
+		case "OSMData":
+			err = decodePrimitiveBlock(data, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.build()
+}
+
+func decodeBlobHeader(data []byte) (string, uint64, error) {
+	var blobType string
+	var size uint64
+	err := forEachField(data, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			blobType = string(data)
+		case 3:
+			size = value
+		}
+		return nil
+	})
+	return blobType, size, err
+}
+
+// decodeBlob returns the uncompressed block of the blob, raw or zlib compressed
+func decodeBlob(blob []byte) ([]byte, error) {
+	var raw, compressed []byte
+	var rawSize uint64
+	var unsupported bool
+	err := forEachField(blob, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			raw = data
+		case 2:
+			rawSize = value
+		case 3:
+			compressed = data
+		case 4, 5, 6, 7:
+			// lzma, bzip2, lz4 and zstd are rarely used, the extracts are zlib compressed
+			unsupported = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case raw != nil:
+		return raw, nil
+	case compressed != nil:
+		if rawSize > maxBlobSize {
+			return nil, fmt.Errorf("%w: block of %d bytes", ErrInvalidPBF, rawSize)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		out := bytes.NewBuffer(make([]byte, 0, rawSize))
+		if _, err := io.Copy(out, io.LimitReader(zr, maxBlobSize+1)); err != nil {
+			return nil, err
+		}
+		if out.Len() > maxBlobSize {
+			return nil, fmt.Errorf("%w: block larger than %d bytes", ErrInvalidPBF, maxBlobSize)
+		}
+		return out.Bytes(), nil
+	case unsupported:
+		return nil, fmt.Errorf("%w: unsupported blob compression", ErrInvalidPBF)
+	}
+	return nil, fmt.Errorf("%w: empty blob", ErrInvalidPBF)
+}
+
+// checkHeaderBlock fails when the extract requires features the decoder does not understand
+func checkHeaderBlock(data []byte) error {
+	return forEachField(data, func(field int, value uint64, data []byte) error {
+		if field == 4 && !supportedPBFFeatures[string(data)] {
+			return fmt.Errorf("%w: unsupported feature %s", ErrInvalidPBF, data)
+		}
+		return nil
+	})
+}
+
+// primitiveBlock holds what is needed to decode the groups of a block
+type primitiveBlock struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (p *primitiveBlock) coord(lat, lon int64) (float64, float64) {
+	return 1e-9 * float64(p.latOffset+p.granularity*lat), 1e-9 * float64(p.lonOffset+p.granularity*lon)
+}
+
+func (p *primitiveBlock) string(index uint64) string {
+	if index >= uint64(len(p.strings)) {
+		return ""
+	}
+	return string(p.strings[index])
+}
+
+func decodePrimitiveBlock(data []byte, b *graphBuilder) error {
+	block := &primitiveBlock{granularity: 100}
+	var groups [][]byte
+	err := forEachField(data, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			return forEachField(data, func(field int, value uint64, data []byte) error {
+				if field == 1 {
+					block.strings = append(block.strings, data)
+				}
+				return nil
+			})
+		case 2:
+			groups = append(groups, data)
+		case 17:
+			block.granularity = int64(value)
+		case 19:
+			block.latOffset = int64(value)
+		case 20:
+			block.lonOffset = int64(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		err := forEachField(group, func(field int, value uint64, data []byte) error {
+			switch field {
+			case 1:
+				return decodeNode(data, block, b)
+			case 2:
+				return decodeDenseNodes(data, block, b)
+			case 3:
+				return decodeWay(data, block, b)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeNode(data []byte, block *primitiveBlock, b *graphBuilder) error {
+	var id, lat, lon int64
+	err := forEachField(data, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			id = zigzag(value)
+		case 8:
+			lat = zigzag(value)
+		case 9:
+			lon = zigzag(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	nodeLat, nodeLon := block.coord(lat, lon)
+	b.addNode(id, nodeLat, nodeLon)
+	return nil
+}
+
+// decodeDenseNodes decodes the nodes packed as deltas of the previous node
+func decodeDenseNodes(data []byte, block *primitiveBlock, b *graphBuilder) error {
+	var ids, lats, lons []int64
+	err := forEachField(data, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			return appendPackedSint64(&ids, value, data)
+		case 8:
+			return appendPackedSint64(&lats, value, data)
+		case 9:
+			return appendPackedSint64(&lons, value, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return fmt.Errorf("%w: dense nodes of different lengths", ErrInvalidPBF)
+	}
+	var id, lat, lon int64
+	for i := range ids {
+		id, lat, lon = id+ids[i], lat+lats[i], lon+lons[i]
+		nodeLat, nodeLon := block.coord(lat, lon)
+		b.addNode(id, nodeLat, nodeLon)
+	}
+	return nil
+}
+
+func decodeWay(data []byte, block *primitiveBlock, b *graphBuilder) error {
+	var keys, vals []uint64
+	var refs []int64
+	err := forEachField(data, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 2:
+			return appendPackedUint64(&keys, value, data)
+		case 3:
+			return appendPackedUint64(&vals, value, data)
+		case 8:
+			return appendPackedSint64(&refs, value, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(keys) != len(vals) {
+		return fmt.Errorf("%w: way with %d keys and %d values", ErrInvalidPBF, len(keys), len(vals))
+	}
+
+	way := osmWay{Refs: make([]int64, len(refs))}
+	var ref int64
+	for i, delta := range refs {
+		ref += delta
+		way.Refs[i] = ref
+	}
+	for i, key := range keys {
+		switch block.string(key) {
+		case "highway":
+			way.Highway = block.string(vals[i])
+		case "oneway":
+			way.Oneway = block.string(vals[i])
+		case "maxspeed":
+			way.MaxSpeed = block.string(vals[i])
+		}
+	}
+	b.addWay(way)
+	return nil
+}
+
+// forEachField calls fn with every field of a protocol buffers message, the value of the varint and fixed
+// fields and the data of the length delimited ones
+func forEachField(buf []byte, fn func(field int, value uint64, data []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad field key", ErrInvalidPBF)
+		}
+		buf = buf[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		var value uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			value, n = binary.Uvarint(buf)
+			if n <= 0 {
+				return fmt.Errorf("%w: bad varint", ErrInvalidPBF)
+			}
+			buf = buf[n:]
+		case wireFixed64:
+			if len(buf) < 8 {
+				return fmt.Errorf("%w: truncated field", ErrInvalidPBF)
+			}
+			value, buf = binary.LittleEndian.Uint64(buf), buf[8:]
+		case wireFixed32:
+			if len(buf) < 4 {
+				return fmt.Errorf("%w: truncated field", ErrInvalidPBF)
+			}
+			value, buf = uint64(binary.LittleEndian.Uint32(buf)), buf[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || length > uint64(len(buf)-n) {
+				return fmt.Errorf("%w: truncated field", ErrInvalidPBF)
+			}
+			data, buf = buf[n:n+int(length)], buf[n+int(length):]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", ErrInvalidPBF, wireType)
+		}
+		if err := fn(field, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPackedUint64 appends the values of a repeated varint field, packed in data or a single value when
+// the field is not packed
+func appendPackedUint64(values *[]uint64, value uint64, data []byte) error {
+	if data == nil {
+		*values = append(*values, value)
+		return nil
+	}
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad packed varint", ErrInvalidPBF)
+		}
+		*values = append(*values, v)
+		data = data[n:]
+	}
+	return nil
+}
+
+// appendPackedSint64 appends the values of a repeated zigzag encoded field
+func appendPackedSint64(values *[]int64, value uint64, data []byte) error {
+	var raw []uint64
+	if err := appendPackedUint64(&raw, value, data); err != nil {
+		return err
+	}
+	for _, v := range raw {
+		*values = append(*values, zigzag(v))
+	}
+	return nil
+}
+
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,178 @@
+package roads
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pb encodes protocol buffers messages for the tests
+type pb struct {
+	buf []byte
+}
+
+func (p *pb) key(field, wireType int) {
+	p.buf = binary.AppendUvarint(p.buf, uint64(field<<3|wireType))
+}
+
+func (p *pb) varint(field int, v uint64) *pb {
+	p.key(field, wireVarint)
+	p.buf = binary.AppendUvarint(p.buf, v)
+	return p
+}
+
+func (p *pb) sint(field int, v int64) *pb {
+	return p.varint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (p *pb) bytes(field int, data []byte) *pb {
+	p.key(field, wireBytes)
+	p.buf = binary.AppendUvarint(p.buf, uint64(len(data)))
+	p.buf = append(p.buf, data...)
+	return p
+}
+
+func (p *pb) packed(field int, values ...uint64) *pb {
+	var data []byte
+	for _, v := range values {
+		data = binary.AppendUvarint(data, v)
+	}
+	return p.bytes(field, data)
+}
+
+// packedDelta packs the values as zigzag deltas, like the ids and coordinates of dense nodes and the refs of
+// the ways
+func (p *pb) packedDelta(field int, values ...int64) *pb {
+	var prev int64
+	var encoded []uint64
+	for _, v := range values {
+		delta := v - prev
+		encoded = append(encoded, uint64(delta<<1)^uint64(delta>>63))
+		prev = v
+	}
+	return p.packed(field, encoded...)
+}
+
+// appendBlob appends a blob of the given type to the file, zlib compressed or raw
+func appendBlob(t *testing.T, file []byte, blobType string, block []byte, compress bool) []byte {
+	t.Helper()
+	blob := &pb{}
+	if compress {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		_, err := w.Write(block)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		blob.varint(2, uint64(len(block))).bytes(3, z.Bytes())
+	} else {
+		blob.bytes(1, block)
+	}
+	header := (&pb{}).bytes(1, []byte(blobType)).varint(3, uint64(len(blob.buf)))
+	file = binary.BigEndian.AppendUint32(file, uint32(len(header.buf)))
+	file = append(file, header.buf...)
+	return append(file, blob.buf...)
+}
+
+// units converts degrees to the units of a block with the default granularity of 100 nanodegrees
+func units(degrees float64) int64 {
+	return int64(math.Round(degrees * 1e7))
+}
+
+// testPBF is testExtract as a PBF file, the first nodes dense and the rest plain
+func testPBF(t *testing.T, features ...string) []byte {
+	t.Helper()
+	header := &pb{}
+	for _, f := range append([]string{"OsmSchema-V0.6", "DenseNodes"}, features...) {
+		header.bytes(4, []byte(f))
+	}
+
+	// The string table starts with an empty string, indexes 0 are not used
+	strs := []string{"", "highway", "residential", "tertiary", "oneway", "yes", "footway", "primary", "maxspeed", "50"}
+	table := &pb{}
+	for _, s := range strs {
+		table.bytes(1, []byte(s))
+	}
+	dense := (&pb{}).
+		packedDelta(1, 1, 2, 3).
+		packedDelta(8, units(40.4), units(40.4), units(40.4)).
+		packedDelta(9, units(-3.7), units(-3.695), units(-3.69))
+	group := (&pb{}).bytes(2, dense.buf)
+	for _, n := range []struct {
+		id       int64
+		lat, lon float64
+	}{{4, 40.405, -3.695}, {5, 40.41, -3.7}} {
+		group.bytes(1, (&pb{}).sint(1, n.id).sint(8, units(n.lat)).sint(9, units(n.lon)).buf)
+	}
+	ways := []struct {
+		id         int64
+		keys, vals []uint64
+		refs       []int64
+	}{
+		{10, []uint64{1}, []uint64{2}, []int64{1, 2, 3}},
+		{11, []uint64{1, 4, 8}, []uint64{3, 5, 9}, []int64{2, 4}},
+		{12, []uint64{1}, []uint64{6}, []int64{1, 5}},
+		{13, []uint64{1}, []uint64{7}, []int64{4, 99}},
+	}
+	for _, w := range ways {
+		group.bytes(3, (&pb{}).varint(1, uint64(w.id)).packed(2, w.keys...).packed(3, w.vals...).packedDelta(8, w.refs...).buf)
+	}
+	block := (&pb{}).bytes(1, table.buf).bytes(2, group.buf)
+
+	file := appendBlob(t, nil, "OSMHeader", header.buf, false)
+	return appendBlob(t, file, "OSMData", block.buf, true)
+}
+
+func TestShouldLoadOSMPBF(t *testing.T) {
+	pbf, err := LoadOSMPBF(bytes.NewReader(testPBF(t)))
+	require.NoError(t, err)
+	xml := loadTestGraph(t)
+
+	// The same graph as the XML extract, but the speed limit of the one way street
+	require.Len(t, pbf.Nodes, len(xml.Nodes))
+	for i, n := range xml.Nodes {
+		assert.Equal(t, n.ID, pbf.Nodes[i].ID)
+		assert.InDelta(t, n.Lat, pbf.Nodes[i].Lat, 1e-9)
+		assert.InDelta(t, n.Lon, pbf.Nodes[i].Lon, 1e-9)
+		require.Len(t, pbf.Edges[i], len(xml.Edges[i]))
+		for j, e := range xml.Edges[i] {
+			assert.Equal(t, e.To, pbf.Edges[i][j].To)
+			assert.Equal(t, e.Highway, pbf.Edges[i][j].Highway)
+		}
+	}
+	two, four := nodeIndex(t, pbf, 2), nodeIndex(t, pbf, 4)
+	edge, ok := pbf.Edge(two, four)
+	require.True(t, ok)
+	assert.Equal(t, 50.0, edge.SpeedKmh)
+	edge, ok = pbf.Edge(two, nodeIndex(t, pbf, 3))
+	require.True(t, ok)
+	assert.Equal(t, 25.0, edge.SpeedKmh)
+}
+
+func TestShouldRejectInvalidPBF(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"unsupported feature", testPBF(t, "HistoricalInformation")},
+		{"truncated", testPBF(t)[:60]},
+		{"huge header", []byte{0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadOSMPBF(bytes.NewReader(tt.file))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestShouldParseMaxSpeed(t *testing.T) {
+	assert.Equal(t, 50.0, parseMaxSpeed("50"))
+	assert.InDelta(t, 48.28, parseMaxSpeed("30 mph"), 0.01)
+	assert.Equal(t, 0.0, parseMaxSpeed("none"))
+	assert.Equal(t, 0.0, parseMaxSpeed(""))
+}
@@ -0,0 +1,102 @@
+package roads
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testExtract is a street going east with a one way street going north from its middle, a footway and a way
+// leaving the extract
+const testExtract = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="1" lat="40.4000" lon="-3.7000"/>
+  <node id="2" lat="40.4000" lon="-3.6950"/>
+  <node id="3" lat="40.4000" lon="-3.6900"/>
+  <node id="4" lat="40.4050" lon="-3.6950"/>
+  <node id="5" lat="40.4100" lon="-3.7000"/>
+  <way id="10">
+    <nd ref="1"/><nd ref="2"/><nd ref="3"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <way id="11">
+    <nd ref="2"/><nd ref="4"/>
+    <tag k="highway" v="tertiary"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+  <way id="12">
+    <nd ref="1"/><nd ref="5"/>
+    <tag k="highway" v="footway"/>
+  </way>
+  <way id="13">
+    <nd ref="4"/><nd ref="99"/>
+    <tag k="highway" v="primary"/>
+  </way>
+  <relation id="20"><member type="way" ref="10" role=""/></relation>
+</osm>`
+
+func loadTestGraph(t *testing.T) *Graph {
+	t.Helper()
+	g, err := LoadOSMXML(strings.NewReader(testExtract))
+	require.NoError(t, err)
+	return g
+}
+
+func nodeIndex(t *testing.T, g *Graph, id int64) int {
+	t.Helper()
+	for i, n := range g.Nodes {
+		if n.ID == id {
+			return i
+		}
+	}
+	t.Fatalf("node %d not in the graph", id)
+	return -1
+}
+
+func TestShouldLoadOSMExtract(t *testing.T) {
+	g := loadTestGraph(t)
+	// The footway and the node out of the extract are left out
+	assert.Len(t, g.Nodes, 4)
+
+	two, four := nodeIndex(t, g, 2), nodeIndex(t, g, 4)
+	var twoToFour, fourToTwo bool
+	for _, e := range g.Edges[two] {
+		twoToFour = twoToFour || e.To == four
+	}
+	for _, e := range g.Edges[four] {
+		fourToTwo = fourToTwo || e.To == two
+	}
+	assert.True(t, twoToFour)
+	assert.False(t, fourToTwo, "one way street driven backwards")
+	assert.Len(t, g.Edges[two], 3)
+	assert.InDelta(t, 0.42, g.Edges[two][0].DistanceKm, 0.01)
+
+	_, err := LoadOSMXML(strings.NewReader(`<osm></osm>`))
+	assert.Error(t, err)
+}
+
+func TestShouldSnapToClosestRoad(t *testing.T) {
+	g := loadTestGraph(t)
+
+	// 20m north of the middle of the first block
+	snap, ok := g.Snap(model.Coordinate{Lat: 40.40018, Lon: -3.6975}, 0.05)
+	require.True(t, ok)
+	assert.InDelta(t, 40.4000, snap.Point.Lat, 1e-6)
+	assert.InDelta(t, -3.6975, snap.Point.Lon, 1e-6)
+	assert.InDelta(t, 0.02, snap.DistanceKm, 0.001)
+	assert.InDelta(t, 0.5, snap.Fraction, 0.01)
+
+	// Next to the one way street
+	snap, ok = g.Snap(model.Coordinate{Lat: 40.4030, Lon: -3.6948}, 0.05)
+	require.True(t, ok)
+	assert.InDelta(t, -3.6950, snap.Point.Lon, 1e-6)
+	assert.Equal(t, nodeIndex(t, g, 2), snap.From)
+	assert.Equal(t, nodeIndex(t, g, 4), snap.To)
+
+	// Far from every road
+	_, ok = g.Snap(model.Coordinate{Lat: 40.4100, Lon: -3.7000}, 0.05)
+	assert.False(t, ok)
+}
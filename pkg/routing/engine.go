@@ -0,0 +1,201 @@
+package routing
+
+import (
+	"container/heap"
+	"math"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/roads"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// DefaultMaxSnapKm is how far from a road the points of a route can be
+const DefaultMaxSnapKm = 0.3
+
+// Engine finds the fastest routes on a road graph with A*, the heuristic is the time to drive the straight
+// line to the destination at the top speed of the graph
+type Engine struct {
+	graph       *roads.Graph
+	maxSnapKm   float64
+	maxSpeedKmh float64
+}
+
+// NewEngine creates an engine on the graph, maxSnapKm defaults to DefaultMaxSnapKm
+func NewEngine(graph *roads.Graph, maxSnapKm float64) *Engine {
+	if maxSnapKm <= 0 {
+		maxSnapKm = DefaultMaxSnapKm
+	}
+	maxSpeed := 0.0
+	for _, edges := range graph.Edges {
+		for _, e := range edges {
+			maxSpeed = math.Max(maxSpeed, e.SpeedKmh)
+		}
+	}
+	return &Engine{graph: graph, maxSnapKm: maxSnapKm, maxSpeedKmh: maxSpeed}
+}
+
+// LoadEngine creates an engine on the roads of an OSM extract, PBF or XML, see roads.LoadOSMFile
+func LoadEngine(path string) (*Engine, error) {
+	graph, err := roads.LoadOSMFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(graph, 0), nil
+}
+
+// Graph returns the road graph of the engine
+func (e *Engine) Graph() *roads.Graph {
+	return e.graph
+}
+
+// access is the piece of road between a snapped point and a node of its segment
+type access struct {
+	node      int
+	hours, km float64
+}
+
+// departures are the nodes that can be reached from the snapped point, following the direction of the road
+func (e *Engine) departures(s roads.Snap) []access {
+	var result []access
+	if edge, ok := e.graph.Edge(s.From, s.To); ok {
+		result = append(result, access{node: s.To, hours: edge.Hours() * (1 - s.Fraction), km: edge.DistanceKm * (1 - s.Fraction)})
+	}
+	if edge, ok := e.graph.Edge(s.To, s.From); ok {
+		result = append(result, access{node: s.From, hours: edge.Hours() * s.Fraction, km: edge.DistanceKm * s.Fraction})
+	}
+	return result
+}
+
+// arrivals are the nodes the snapped point can be reached from
+func (e *Engine) arrivals(s roads.Snap) map[int]access {
+	result := make(map[int]access, 2)
+	if edge, ok := e.graph.Edge(s.From, s.To); ok {
+		result[s.From] = access{node: s.From, hours: edge.Hours() * s.Fraction, km: edge.DistanceKm * s.Fraction}
+	}
+	if edge, ok := e.graph.Edge(s.To, s.From); ok {
+		result[s.To] = access{node: s.To, hours: edge.Hours() * (1 - s.Fraction), km: edge.DistanceKm * (1 - s.Fraction)}
+	}
+	return result
+}
+
+// direct is the drive between two points of the same segment without leaving it, false when the road does
+// not go that way
+func (e *Engine) direct(src, dst roads.Snap) (access, bool) {
+	if src.From != dst.From || src.To != dst.To {
+		return access{}, false
+	}
+	if edge, ok := e.graph.Edge(src.From, src.To); ok && dst.Fraction >= src.Fraction {
+		return access{hours: edge.Hours() * (dst.Fraction - src.Fraction), km: edge.DistanceKm * (dst.Fraction - src.Fraction)}, true
+	}
+	if edge, ok := e.graph.Edge(src.To, src.From); ok && dst.Fraction <= src.Fraction {
+		return access{hours: edge.Hours() * (src.Fraction - dst.Fraction), km: edge.DistanceKm * (src.Fraction - dst.Fraction)}, true
+	}
+	return access{}, false
+}
+
+// Route finds the fastest drive between the points, both are snapped to the closest road
+func (e *Engine) Route(from, to model.Coordinate) (Route, error) {
+	src, ok := e.graph.Snap(from, e.maxSnapKm)
+	if !ok {
+		return Route{}, ErrNoRoute
+	}
+	dst, ok := e.graph.Snap(to, e.maxSnapKm)
+	if !ok {
+		return Route{}, ErrNoRoute
+	}
+
+	heuristic := func(node int) float64 {
+		n := e.graph.Nodes[node]
+		return util.CalculateDistance(n.Lat, n.Lon, dst.Point.Lat, dst.Point.Lon) / e.maxSpeedKmh
+	}
+	hours := make(map[int]float64)
+	kms := make(map[int]float64)
+	prev := make(map[int]int)
+	queue := &nodeQueue{}
+	for _, a := range e.departures(src) {
+		if old, ok := hours[a.node]; ok && old <= a.hours {
+			continue
+		}
+		hours[a.node], kms[a.node], prev[a.node] = a.hours, a.km, -1
+		heap.Push(queue, queuedNode{node: a.node, hours: a.hours, priority: a.hours + heuristic(a.node)})
+	}
+
+	best, bestEnd := math.Inf(1), -1
+	direct, isDirect := e.direct(src, dst)
+	if isDirect {
+		best = direct.hours
+	}
+	ends := e.arrivals(dst)
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(queuedNode)
+		if item.priority >= best {
+			break
+		}
+		if item.hours > hours[item.node] {
+			continue
+		}
+		if end, ok := ends[item.node]; ok && item.hours+end.hours < best {
+			best, bestEnd = item.hours+end.hours, item.node
+		}
+		for _, edge := range e.graph.Edges[item.node] {
+			next := item.hours + edge.Hours()
+			if old, ok := hours[edge.To]; ok && old <= next {
+				continue
+			}
+			hours[edge.To], kms[edge.To], prev[edge.To] = next, kms[item.node]+edge.DistanceKm, item.node
+			heap.Push(queue, queuedNode{node: edge.To, hours: next, priority: next + heuristic(edge.To)})
+		}
+	}
+	if math.IsInf(best, 1) {
+		return Route{}, ErrNoRoute
+	}
+
+	route := Route{Duration: time.Duration(best * float64(time.Hour))}
+	if bestEnd < 0 {
+		route.DistanceKm = direct.km
+		route.Points = []model.Coordinate{src.Point, dst.Point}
+		return route, nil
+	}
+	route.DistanceKm = kms[bestEnd] + ends[bestEnd].km
+	var nodes []int
+	for node := bestEnd; node >= 0; node = prev[node] {
+		nodes = append(nodes, node)
+	}
+	route.Points = append(route.Points, src.Point)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := e.graph.Nodes[nodes[i]]
+		route.Points = appendPoint(route.Points, model.Coordinate{Lat: n.Lat, Lon: n.Lon})
+	}
+	route.Points = appendPoint(route.Points, dst.Point)
+	return route, nil
+}
+
+// appendPoint appends the point unless it repeats the last one, the snapped points may be nodes
+func appendPoint(points []model.Coordinate, p model.Coordinate) []model.Coordinate {
+	if len(points) > 0 && points[len(points)-1] == p {
+		return points
+	}
+	return append(points, p)
+}
+
+// queuedNode is a node reached in hours, priority adds the heuristic to the destination
+type queuedNode struct {
+	node     int
+	hours    float64
+	priority float64
+}
+
+// nodeQueue is a min heap of the nodes by priority
+type nodeQueue []queuedNode
+
+func (q nodeQueue) Len() int            { return len(q) }
+func (q nodeQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q nodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(queuedNode)) }
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
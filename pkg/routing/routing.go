@@ -0,0 +1,49 @@
+// Package routing answers the distance, duration and path of the drives between two points on the road
+// network, loaded from a local OpenStreetMap extract. The callers keep the great-circle distance as fallback
+// for the points out of the network.
+package routing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// ErrNoRoute is returned when the points are not near a road or the roads do not connect them
+var ErrNoRoute = errors.New("no route between the points")
+
+// Route is a drive along the roads
+type Route struct {
+	DistanceKm float64
+	Duration   time.Duration
+	// Points is the polyline of the drive, from the origin to the destination
+	Points []model.Coordinate
+}
+
+// Router finds the fastest drive between two points
+type Router interface {
+	Route(from, to model.Coordinate) (Route, error)
+}
+
+// RouteVia drives through the points in order, the legs are joined in a single route
+func RouteVia(router Router, points []model.Coordinate) (Route, error) {
+	if len(points) < 2 {
+		return Route{}, ErrNoRoute
+	}
+	var total Route
+	for i := 1; i < len(points); i++ {
+		leg, err := router.Route(points[i-1], points[i])
+		if err != nil {
+			return Route{}, err
+		}
+		total.DistanceKm += leg.DistanceKm
+		total.Duration += leg.Duration
+		if len(total.Points) > 0 && len(leg.Points) > 0 {
+			// The leg starts where the previous one ends
+			leg.Points = leg.Points[1:]
+		}
+		total.Points = append(total.Points, leg.Points...)
+	}
+	return total, nil
+}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/roads"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	a = model.Coordinate{Lat: 40.40, Lon: -3.70}
+	b = model.Coordinate{Lat: 40.40, Lon: -3.68}
+	c = model.Coordinate{Lat: 40.41, Lon: -3.70}
+	d = model.Coordinate{Lat: 40.41, Lon: -3.68}
+	// g and h are a road not connected to the rest
+	g = model.Coordinate{Lat: 40.45, Lon: -3.70}
+	h = model.Coordinate{Lat: 40.45, Lon: -3.68}
+)
+
+// testEngine is a slow street from a to b and a fast detour through c and d, one way from c to d
+func testEngine(t *testing.T) *Engine {
+	t.Helper()
+	graph := roads.NewGraph()
+	nodes := make(map[model.Coordinate]int)
+	for i, p := range []model.Coordinate{a, b, c, d, g, h} {
+		nodes[p] = graph.AddNode(roads.Node{ID: int64(i + 1), Lat: p.Lat, Lon: p.Lon})
+	}
+	graph.AddRoad(nodes[a], nodes[b], "living_street", false)
+	graph.AddRoad(nodes[a], nodes[c], "primary", false)
+	graph.AddRoadWithSpeed(nodes[c], nodes[d], "trunk", 80, true)
+	graph.AddRoad(nodes[d], nodes[b], "primary", false)
+	graph.AddRoad(nodes[g], nodes[h], "residential", false)
+	return NewEngine(graph, 0.1)
+}
+
+func km(p, q model.Coordinate) float64 {
+	return util.CalculateDistance(p.Lat, p.Lon, q.Lat, q.Lon)
+}
+
+// along returns the point at the fraction of the way from p to q
+func along(p, q model.Coordinate, fraction float64) model.Coordinate {
+	return model.Coordinate{Lat: p.Lat + (q.Lat-p.Lat)*fraction, Lon: p.Lon + (q.Lon-p.Lon)*fraction}
+}
+
+func TestShouldTakeTheFastestRoute(t *testing.T) {
+	engine := testEngine(t)
+
+	// The detour is longer but faster than the living street
+	route, err := engine.Route(a, b)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Coordinate{a, c, d, b}, route.Points)
+	assert.InDelta(t, km(a, c)+km(c, d)+km(d, b), route.DistanceKm, 0.001)
+	assert.InDelta(t, km(a, c)/50+km(c, d)/80+km(d, b)/50, route.Duration.Hours(), 1e-6)
+
+	// The one way street can not be driven back
+	route, err = engine.Route(b, a)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Coordinate{b, a}, route.Points)
+	assert.InDelta(t, km(a, b)/10, route.Duration.Hours(), 1e-6)
+}
+
+func TestShouldRouteWithinSegment(t *testing.T) {
+	engine := testEngine(t)
+	from, to := along(a, b, 0.25), along(a, b, 0.75)
+
+	route, err := engine.Route(from, to)
+	require.NoError(t, err)
+	assert.Len(t, route.Points, 2)
+	assert.InDelta(t, km(a, b)/2, route.DistanceKm, 0.001)
+
+	// From the middle of the street the detour starts at a
+	route, err = engine.Route(from, d)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Coordinate{a, c, d}, route.Points[1:])
+	assert.InDelta(t, km(a, b)/4+km(a, c)+km(c, d), route.DistanceKm, 0.001)
+}
+
+func TestShouldNotRouteOutOfTheRoads(t *testing.T) {
+	engine := testEngine(t)
+
+	_, err := engine.Route(a, g)
+	assert.ErrorIs(t, err, ErrNoRoute)
+	_, err = engine.Route(a, model.Coordinate{Lat: 41, Lon: -3.7})
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestShouldRouteViaStops(t *testing.T) {
+	engine := testEngine(t)
+
+	route, err := RouteVia(engine, []model.Coordinate{b, a, c})
+	require.NoError(t, err)
+	assert.Equal(t, []model.Coordinate{b, a, c}, route.Points)
+	assert.InDelta(t, km(a, b)+km(a, c), route.DistanceKm, 0.001)
+
+	_, err = RouteVia(engine, []model.Coordinate{a})
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
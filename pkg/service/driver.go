@@ -2,11 +2,14 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type DriverCruder interface {
@@ -15,6 +18,9 @@ type DriverCruder interface {
 	GetDriver(ctx context.Context, id int) (*model.Driver, error)
 	UpdateDriver(ctx context.Context, driver *model.Driver) error
 	DeleteDriver(ctx context.Context, id int) error
+	SetDriverPassword(ctx context.Context, id int, password string) error
+	AuthenticateDriver(ctx context.Context, email, password string) (*model.Driver, error)
+	DriverWatcher
 }
 
 type DriverDatabase struct {
@@ -37,6 +43,14 @@ func NewDatabase(ctx context.Context, connectionString string, table string) (*D
 		return nil, err
 	}
 
+	if err := db.createVehiclesTable(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := db.createTrailsTable(ctx); err != nil {
+		return nil, err
+	}
+
 	log.Println("Database connection established")
 	return db, nil
 }
@@ -49,9 +63,16 @@ func (db *DriverDatabase) createTable(ctx context.Context) error {
         email VARCHAR(100) UNIQUE,
         license_number VARCHAR(50),
         region VARCHAR(100),
-		status VARCHAR(50)
+		status VARCHAR(50),
+		password_hash VARCHAR(100)
     );`, db.Table)
 	_, err := db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	// Tables created before drivers had credentials
+	query = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS password_hash VARCHAR(100);`, db.Table)
+	_, err = db.Pool.Exec(ctx, query)
 	return err
 }
 
@@ -120,17 +141,88 @@ func (db *DriverDatabase) UpdateDriver(ctx context.Context, driver *model.Driver
 			id = $6`,
 		db.Table)
 	_, err := db.Pool.Exec(ctx, query, driver.Name, driver.Email, driver.LicenseNumber, driver.Region, driver.Status, driver.ID)
-	return err
+	if err != nil {
+		return err
+	}
+	db.notifyDriverChange(ctx, model.DriverChangeEvent{
+		Kind:     model.DriverChangeUpdated,
+		DriverID: driver.ID,
+		Status:   driver.Status,
+		Region:   driver.Region,
+	})
+	return nil
 }
 
 func (db *DriverDatabase) DeleteDriver(ctx context.Context, id int) error {
 	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, db.Table)
 	_, err := db.Pool.Exec(ctx, query, id)
-	return err
+	if err != nil {
+		return err
+	}
+	db.notifyDriverChange(ctx, model.DriverChangeEvent{Kind: model.DriverChangeDeleted, DriverID: id})
+	return nil
 }
 
 func (db *DriverDatabase) DeleteAllDrivers(ctx context.Context) error {
-	query := fmt.Sprintf(`DELETE FROM %s`, db.Table)
+	query := fmt.Sprintf(`DELETE FROM %s`, db.vehiclesTable())
 	_, err := db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	query = fmt.Sprintf(`DELETE FROM %s`, db.trailsTable())
+	_, err = db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	query = fmt.Sprintf(`DELETE FROM %s`, db.Table)
+	_, err = db.Pool.Exec(ctx, query)
 	return err
 }
+
+// SetDriverPassword stores a bcrypt hash of the driver password
+func (db *DriverDatabase) SetDriverPassword(ctx context.Context, id int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE %s SET password_hash = $1 WHERE id = $2`, db.Table)
+	tag, err := db.Pool.Exec(ctx, query, string(hash), id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("driver %d not found", id)
+	}
+	return nil
+}
+
+// AuthenticateDriver returns the driver when the password matches the stored hash
+func (db *DriverDatabase) AuthenticateDriver(ctx context.Context, email, password string) (*model.Driver, error) {
+	var driver model.Driver
+	var hash string
+	query := fmt.Sprintf(`
+			SELECT
+				id, name, email, license_number, region, status, COALESCE(password_hash, '')
+			FROM
+				%s
+			WHERE email = $1`,
+		db.Table)
+	err := db.Pool.QueryRow(ctx, query, email).Scan(
+		&driver.ID,
+		&driver.Name,
+		&driver.Email,
+		&driver.LicenseNumber,
+		&driver.Region,
+		&driver.Status,
+		&hash)
+	// Unknown emails are compared with the dummy hash so they take as long as the known ones
+	if errors.Is(err, pgx.ErrNoRows) {
+		hash = ""
+	} else if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(hash, password); err != nil {
+		return nil, err
+	}
+	return &driver, nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
+)
+
+// driverChangesRetryInterval is the time to wait before listening again after losing the connection
+const driverChangesRetryInterval = time.Second
+
+// DriverWatcher streams the changes of the drivers, every instance of a service gets all the changes
+type DriverWatcher interface {
+	WatchDriverChanges(ctx context.Context) (<-chan model.DriverChangeEvent, error)
+}
+
+// changesChannel is the Postgres channel where the driver changes are notified
+func (db *DriverDatabase) changesChannel() string {
+	return db.Table + "_changes"
+}
+
+// notifyDriverChange sends the change to the listeners of the drivers channel. The change is already stored,
+// so a failed notification is logged and not returned.
+func (db *DriverDatabase) notifyDriverChange(ctx context.Context, event model.DriverChangeEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println("marshal driver change:", err)
+		return
+	}
+	_, err = db.Pool.Exec(ctx, "SELECT pg_notify($1, $2)", db.changesChannel(), string(payload))
+	if err != nil {
+		log.Printf("error notifying change of driver %d: %v\n", event.DriverID, err)
+	}
+}
+
+// WatchDriverChanges listens to the driver changes until the context is done. The connection is restored when
+// lost, the changes notified meanwhile are missed so a DriverChangeResync event is sent once listening again.
+func (db *DriverDatabase) WatchDriverChanges(ctx context.Context) (<-chan model.DriverChangeEvent, error) {
+	conn, err := db.listenDriverChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan model.DriverChangeEvent, 64)
+	go func() {
+		defer close(events)
+		for {
+			if conn == nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(driverChangesRetryInterval):
+				}
+				if conn, err = db.listenDriverChanges(ctx); err != nil {
+					log.Println("listen driver changes:", err)
+					conn = nil
+					continue
+				}
+				select {
+				case events <- model.DriverChangeEvent{Kind: model.DriverChangeResync}:
+				case <-ctx.Done():
+					conn.Close(context.Background())
+					return
+				}
+			}
+
+			notification, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				conn.Close(context.Background())
+				conn = nil
+				if ctx.Err() != nil {
+					return
+				}
+				log.Println("wait driver changes:", err)
+				continue
+			}
+
+			var event model.DriverChangeEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				log.Println("unmarshal driver change:", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				conn.Close(context.Background())
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// listenDriverChanges takes a connection out of the pool and listens to the changes on it, the connection
+// never goes back to the pool so the other queries don't get the notifications
+func (db *DriverDatabase) listenDriverChanges(ctx context.Context) (*pgx.Conn, error) {
+	pooled, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn := pooled.Hijack()
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{db.changesChannel()}.Sanitize())
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
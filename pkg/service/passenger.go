@@ -2,17 +2,43 @@ package service
 
 import (
 	"context"
+	"errors"
 
 	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned when the email does not exist or the password does not match, both
+// cases return the same error so the login can't be used to find out the registered emails
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// unknownAccountHash is compared when the account does not exist or has no password, so the unknown emails
+// take as long to reject as the wrong passwords
+const unknownAccountHash = "$2a$10$sWZlDaQgzSb5w1LhnoBSsu9Hs5qD8wHlJD7lvlVO5zwEwjaHHfhW6"
+
+// checkPassword compares the password against the hash, an empty hash is compared against unknownAccountHash
+// and never matches
+func checkPassword(hash, password string) error {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword([]byte(unknownAccountHash), []byte(password))
+		return ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
 type PassengerCruder interface {
 	CreatePassenger(ctx context.Context, passenger *model.Passenger) error
 	ListPassengers(ctx context.Context) ([]model.Passenger, error)
 	GetPassenger(ctx context.Context, id int) (*model.Passenger, error)
 	UpdatePassenger(ctx context.Context, passenger *model.Passenger) error
 	DeletePassenger(ctx context.Context, id int) error
+	SignupPassenger(ctx context.Context, passenger *model.Passenger, password string) error
+	AuthenticatePassenger(ctx context.Context, email, password string) (*model.Passenger, error)
 }
 
 type PassengerDatabase struct {
@@ -42,9 +68,15 @@ func (db *PassengerDatabase) createTable(ctx context.Context) error {
 	query := `CREATE TABLE IF NOT EXISTS passengers (
 		id SERIAL PRIMARY KEY,
 		name VARCHAR(100),
-		email VARCHAR(100) UNIQUE
+		email VARCHAR(100) UNIQUE,
+		password_hash VARCHAR(100)
 	);`
 	_, err := db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	// Tables created before passengers had credentials
+	_, err = db.Pool.Exec(ctx, `ALTER TABLE passengers ADD COLUMN IF NOT EXISTS password_hash VARCHAR(100);`)
 	return err
 }
 
@@ -109,3 +141,31 @@ func (db *PassengerDatabase) DeleteAllPassengers(ctx context.Context) error {
 	_, err := db.Pool.Exec(ctx, query)
 	return err
 }
+
+// SignupPassenger creates a passenger with a bcrypt hash of the password
+func (db *PassengerDatabase) SignupPassenger(ctx context.Context, passenger *model.Passenger, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO passengers (name, email, password_hash) VALUES ($1, $2, $3) RETURNING id;`
+	return db.Pool.QueryRow(ctx, query, passenger.Name, passenger.Email, string(hash)).Scan(&passenger.ID)
+}
+
+// AuthenticatePassenger returns the passenger when the password matches the stored hash
+func (db *PassengerDatabase) AuthenticatePassenger(ctx context.Context, email, password string) (*model.Passenger, error) {
+	query := `SELECT id, name, email, COALESCE(password_hash, '') FROM passengers WHERE email = $1;`
+	var passenger model.Passenger
+	var hash string
+	err := db.Pool.QueryRow(ctx, query, email).Scan(&passenger.ID, &passenger.Name, &passenger.Email, &hash)
+	// Unknown emails are compared with the dummy hash so they take as long as the known ones
+	if errors.Is(err, pgx.ErrNoRows) {
+		hash = ""
+	} else if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(hash, password); err != nil {
+		return nil, err
+	}
+	return &passenger, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// pickupPINKeyPrefix prefixes the keys holding the clear PIN of every ride
+	pickupPINKeyPrefix = "pickup_pin:"
+	// DefaultPickupPINTTL outlasts any pickup, a PIN left behind by a ride that never started expires with it
+	DefaultPickupPINTTL = 4 * time.Hour
+)
+
+// PickupPINStore keeps the clear pickup PINs until the passenger is on board, so they are read by the
+// passenger service and never written to Postgres nor to the ride events
+// SavePickupPIN stores the PIN of the ride, replacing the previous one
+// PickupPIN returns the PIN of the ride, empty when the ride has none
+// DeletePickupPIN removes the PIN of the ride, it does nothing when the ride has none
+type PickupPINStore interface {
+	SavePickupPIN(ctx context.Context, rideID int, pin string) error
+	PickupPIN(ctx context.Context, rideID int) (string, error)
+	DeletePickupPIN(ctx context.Context, rideID int) error
+}
+
+// RedisPickupPINStore keeps the PINs in Redis with a TTL, so the PINs of rides that never started are removed
+// by Redis
+type RedisPickupPINStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+// NewRedisPickupPINStore creates a new RedisPickupPINStore
+func NewRedisPickupPINStore(redisAddr string, ttl time.Duration) *RedisPickupPINStore {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	return &RedisPickupPINStore{redisClient: rdb, ttl: ttl}
+}
+
+func pickupPINKey(rideID int) string {
+	return pickupPINKeyPrefix + strconv.Itoa(rideID)
+}
+
+// SavePickupPIN stores the PIN of the ride for the TTL of the store
+func (s *RedisPickupPINStore) SavePickupPIN(ctx context.Context, rideID int, pin string) error {
+	return s.redisClient.Set(ctx, pickupPINKey(rideID), pin, s.ttl).Err()
+}
+
+// PickupPIN returns the PIN of the ride, empty when it expired or the ride has none
+func (s *RedisPickupPINStore) PickupPIN(ctx context.Context, rideID int) (string, error) {
+	pin, err := s.redisClient.Get(ctx, pickupPINKey(rideID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return pin, err
+}
+
+// DeletePickupPIN removes the PIN of the ride
+func (s *RedisPickupPINStore) DeletePickupPIN(ctx context.Context, rideID int) error {
+	return s.redisClient.Del(ctx, pickupPINKey(rideID)).Err()
+}
@@ -2,10 +2,19 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/OscarMoya/Glubber/pkg/billing"
 	"github.com/OscarMoya/Glubber/pkg/model"
@@ -15,10 +24,32 @@ import (
 	"github.com/lib/pq"
 )
 
+const (
+	// defaultMaxPickupPINAttempts is the number of wrong PINs a driver can enter before the ride
+	// can no longer be started with a PIN
+	defaultMaxPickupPINAttempts = 3
+	// pickupPINDigits is the length of the PIN shown by the passenger to the driver
+	pickupPINDigits = 4
+)
+
+var (
+	// ErrInvalidRideStatus is returned when a transition is requested from a status that does not allow it
+	ErrInvalidRideStatus = errors.New("invalid ride status for this operation")
+	// ErrInvalidPickupPIN is returned when the PIN provided by the driver does not match
+	ErrInvalidPickupPIN = errors.New("invalid pickup PIN")
+	// ErrPickupPINLocked is returned when the maximum number of PIN attempts has been reached
+	ErrPickupPINLocked = errors.New("too many pickup PIN attempts")
+	// ErrNoPickupPINStore is returned by NewRideService when the PIN is required and there is nowhere to
+	// keep it for the passenger
+	ErrNoPickupPINStore = errors.New("pickup PIN required without a PIN store")
+)
+
 type RideCruder interface {
 	EstimateRide(ctx context.Context, ride *model.Ride) error
 	AcceptRide(ctx context.Context, ride *model.Ride) error
 	DriverAccept(ctx context.Context, ride *model.Ride) error
+	AcceptRideOffer(ctx context.Context, rideID, driverID int) (*model.Ride, error)
+	JoinPool(ctx context.Context, ride *model.Ride, pool []*model.Ride, driverID int) error
 	DriverArrived(ctx context.Context, ride *model.Ride) error
 	CompleteRide(ctx context.Context, ride *model.Ride) error
 	CancelRide(ctx context.Context, ride *model.Ride) error
@@ -26,9 +57,21 @@ type RideCruder interface {
 
 	CreateRide(ctx context.Context, ride *model.Ride) error
 	ListRides(ctx context.Context) ([]model.Ride, error)
+	ListPassengerRides(ctx context.Context, passengerID int) ([]model.Ride, error)
+	ListDriverRides(ctx context.Context, driverID int) ([]model.Ride, error)
+	ListActiveRides(ctx context.Context) ([]model.Ride, error)
 	GetRide(ctx context.Context, id int) (*model.Ride, error)
 	UpdateRide(ctx context.Context, ride *model.Ride) error
+	UpdateRideRoute(ctx context.Context, ride *model.Ride, expected model.RideStatus) error
 	DeleteRide(ctx context.Context, id int) error
+	StartRide(ctx context.Context, ride *model.Ride, pin string) error
+
+	ScheduleRide(ctx context.Context, ride *model.Ride) error
+	ListScheduledRides(ctx context.Context, passengerID int) ([]model.Ride, error)
+	CancelScheduledRide(ctx context.Context, id int) error
+
+	StopArrived(ctx context.Context, rideID, seq int) error
+	StopDeparted(ctx context.Context, rideID, seq int) error
 }
 
 type RideServiceOpts struct {
@@ -38,21 +81,53 @@ type RideServiceOpts struct {
 	Table       string
 	DriverTopic string
 	DriverKey   string
+	// PassengerTopic receives every change of the rides keyed by passenger, so the passenger service can
+	// push live updates. Events are not published when it is empty
+	PassengerTopic string
+	// RequirePickupPIN makes StartRide verify the PIN generated when the driver is matched, the clear PIN is
+	// kept in PickupPINs for the passenger
+	RequirePickupPIN bool
+	PickupPINs       PickupPINStore
+	// MaxPickupPINAttempts bounds the wrong PINs accepted per ride, defaults to defaultMaxPickupPINAttempts
+	MaxPickupPINAttempts int
+	// SchedulerInterval is how often Postgres is polled for scheduled rides that are due, defaults to
+	// defaultSchedulerInterval
+	SchedulerInterval time.Duration
+	// ScheduleLeadTime is how long before the pickup time a scheduled ride is pushed to dispatch,
+	// defaults to defaultScheduleLeadTime
+	ScheduleLeadTime time.Duration
 }
 
 type RideService struct {
 	RideServiceOpts
 	outboxTable   string
+	stopsTable    string
+	zonesTable    string
 	notifyChannel string
 }
 
 // NewRideService creates a new RideDatabase
 func NewRideService(ctx context.Context, opts RideServiceOpts) (*RideService, error) {
 
+	if opts.RequirePickupPIN && opts.PickupPINs == nil {
+		return nil, ErrNoPickupPINStore
+	}
+	if opts.MaxPickupPINAttempts <= 0 {
+		opts.MaxPickupPINAttempts = defaultMaxPickupPINAttempts
+	}
+	if opts.SchedulerInterval <= 0 {
+		opts.SchedulerInterval = defaultSchedulerInterval
+	}
+	if opts.ScheduleLeadTime <= 0 {
+		opts.ScheduleLeadTime = defaultScheduleLeadTime
+	}
+
 	svc := &RideService{
 
 		RideServiceOpts: opts,
 		outboxTable:     opts.Table + "_outbox",
+		stopsTable:      opts.Table + "_stops",
+		zonesTable:      opts.Table + "_zones",
 		notifyChannel:   opts.Table + "_events",
 	}
 
@@ -93,8 +168,30 @@ func (svc *RideService) processNotification(ctx context.Context, notification *p
 	if outbox == nil {
 		return fmt.Errorf("outbox not found")
 	}
-	switch outbox.Status {
-	case model.RideStatusPassengerAccepted:
+	// Deleted rides can't be loaded anymore, the rest of the events carry the ride
+	if outbox.Status != model.RideStatusDeleted {
+		ride, err := svc.GetRide(ctx, outbox.RideID)
+		if err != nil {
+			return err
+		}
+		outbox.Ride = ride
+	}
+
+	if svc.PassengerTopic != "" && outbox.Ride != nil {
+		outboxBytes, err := json.Marshal(outbox)
+		if err != nil {
+			return err
+		}
+		err = svc.Producer.SendMessage(ctx, svc.PassengerTopic, strconv.Itoa(outbox.Ride.PassengerID), outboxBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	// The offer carries the ride so the drivers see the full route including the stops, the changes of the
+	// rides with a driver reach the driver through the same topic
+	case outbox.Status == model.RideStatusPassengerAccepted, outbox.Ride != nil && outbox.Ride.DriverID != nil:
 		outboxBytes, err := json.Marshal(outbox)
 		if err != nil {
 			return err
@@ -119,13 +216,47 @@ func (svc *RideService) createTables(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// The rides tables created before a column was added get it, the rides are scanned with every column
+	query, err = util.BuildSQLAddColumnsQuery(svc.Table, model.Ride{})
+	if err != nil {
+		return err
+	}
+	err = svc.Repository.CreateTable(ctx, query)
+	if err != nil {
+		return err
+	}
 
 	query, err = util.BuildSQLCreateTableQuery(svc.outboxTable, model.RideOutbox{})
 	if err != nil {
 		return err
 	}
 	err = svc.Repository.CreateTable(ctx, query)
-	return err
+	if err != nil {
+		return err
+	}
+	// The clear PINs were kept in the outbox before PickupPINStore, the column is dropped with them
+	query = fmt.Sprintf(`ALTER TABLE %s DROP COLUMN IF EXISTS pickup_pin;`, svc.outboxTable)
+	err = svc.Repository.CreateTable(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query, err = util.BuildSQLCreateTableQuery(svc.stopsTable, model.RideStop{})
+	if err != nil {
+		return err
+	}
+	err = svc.Repository.CreateTable(ctx, query)
+	if err != nil {
+		return err
+	}
+	// A stop is marked by its sequence number, it can't be shared by two stops of a ride
+	query = fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s_ride_seq ON %s (ride_id, seq);`, svc.stopsTable, svc.stopsTable)
+	err = svc.Repository.CreateTable(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return svc.createZonesTable(ctx)
 }
 
 func (svc *RideService) Close() {
@@ -138,6 +269,7 @@ func (svc *RideService) Start(ctx context.Context) error {
 		return err
 	}
 	go svc.processNotificationsWorker(ctx)
+	go svc.schedulerWorker(ctx)
 	return nil
 }
 
@@ -147,39 +279,200 @@ func (svc *RideService) EstimateRide(ctx context.Context, ride *model.Ride) erro
 	return svc.CreateRide(ctx, ride)
 }
 
+// AcceptRide moves a ride the passenger got a quote for to passenger_accepted, the event offers it to the drivers
 func (svc *RideService) AcceptRide(ctx context.Context, ride *model.Ride) error {
-	ride.Status = model.RideStatusPassengerAccepted
-	// After this update, the notification will be sent to the driver
-	return svc.UpdateRide(ctx, ride)
+	return svc.transitionRide(ctx, ride, model.RideStatusPassengerAccepted, model.RideStatusPending)
 }
 
 func (svc *RideService) DriverAccept(ctx context.Context, ride *model.Ride) error {
 	ride.Status = model.RideStatusDriverAccepted
+	if err := svc.newPickupPIN(ride); err != nil {
+		return err
+	}
+	if err := svc.savePickupPIN(ctx, ride); err != nil {
+		return err
+	}
 	// After this update, we need to notify the passengers that the driver has accepted the ride
 	return svc.UpdateRide(ctx, ride)
 }
 
+// DriverArrived moves a ride to picking_up when its driver reaches the pickup, the passenger is told
 func (svc *RideService) DriverArrived(ctx context.Context, ride *model.Ride) error {
-	ride.Status = model.RideStatusPickingUp
-	// After this update, we need to notify the passengers that the driver has arrived
-	return svc.UpdateRide(ctx, ride)
+	return svc.transitionRide(ctx, ride, model.RideStatusPickingUp, model.RideStatusDriverAccepted)
+}
+
+// StartRide moves a ride from picking_up to in_transit. When the service requires a pickup PIN, the
+// PIN provided by the driver is verified against the stored hash and every failure is accounted in
+// Postgres, once the attempts are exhausted the ride can't be started with a PIN anymore.
+func (svc *RideService) StartRide(ctx context.Context, ride *model.Ride, pin string) error {
+	if ride.Status != model.RideStatusPickingUp {
+		return ErrInvalidRideStatus
+	}
+
+	if svc.RequirePickupPIN && ride.PickupPINHash != "" {
+		if ride.PickupPINAttempts >= svc.MaxPickupPINAttempts {
+			return ErrPickupPINLocked
+		}
+		expected := []byte(ride.PickupPINHash)
+		got := []byte(hashPickupPIN(ride.ID, pin))
+		if subtle.ConstantTimeCompare(expected, got) != 1 {
+			attempts, err := svc.countPickupPINFailure(ctx, ride.ID)
+			if err != nil {
+				return err
+			}
+			ride.PickupPINAttempts = attempts
+			if attempts >= svc.MaxPickupPINAttempts {
+				return ErrPickupPINLocked
+			}
+			return ErrInvalidPickupPIN
+		}
+	}
+
+	// After this update, we need to notify the passenger that the ride has started
+	if err := svc.startRide(ctx, ride); err != nil {
+		return err
+	}
+	svc.forgetPickupPIN(ctx, ride.ID)
+	return nil
+}
+
+// countPickupPINFailure adds a failed attempt to the ride and returns the attempts, the increment is atomic
+// so concurrent guesses can't go over MaxPickupPINAttempts. It returns ErrPickupPINLocked when no attempt
+// is left.
+func (svc *RideService) countPickupPINFailure(ctx context.Context, rideID int) (int, error) {
+	query := fmt.Sprintf(`
+		UPDATE %s SET pickup_pin_attempts = pickup_pin_attempts + 1
+		WHERE id = $1 AND pickup_pin_attempts < $2 RETURNING pickup_pin_attempts;`, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var attempts int
+	err = tx.QueryRow(ctx, query, rideID, svc.MaxPickupPINAttempts).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback(ctx)
+		return 0, ErrPickupPINLocked
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+	return attempts, tx.Commit(ctx)
+}
+
+// startRide moves the ride to in_transit if it is still picking up and has attempts left, the attempts are
+// not written from the ride so the failures counted meanwhile are kept
+func (svc *RideService) startRide(ctx context.Context, ride *model.Ride) error {
+	query := fmt.Sprintf(`
+		UPDATE %s SET status = $1
+		WHERE id = $2 AND status = $3 AND pickup_pin_attempts < $4;`, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, query, model.RideStatusInTransit, ride.ID, model.RideStatusPickingUp, svc.MaxPickupPINAttempts)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		current, err := svc.GetRide(ctx, ride.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != model.RideStatusPickingUp {
+			return ErrInvalidRideStatus
+		}
+		return ErrPickupPINLocked
+	}
+	ride.Status = model.RideStatusInTransit
+	err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(ride))
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
+// CompleteRide moves a ride in transit to passenger_dropped once the passenger leaves the vehicle
 func (svc *RideService) CompleteRide(ctx context.Context, ride *model.Ride) error {
-	ride.Status = model.RideStatusCompleted
-	return svc.UpdateRide(ctx, ride)
+	return svc.transitionRide(ctx, ride, model.RideStatusCompleted, model.RideStatusInTransit)
 }
 
+// CancelRide cancels a ride on behalf of the passenger, rides already in transit or ended return
+// ErrInvalidRideStatus
 func (svc *RideService) CancelRide(ctx context.Context, ride *model.Ride) error {
-	ride.Status = model.RideStatusPassengerCancelled
-	// After this update, we need to notify the driver that the passenger has cancelled the ride
-	return svc.UpdateRide(ctx, ride)
+	err := svc.transitionRide(ctx, ride, model.RideStatusPassengerCancelled, model.CancellableRideStatuses...)
+	if err != nil {
+		return err
+	}
+	svc.forgetPickupPIN(ctx, ride.ID)
+	return nil
+}
+
+// transitionRide moves the ride to the status to if it is still in one of the statuses from, the rest of the
+// ride is not written so the changes made meanwhile are kept. The event of the new status goes through the
+// outbox, ErrInvalidRideStatus is returned when the ride is in another status.
+func (svc *RideService) transitionRide(ctx context.Context, ride *model.Ride, to model.RideStatus, from ...model.RideStatus) error {
+	placeholders := make([]string, len(from))
+	args := []interface{}{to, ride.ID}
+	for i, status := range from {
+		placeholders[i] = fmt.Sprintf("$%d", i+3)
+		args = append(args, status)
+	}
+	query := fmt.Sprintf(`UPDATE %s SET status = $1 WHERE id = $2 AND status IN (%s);`, svc.Table, strings.Join(placeholders, ", "))
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		return ErrInvalidRideStatus
+	}
+	ride.Status = to
+	err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(ride))
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (svc *RideService) RideError(ctx context.Context, ride *model.Ride) error {
 	ride.Status = model.RideStatusErrored
 	// After this update, we need to notify the passenger and the Driver that there was an error
-	return svc.UpdateRide(ctx, ride)
+	if err := svc.UpdateRide(ctx, ride); err != nil {
+		return err
+	}
+	svc.forgetPickupPIN(ctx, ride.ID)
+	return nil
+}
+
+// forgetPickupPIN removes the clear PIN of a ride that does not need it anymore, a failure only leaves the PIN
+// until its TTL expires
+func (svc *RideService) forgetPickupPIN(ctx context.Context, rideID int) {
+	if svc.PickupPINs == nil {
+		return
+	}
+	if err := svc.PickupPINs.DeletePickupPIN(ctx, rideID); err != nil {
+		log.Printf("delete pickup PIN of ride %d: %v\n", rideID, err)
+	}
 }
 
 func (svc *RideService) CreateRide(ctx context.Context, ride *model.Ride) error {
@@ -194,6 +487,11 @@ func (svc *RideService) CreateRide(ctx context.Context, ride *model.Ride) error
 		tx.Rollback(ctx)
 		return err
 	}
+	err = svc.insertRideStops(ctx, tx, ride)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
 	outbox := model.NewRideOutbox(ride)
 	fields, placeholder, args, _ = util.BuildSQLInsertQuery(outbox, 1)
 	query = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING id;`, svc.outboxTable, fields, placeholder)
@@ -242,6 +540,20 @@ func (svc *RideService) ListRides(ctx context.Context) ([]model.Ride, error) {
 	return rides, nil
 }
 
+// ListPassengerRides returns the rides requested by a passenger
+func (svc *RideService) ListPassengerRides(ctx context.Context, passengerID int) ([]model.Ride, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE passenger_id = $1 ORDER BY id;`, fields, svc.Table)
+	return svc.queryRides(ctx, query, passengerID)
+}
+
+// ListDriverRides returns the rides assigned to a driver
+func (svc *RideService) ListDriverRides(ctx context.Context, driverID int) ([]model.Ride, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE driver_id = $1 ORDER BY id;`, fields, svc.Table)
+	return svc.queryRides(ctx, query, driverID)
+}
+
 func (svc *RideService) GetRide(ctx context.Context, id int) (*model.Ride, error) {
 	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
 	// fields = "id, " + fields
@@ -258,6 +570,11 @@ func (svc *RideService) GetRide(ctx context.Context, id int) (*model.Ride, error
 		tx.Rollback(ctx)
 		return nil, err
 	}
+	ride.Stops, err = svc.getRideStops(ctx, tx, ride.ID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
 
 	tx.Commit(ctx)
 	return ride, nil
@@ -294,6 +611,40 @@ func (svc *RideService) UpdateRide(ctx context.Context, ride *model.Ride) error
 	return tx.Commit(ctx)
 }
 
+// UpdateRideRoute stores the route, the vehicle and the price of a ride still in the status it was read in, the
+// rest of the ride is not written. The passenger and the matched driver get a RideEventUpdated event, the ride
+// is not offered again. ErrInvalidRideStatus is returned when the status changed meanwhile.
+func (svc *RideService) UpdateRideRoute(ctx context.Context, ride *model.Ride, expected model.RideStatus) error {
+	query := fmt.Sprintf(`
+		UPDATE %s SET src_lat = $1, src_lon = $2, dst_lat = $3, dst_lon = $4, vehicle_class = $5, seats = $6, price = $7
+		WHERE id = $8 AND status = $9;`, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, query, ride.SrcLat, ride.SrcLon, ride.DstLat, ride.DstLon, ride.VehicleClass, ride.Seats,
+		ride.Price, ride.ID, expected)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		return ErrInvalidRideStatus
+	}
+	err = svc.insertOutbox(ctx, tx, &model.RideOutbox{RideID: ride.ID, Status: model.RideEventUpdated})
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func (svc *RideService) DeleteRide(ctx context.Context, id int) error {
 	query := fmt.Sprintf(`DELETE FROM %s WHERE id = %d;`, svc.Table, id)
 	tx, err := svc.Repository.BeginTransaction(ctx)
@@ -305,6 +656,12 @@ func (svc *RideService) DeleteRide(ctx context.Context, id int) error {
 		tx.Rollback(ctx)
 		return err
 	}
+	query = fmt.Sprintf(`DELETE FROM %s WHERE ride_id = %d;`, svc.stopsTable, id)
+	_, err = tx.Exec(ctx, query)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
 	outbox := &model.RideOutbox{RideID: id, Status: model.RideStatusDeleted}
 	fields, placeholder, args, _ := util.BuildSQLInsertQuery(outbox, 1)
 	query = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING id;`, svc.outboxTable, fields, placeholder)
@@ -341,6 +698,18 @@ func (svc *RideService) DeleteAllRides(ctx context.Context) error {
 		tx.Rollback(ctx)
 		return err
 	}
+	query = fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, svc.stopsTable)
+	_, err = tx.Exec(ctx, query)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	query = fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, svc.zonesTable)
+	_, err = tx.Exec(ctx, query)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
 	err = tx.Commit(ctx)
 	log.Println("Deleted all rides ERR", err)
 	return err
@@ -378,3 +747,47 @@ func (svc *RideService) deleteOutbox(ctx context.Context, id int) error {
 	}
 	return tx.Commit(ctx)
 }
+
+// newPickupPIN generates the PIN of a ride matched with a driver and sets its hash in the ride, the attempts
+// start again. It does nothing when the service does not require a PIN.
+func (svc *RideService) newPickupPIN(ride *model.Ride) error {
+	if !svc.RequirePickupPIN {
+		return nil
+	}
+	pin, err := generatePickupPIN()
+	if err != nil {
+		return err
+	}
+	ride.PickupPIN = pin
+	ride.PickupPINHash = hashPickupPIN(ride.ID, pin)
+	ride.PickupPINAttempts = 0
+	return nil
+}
+
+// savePickupPIN keeps the clear PIN of the ride for the passenger, once the ride is assigned to the driver
+func (svc *RideService) savePickupPIN(ctx context.Context, ride *model.Ride) error {
+	if !svc.RequirePickupPIN {
+		return nil
+	}
+	return svc.PickupPINs.SavePickupPIN(ctx, ride.ID, ride.PickupPIN)
+}
+
+// generatePickupPIN returns a random numeric PIN of pickupPINDigits digits, drawn uniformly
+func generatePickupPIN() (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < pickupPINDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", pickupPINDigits, n), nil
+}
+
+// hashPickupPIN hashes the PIN salted with the ride ID so the same PIN does not produce the same hash
+// across rides
+func hashPickupPIN(rideID int, pin string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", rideID, pin)))
+	return hex.EncodeToString(sum[:])
+}
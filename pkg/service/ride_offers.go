@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// ErrRideNotOffered is returned when a driver accepts a ride that is not waiting for a driver anymore
+var ErrRideNotOffered = errors.New("ride is not offered anymore")
+
+// AcceptRideOffer assigns the ride to the driver if it is still waiting for one, so when several drivers
+// accept the same offer only the first one gets the ride. The ride is returned with the new status.
+func (svc *RideService) AcceptRideOffer(ctx context.Context, rideID, driverID int) (*model.Ride, error) {
+	ride, err := svc.GetRide(ctx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	ride.DriverID = &driverID
+	ride.Status = model.RideStatusDriverAccepted
+	if err := svc.newPickupPIN(ride); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET driver_id = $1, status = $2, pickup_pin_hash = $3, pickup_pin_attempts = $4
+		WHERE id = $5 AND status = $6 AND driver_id IS NULL;`, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(ctx, query, driverID, ride.Status, ride.PickupPINHash, ride.PickupPINAttempts, rideID, model.RideStatusPassengerAccepted)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		return nil, ErrRideNotOffered
+	}
+	// The PIN is kept once the driver got the ride, a driver losing the race does not replace it
+	if err := svc.savePickupPIN(ctx, ride); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(ride))
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return ride, tx.Commit(ctx)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// ErrPoolChanged is returned when a ride of the pool ended or changed driver while a new ride joined it
+var ErrPoolChanged = errors.New("pool changed while joining it")
+
+// ListActiveRides returns the rides with a driver that did not end, the pools are rebuilt from them
+func (svc *RideService) ListActiveRides(ctx context.Context) ([]model.Ride, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE driver_id IS NOT NULL AND status IN ($1, $2, $3) ORDER BY id;`, fields, svc.Table)
+	return svc.queryRides(ctx, query, model.RideStatusDriverAccepted, model.RideStatusPickingUp, model.RideStatusInTransit)
+}
+
+// JoinPool assigns a ride waiting for a driver to the driver of a pool. The pool ID and the split fares of the
+// ride and of the rides already in the pool are stored at once, every passenger gets an event with the new
+// fare. It returns ErrRideNotOffered when the ride got a driver meanwhile and ErrPoolChanged when a ride of the
+// pool ended or changed driver.
+func (svc *RideService) JoinPool(ctx context.Context, ride *model.Ride, pool []*model.Ride, driverID int) error {
+	if ride.PoolID == nil {
+		return fmt.Errorf("ride %d has no pool", ride.ID)
+	}
+	ride.DriverID = &driverID
+	ride.Status = model.RideStatusDriverAccepted
+	if err := svc.newPickupPIN(ride); err != nil {
+		return err
+	}
+
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		UPDATE %s SET driver_id = $1, status = $2, pool_id = $3, price = $4, pickup_pin_hash = $5, pickup_pin_attempts = $6
+		WHERE id = $7 AND status = $8 AND driver_id IS NULL;`, svc.Table)
+	res, err := tx.Exec(ctx, query, driverID, ride.Status, *ride.PoolID, ride.Price, ride.PickupPINHash, ride.PickupPINAttempts,
+		ride.ID, model.RideStatusPassengerAccepted)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		return ErrRideNotOffered
+	}
+
+	// The rides of the pool keep their status, only the pool and the fare change
+	query = fmt.Sprintf(`
+		UPDATE %s SET pool_id = $1, price = $2
+		WHERE id = $3 AND driver_id = $4 AND status IN ($5, $6, $7);`, svc.Table)
+	for _, pooled := range pool {
+		if pooled.ID == ride.ID {
+			continue
+		}
+		res, err := tx.Exec(ctx, query, *ride.PoolID, pooled.Price, pooled.ID, driverID,
+			model.RideStatusDriverAccepted, model.RideStatusPickingUp, model.RideStatusInTransit)
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if affected == 0 {
+			tx.Rollback(ctx)
+			return ErrPoolChanged
+		}
+		pooled.PoolID = ride.PoolID
+		err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(pooled))
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	if err := svc.savePickupPIN(ctx, ride); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(ride))
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+const (
+	// defaultSchedulerInterval is how often the scheduler looks for scheduled rides that are due
+	defaultSchedulerInterval = 30 * time.Second
+	// defaultScheduleLeadTime is how long before the pickup time a scheduled ride enters dispatch
+	defaultScheduleLeadTime = 10 * time.Minute
+)
+
+// ScheduleRide books a ride for its ScheduledAt pickup time. The ride is estimated and stored with
+// the scheduled status, the scheduler worker will push it into dispatch ScheduleLeadTime before pickup.
+func (svc *RideService) ScheduleRide(ctx context.Context, ride *model.Ride) error {
+	if !ride.IsScheduled(time.Now()) {
+		return fmt.Errorf("scheduled_at must be in the future")
+	}
+	ride.Status = model.RideStatusScheduled
+	if svc.Biller != nil {
+		if err := svc.Biller.EstimateRide(ride); err != nil {
+			return err
+		}
+	}
+	return svc.CreateRide(ctx, ride)
+}
+
+// ListScheduledRides returns the future bookings of a passenger ordered by pickup time
+func (svc *RideService) ListScheduledRides(ctx context.Context, passengerID int) ([]model.Ride, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE status = $1 AND passenger_id = $2 ORDER BY scheduled_at;`, fields, svc.Table)
+	return svc.queryRides(ctx, query, model.RideStatusScheduled, passengerID)
+}
+
+// CancelScheduledRide cancels a future booking, rides that already entered dispatch have to be
+// cancelled through the regular flow
+func (svc *RideService) CancelScheduledRide(ctx context.Context, id int) error {
+	ride, err := svc.GetRide(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ride.Status != model.RideStatusScheduled {
+		return ErrInvalidRideStatus
+	}
+	return svc.CancelRide(ctx, ride)
+}
+
+// schedulerWorker polls Postgres for scheduled rides that are due. Polling instead of in-memory timers
+// means bookings survive restarts and any instance can pick them up.
+func (svc *RideService) schedulerWorker(ctx context.Context) {
+	ticker := time.NewTicker(svc.SchedulerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := svc.dispatchDueScheduledRides(ctx, now)
+			if err != nil {
+				log.Printf("Error dispatching scheduled rides: %v\n", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Dispatched %d scheduled rides\n", n)
+			}
+		}
+	}
+}
+
+// dispatchDueScheduledRides pushes into the AcceptRide flow every scheduled ride whose pickup time is
+// within ScheduleLeadTime of now. It returns the number of rides dispatched by this instance.
+func (svc *RideService) dispatchDueScheduledRides(ctx context.Context, now time.Time) (int, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.Ride{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE status = $1 AND scheduled_at <= $2 ORDER BY scheduled_at;`, fields, svc.Table)
+	rides, err := svc.queryRides(ctx, query, model.RideStatusScheduled, now.Add(svc.ScheduleLeadTime))
+	if err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	for i := range rides {
+		ok, err := svc.dispatchScheduledRide(ctx, &rides[i])
+		if err != nil {
+			log.Printf("Error dispatching scheduled ride %d: %v\n", rides[i].ID, err)
+			continue
+		}
+		if ok {
+			dispatched++
+		}
+	}
+	return dispatched, nil
+}
+
+// dispatchScheduledRide moves a ride from scheduled to passenger_accepted, the same status AcceptRide
+// sets, so the outbox notification sends it to the drivers. The update is conditional on the ride
+// still being scheduled so two instances polling at the same time don't dispatch it twice.
+func (svc *RideService) dispatchScheduledRide(ctx context.Context, ride *model.Ride) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET status = $1 WHERE id = $2 AND status = $3;`, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(ctx, query, model.RideStatusPassengerAccepted, ride.ID, model.RideStatusScheduled)
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback(ctx)
+		return false, err
+	}
+
+	ride.Status = model.RideStatusPassengerAccepted
+	err = svc.insertOutbox(ctx, tx, model.NewRideOutbox(ride))
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// queryRides runs a select query over the rides table and scans every row
+func (svc *RideService) queryRides(ctx context.Context, query string, args ...interface{}) ([]model.Ride, error) {
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rides := make([]model.Ride, 0)
+	for rows.Next() {
+		ride := model.Ride{}
+		err = ride.Scan(rows)
+		if err != nil {
+			tx.Rollback(ctx)
+			return nil, err
+		}
+		rides = append(rides, ride)
+	}
+	tx.Commit(ctx)
+
+	return rides, nil
+}
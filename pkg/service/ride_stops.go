@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/repository"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+var (
+	// ErrStopNotFound is returned when the ride has no stop with the given sequence number
+	ErrStopNotFound = errors.New("stop not found")
+	// ErrStopOutOfOrder is returned when a stop is reached before leaving the previous ones, left before
+	// reaching it, or marked twice
+	ErrStopOutOfOrder = errors.New("stop out of order")
+)
+
+// StopArrived records that the driver reached the intermediate stop seq of a ride and emits a
+// stop_arrived event through the outbox, the previous stops must have been left
+func (svc *RideService) StopArrived(ctx context.Context, rideID, seq int) error {
+	condition := fmt.Sprintf(`arrived_at IS NULL AND NOT EXISTS (
+		SELECT 1 FROM %s WHERE ride_id = $2 AND seq < $3 AND departed_at IS NULL)`, svc.stopsTable)
+	return svc.markStop(ctx, rideID, seq, "arrived_at", condition, model.RideEventStopArrived)
+}
+
+// StopDeparted records that the driver left the intermediate stop seq of a ride and emits a
+// stop_departed event through the outbox, the stop must have been reached
+func (svc *RideService) StopDeparted(ctx context.Context, rideID, seq int) error {
+	condition := `arrived_at IS NOT NULL AND departed_at IS NULL`
+	return svc.markStop(ctx, rideID, seq, "departed_at", condition, model.RideEventStopDeparted)
+}
+
+// markStop sets the timestamp column of a stop matching the condition, while the ride is in transit, and
+// inserts the outbox event in the same transaction. It returns ErrInvalidRideStatus when the ride is not in
+// transit, ErrStopNotFound and ErrStopOutOfOrder when the stop does not exist or does not match the condition.
+func (svc *RideService) markStop(ctx context.Context, rideID, seq int, column, condition string, event model.RideStatus) error {
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s = $1
+		WHERE ride_id = $2 AND seq = $3 AND %s
+		AND EXISTS (SELECT 1 FROM %s WHERE id = $2 AND status = $4);`, svc.stopsTable, column, condition, svc.Table)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, query, time.Now(), rideID, seq, model.RideStatusInTransit)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback(ctx)
+		return svc.stopNotMarked(ctx, rideID, seq)
+	}
+
+	outbox := &model.RideOutbox{RideID: rideID, Status: event, StopSeq: seq}
+	err = svc.insertOutbox(ctx, tx, outbox)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// stopNotMarked tells why markStop did not change the stop
+func (svc *RideService) stopNotMarked(ctx context.Context, rideID, seq int) error {
+	ride, err := svc.GetRide(ctx, rideID)
+	if err != nil {
+		return err
+	}
+	if ride.Status != model.RideStatusInTransit {
+		return ErrInvalidRideStatus
+	}
+	for _, stop := range ride.Stops {
+		if stop.Seq == seq {
+			return ErrStopOutOfOrder
+		}
+	}
+	return fmt.Errorf("%w: stop %d of ride %d", ErrStopNotFound, seq, rideID)
+}
+
+// insertRideStops stores the stops of a newly created ride, they are numbered by their order in the ride
+// which is the order they are billed in, the sequence numbers sent by the client are ignored
+func (svc *RideService) insertRideStops(ctx context.Context, tx repository.Transaction, ride *model.Ride) error {
+	for i := range ride.Stops {
+		stop := &ride.Stops[i]
+		stop.RideID = ride.ID
+		stop.Seq = i + 1
+		fields, placeholder, args, _ := util.BuildSQLInsertQuery(stop, 1)
+		query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING id;`, svc.stopsTable, fields, placeholder)
+		err := tx.QueryRow(ctx, query, args...).Scan(&stop.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRideStops returns the stops of a ride ordered by sequence
+func (svc *RideService) getRideStops(ctx context.Context, tx repository.Transaction, rideID int) ([]model.RideStop, error) {
+	fields, _, _, _ := util.BuildSQLSelectQuery(&model.RideStop{}, 1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE ride_id = $1 ORDER BY seq;`, fields, svc.stopsTable)
+	rows, err := tx.Query(ctx, query, rideID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stops []model.RideStop
+	for rows.Next() {
+		stop := model.RideStop{}
+		if err := stop.Scan(rows); err != nil {
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	return stops, rows.Err()
+}
+
+// insertOutbox inserts an outbox event and notifies the listeners within the given transaction
+func (svc *RideService) insertOutbox(ctx context.Context, tx repository.Transaction, outbox *model.RideOutbox) error {
+	fields, placeholder, args, _ := util.BuildSQLInsertQuery(outbox, 1)
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING id;`, svc.outboxTable, fields, placeholder)
+	err := tx.QueryRow(ctx, query, args...).Scan(&outbox.ID)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`NOTIFY %s, '%d';`, svc.notifyChannel, outbox.ID)
+	_, err = tx.Exec(ctx, query)
+	return err
+}
@@ -179,3 +179,168 @@ func TestDeleteRide(t *testing.T) {
 	_, err = db.GetRide(context.Background(), ride.ID)
 	require.Error(t, err)
 }
+
+func TestStartRide(t *testing.T) {
+	opts := getTestOpts("rides6")
+	opts.RequirePickupPIN = true
+	opts.PickupPINs = NewRedisPickupPINStore("localhost:6379", time.Hour)
+	opts.MaxPickupPINAttempts = 2
+	db, err := NewRideService(context.Background(), opts)
+	require.NoError(t, err)
+	defer DeleteRideDB(db)()
+
+	driverID := 7
+	ride := &model.Ride{
+		PassengerID: 1,
+		DriverID:    &driverID,
+		Price:       100,
+		Status:      model.RideStatusPassengerAccepted,
+	}
+
+	err = db.CreateRide(context.Background(), ride)
+	require.NoError(t, err)
+
+	// The ride can't be started before the driver arrives
+	err = db.StartRide(context.Background(), ride, "")
+	require.ErrorIs(t, err, ErrInvalidRideStatus)
+
+	err = db.DriverAccept(context.Background(), ride)
+	require.NoError(t, err)
+	require.Len(t, ride.PickupPIN, pickupPINDigits)
+	pin := ride.PickupPIN
+
+	err = db.DriverArrived(context.Background(), ride)
+	require.NoError(t, err)
+
+	stored, err := db.GetRide(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.Empty(t, stored.PickupPIN)
+	require.NotEqual(t, pin, stored.PickupPINHash)
+
+	// The passenger reads the clear PIN from the store until the ride starts
+	storedPIN, err := opts.PickupPINs.PickupPIN(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.Equal(t, pin, storedPIN)
+
+	wrongPIN := "0000"
+	if pin == wrongPIN {
+		wrongPIN = "1111"
+	}
+	err = db.StartRide(context.Background(), stored, wrongPIN)
+	require.ErrorIs(t, err, ErrInvalidPickupPIN)
+	require.Equal(t, model.RideStatusPickingUp, stored.Status)
+
+	err = db.StartRide(context.Background(), stored, pin)
+	require.NoError(t, err)
+
+	started, err := db.GetRide(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RideStatusInTransit, started.Status)
+	require.Equal(t, 1, started.PickupPINAttempts)
+
+	storedPIN, err = opts.PickupPINs.PickupPIN(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.Empty(t, storedPIN)
+}
+
+func TestScheduledRides(t *testing.T) {
+	opts := getTestOpts("rides7")
+	opts.ScheduleLeadTime = 10 * time.Minute
+	db, err := NewRideService(context.Background(), opts)
+	require.NoError(t, err)
+	defer DeleteRideDB(db)()
+
+	now := time.Now()
+	soon := now.Add(5 * time.Minute)
+	later := now.Add(2 * time.Hour)
+
+	dueRide := &model.Ride{PassengerID: 1, ScheduledAt: &soon}
+	err = db.ScheduleRide(context.Background(), dueRide)
+	require.NoError(t, err)
+	require.Equal(t, model.RideStatusScheduled, dueRide.Status)
+
+	laterRide := &model.Ride{PassengerID: 1, ScheduledAt: &later}
+	err = db.ScheduleRide(context.Background(), laterRide)
+	require.NoError(t, err)
+
+	past := now.Add(-time.Minute)
+	err = db.ScheduleRide(context.Background(), &model.Ride{PassengerID: 1, ScheduledAt: &past})
+	require.Error(t, err)
+
+	scheduled, err := db.ListScheduledRides(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, scheduled, 2)
+	require.Equal(t, dueRide.ID, scheduled[0].ID)
+
+	// Only the ride inside the lead time is dispatched, and only once
+	n, err := db.dispatchDueScheduledRides(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = db.dispatchDueScheduledRides(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	dispatched, err := db.GetRide(context.Background(), dueRide.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RideStatusPassengerAccepted, dispatched.Status)
+
+	err = db.CancelScheduledRide(context.Background(), dueRide.ID)
+	require.ErrorIs(t, err, ErrInvalidRideStatus)
+
+	err = db.CancelScheduledRide(context.Background(), laterRide.ID)
+	require.NoError(t, err)
+	scheduled, err = db.ListScheduledRides(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, scheduled, 0)
+}
+
+func TestRideStops(t *testing.T) {
+	db, err := NewRideService(context.Background(), getTestOpts("rides8"))
+	require.NoError(t, err)
+	defer DeleteRideDB(db)()
+
+	ride := &model.Ride{
+		PassengerID: 1,
+		Status:      model.RideStatusPending,
+		// The sequence numbers sent are ignored, the stops are numbered by their order
+		Stops: []model.RideStop{
+			{Seq: 2, Lat: 40.71, Lon: -74.0},
+			{Lat: 40.72, Lon: -74.01},
+		},
+	}
+	err = db.CreateRide(context.Background(), ride)
+	require.NoError(t, err)
+
+	stored, err := db.GetRide(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Stops, 2)
+	require.Equal(t, 1, stored.Stops[0].Seq)
+	require.Equal(t, 2, stored.Stops[1].Seq)
+	require.Nil(t, stored.Stops[0].ArrivedAt)
+
+	// The stops are only marked while the passenger is on board
+	err = db.StopArrived(context.Background(), ride.ID, 1)
+	require.ErrorIs(t, err, ErrInvalidRideStatus)
+	stored.Status = model.RideStatusInTransit
+	err = db.UpdateRide(context.Background(), stored)
+	require.NoError(t, err)
+
+	err = db.StopDeparted(context.Background(), ride.ID, 1)
+	require.ErrorIs(t, err, ErrStopOutOfOrder)
+	err = db.StopArrived(context.Background(), ride.ID, 1)
+	require.NoError(t, err)
+	err = db.StopArrived(context.Background(), ride.ID, 1)
+	require.ErrorIs(t, err, ErrStopOutOfOrder)
+	err = db.StopArrived(context.Background(), ride.ID, 2)
+	require.ErrorIs(t, err, ErrStopOutOfOrder)
+	err = db.StopDeparted(context.Background(), ride.ID, 1)
+	require.NoError(t, err)
+	err = db.StopArrived(context.Background(), ride.ID, 3)
+	require.ErrorIs(t, err, ErrStopNotFound)
+
+	stored, err = db.GetRide(context.Background(), ride.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.Stops[0].ArrivedAt)
+	require.NotNil(t, stored.Stops[0].DepartedAt)
+	require.Nil(t, stored.Stops[1].ArrivedAt)
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/jackc/pgx/v4"
+)
+
+// TrailCruder stores the trails of the drivers flushed from the location service and queries them
+type TrailCruder interface {
+	SaveTrailPoints(ctx context.Context, points []model.TrailPoint) error
+	GetRideTrail(ctx context.Context, rideID int) ([]model.TrailPoint, error)
+	GetDriverTrail(ctx context.Context, driverID int, from, to time.Time) ([]model.TrailPoint, error)
+}
+
+func (db *DriverDatabase) trailsTable() string {
+	return db.Table + "_trails"
+}
+
+// createTrailsTable creates the trails table. There is no foreign key to the drivers, the trail of a removed
+// driver is kept for the disputes of its rides.
+func (db *DriverDatabase) createTrailsTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+    CREATE TABLE IF NOT EXISTS %s (
+        driver_id INTEGER NOT NULL,
+        stream_id VARCHAR(32) NOT NULL,
+        ride_id INTEGER,
+        latitude DOUBLE PRECISION NOT NULL,
+        longitude DOUBLE PRECISION NOT NULL,
+        accuracy DOUBLE PRECISION NOT NULL DEFAULT 0,
+        recorded_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (driver_id, stream_id)
+    );
+    CREATE INDEX IF NOT EXISTS %s_ride_idx ON %s (ride_id, recorded_at) WHERE ride_id IS NOT NULL;
+    CREATE INDEX IF NOT EXISTS %s_driver_time_idx ON %s (driver_id, recorded_at);`,
+		db.trailsTable(), db.trailsTable(), db.trailsTable(), db.trailsTable(), db.trailsTable())
+	_, err := db.Pool.Exec(ctx, query)
+	return err
+}
+
+// SaveTrailPoints stores the points in a single transaction, the points already stored are skipped so a flush
+// can be retried
+func (db *DriverDatabase) SaveTrailPoints(ctx context.Context, points []model.TrailPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			(driver_id, stream_id, ride_id, latitude, longitude, accuracy, recorded_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (driver_id, stream_id) DO NOTHING`, db.trailsTable())
+
+	batch := &pgx.Batch{}
+	for _, p := range points {
+		batch.Queue(query, p.DriverID, p.StreamID, p.RideID, p.Latitude, p.Longitude, p.Accuracy, p.RecordedAt)
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetRideTrail returns the trail of the ride in time order
+func (db *DriverDatabase) GetRideTrail(ctx context.Context, rideID int) ([]model.TrailPoint, error) {
+	query := fmt.Sprintf(`
+		SELECT driver_id, stream_id, ride_id, latitude, longitude, accuracy, recorded_at
+		FROM %s
+		WHERE ride_id = $1
+		ORDER BY recorded_at`, db.trailsTable())
+	return db.queryTrail(ctx, query, rideID)
+}
+
+// GetDriverTrail returns the trail of the driver between from, included, and to, excluded, in time order
+func (db *DriverDatabase) GetDriverTrail(ctx context.Context, driverID int, from, to time.Time) ([]model.TrailPoint, error) {
+	query := fmt.Sprintf(`
+		SELECT driver_id, stream_id, ride_id, latitude, longitude, accuracy, recorded_at
+		FROM %s
+		WHERE driver_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		ORDER BY recorded_at`, db.trailsTable())
+	return db.queryTrail(ctx, query, driverID, from, to)
+}
+
+func (db *DriverDatabase) queryTrail(ctx context.Context, query string, args ...interface{}) ([]model.TrailPoint, error) {
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []model.TrailPoint
+	for rows.Next() {
+		var p model.TrailPoint
+		err := rows.Scan(&p.DriverID, &p.StreamID, &p.RideID, &p.Latitude, &p.Longitude, &p.Accuracy, &p.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
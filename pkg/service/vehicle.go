@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// VehicleCruder manages the vehicles linked to the drivers, for now a driver has a single vehicle
+type VehicleCruder interface {
+	SaveDriverVehicle(ctx context.Context, vehicle *model.Vehicle) error
+	GetDriverVehicle(ctx context.Context, driverID int) (*model.Vehicle, error)
+	DeleteDriverVehicle(ctx context.Context, driverID int) error
+}
+
+func (db *DriverDatabase) vehiclesTable() string {
+	return db.Table + "_vehicles"
+}
+
+func (db *DriverDatabase) createVehiclesTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+    CREATE TABLE IF NOT EXISTS %s (
+        id SERIAL PRIMARY KEY,
+        driver_id INTEGER UNIQUE REFERENCES %s(id) ON DELETE CASCADE,
+        make VARCHAR(100),
+        plate VARCHAR(20),
+        class VARCHAR(20),
+        seats INTEGER,
+        accessibility TEXT[]
+    );`, db.vehiclesTable(), db.Table)
+	_, err := db.Pool.Exec(ctx, query)
+	return err
+}
+
+// SaveDriverVehicle creates or replaces the vehicle of the driver
+func (db *DriverDatabase) SaveDriverVehicle(ctx context.Context, vehicle *model.Vehicle) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			(driver_id, make, plate, class, seats, accessibility)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (driver_id) DO UPDATE SET
+			make = EXCLUDED.make, plate = EXCLUDED.plate, class = EXCLUDED.class,
+			seats = EXCLUDED.seats, accessibility = EXCLUDED.accessibility
+		RETURNING id`,
+		db.vehiclesTable())
+	if vehicle.Accessibility == nil {
+		vehicle.Accessibility = []string{}
+	}
+	err := db.Pool.QueryRow(
+		ctx,
+		query,
+		vehicle.DriverID,
+		vehicle.Make,
+		vehicle.Plate,
+		vehicle.Class,
+		vehicle.Seats,
+		vehicle.Accessibility,
+	).Scan(&vehicle.ID)
+	if err != nil {
+		return err
+	}
+	db.notifyDriverChange(ctx, model.DriverChangeEvent{
+		Kind:         model.DriverChangeVehicle,
+		DriverID:     vehicle.DriverID,
+		VehicleClass: vehicle.Class,
+	})
+	return nil
+}
+
+func (db *DriverDatabase) GetDriverVehicle(ctx context.Context, driverID int) (*model.Vehicle, error) {
+	query := fmt.Sprintf(`
+			SELECT
+				id, driver_id, make, plate, class, seats, accessibility
+			FROM
+				%s
+			WHERE driver_id = $1`,
+		db.vehiclesTable())
+	vehicle := &model.Vehicle{}
+	err := vehicle.Scan(db.Pool.QueryRow(ctx, query, driverID))
+	if err != nil {
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+func (db *DriverDatabase) DeleteDriverVehicle(ctx context.Context, driverID int) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE driver_id = $1`, db.vehiclesTable())
+	_, err := db.Pool.Exec(ctx, query, driverID)
+	if err != nil {
+		return err
+	}
+	db.notifyDriverChange(ctx, model.DriverChangeEvent{Kind: model.DriverChangeVehicle, DriverID: driverID})
+	return nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/zones"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrZoneNotFound is returned by GetZone when there is no zone with the ID, whatever the driver of the repository
+var ErrZoneNotFound = errors.New("zone not found")
+
+// ZoneCruder stores the zones of the service areas, see zones.Registry for their checks
+type ZoneCruder interface {
+	CreateZone(ctx context.Context, zone *model.Zone) error
+	ListZones(ctx context.Context, region string) ([]model.Zone, error)
+	// GetZone returns ErrZoneNotFound when there is no zone with the ID
+	GetZone(ctx context.Context, id int) (*model.Zone, error)
+	DeleteZone(ctx context.Context, id int) error
+}
+
+const zoneFields = `id, region, name, kind, area, pickup_points, surcharge, pickup_zone_id`
+
+// createZonesTable creates the zones table, the areas are GeoJSON documents so they are stored as JSONB
+func (svc *RideService) createZonesTable(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		region TEXT NOT NULL,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		area JSONB NOT NULL,
+		pickup_points JSONB,
+		surcharge FLOAT NOT NULL DEFAULT 0
+	);`, svc.zonesTable)
+	err := svc.Repository.CreateTable(ctx, query)
+	if err != nil {
+		return err
+	}
+	// The staging zones came later, the tables created before get the column
+	query = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS pickup_zone_id INTEGER NOT NULL DEFAULT 0;`, svc.zonesTable)
+	return svc.Repository.CreateTable(ctx, query)
+}
+
+// CreateZone validates and stores a zone, invalid zones return zones.ErrInvalidZone
+func (svc *RideService) CreateZone(ctx context.Context, zone *model.Zone) error {
+	if err := zones.Validate(zone); err != nil {
+		return err
+	}
+	pickupPoints, err := json.Marshal(zone.PickupPoints)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (region, name, kind, area, pickup_points, surcharge, pickup_zone_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`, svc.zonesTable)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, query, zone.Region, zone.Name, zone.Kind, string(zone.Area), string(pickupPoints), zone.Surcharge, zone.PickupZoneID).Scan(&zone.ID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListZones returns the zones of a region, all of them when the region is empty
+func (svc *RideService) ListZones(ctx context.Context, region string) ([]model.Zone, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE $1 = '' OR region = $1 ORDER BY id;`, zoneFields, svc.zonesTable)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, query, region)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []model.Zone
+	for rows.Next() {
+		zone := model.Zone{}
+		if err := zone.Scan(rows); err != nil {
+			tx.Rollback(ctx)
+			return nil, err
+		}
+		result = append(result, zone)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return result, tx.Commit(ctx)
+}
+
+func (svc *RideService) GetZone(ctx context.Context, id int) (*model.Zone, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1;`, zoneFields, svc.zonesTable)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zone := &model.Zone{}
+	err = zone.Scan(tx.QueryRow(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows) {
+		tx.Rollback(ctx)
+		return nil, ErrZoneNotFound
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	tx.Commit(ctx)
+	return zone, nil
+}
+
+func (svc *RideService) DeleteZone(ctx context.Context, id int) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1;`, svc.zonesTable)
+	tx, err := svc.Repository.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, query, id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,173 @@
+// Package trail cleans the GPS trails of the drivers. The raw fixes zigzag around the real path and a stopped
+// phone keeps wandering around, so summing the distance between them overestimates the ride. The fixes are
+// smoothed with a Kalman filter, the points a stopped driver jitters around are dropped, and optionally the
+// points are snapped to the road network.
+package trail
+
+import (
+	"math"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/roads"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// Snapper matches a point to the closest road, see roads.Graph
+type Snapper interface {
+	Snap(p model.Coordinate, maxDistanceKm float64) (roads.Snap, bool)
+}
+
+// Options tune the processing, the zero values take the defaults
+type Options struct {
+	// ProcessNoise is how fast in m/s² the speed is expected to change away from the prediction, 3 by default
+	ProcessNoise float64
+	// DefaultAccuracy is the accuracy in meters of the fixes without one, 10 by default
+	DefaultAccuracy float64
+	// StationaryRadius is the distance in meters a point must be from the last kept one to be kept, 15 by
+	// default
+	StationaryRadius float64
+	// Roads snaps the points to the roads when set, points further than MaxSnapDistanceKm from any road are
+	// left as they are. MaxSnapDistanceKm is 30m by default.
+	Roads             Snapper
+	MaxSnapDistanceKm float64
+}
+
+func (o *Options) defaults() {
+	if o.ProcessNoise <= 0 {
+		o.ProcessNoise = 3
+	}
+	if o.DefaultAccuracy <= 0 {
+		o.DefaultAccuracy = 10
+	}
+	if o.StationaryRadius <= 0 {
+		o.StationaryRadius = 15
+	}
+	if o.MaxSnapDistanceKm <= 0 {
+		o.MaxSnapDistanceKm = 0.03
+	}
+}
+
+// Path is a cleaned trail, ready to be drawn and billed. Polyline holds the points in the Google encoded
+// polyline format the maps draw.
+type Path struct {
+	Points     []model.Coordinate `json:"points"`
+	Polyline   string             `json:"polyline"`
+	DistanceKm float64            `json:"distance_km"`
+}
+
+// Process cleans a trail, the points must be in time order
+func Process(points []model.TrailPoint, opts Options) Path {
+	opts.defaults()
+	filter := newKalmanFilter(opts.ProcessNoise)
+
+	var path Path
+	var last *model.Coordinate
+	for _, p := range points {
+		accuracy := p.Accuracy
+		if accuracy <= 0 {
+			accuracy = opts.DefaultAccuracy
+		}
+		lat, lon := filter.update(p.Latitude, p.Longitude, accuracy, p.RecordedAt)
+		point := model.Coordinate{Lat: lat, Lon: lon}
+
+		if last != nil && util.CalculateDistance(last.Lat, last.Lon, point.Lat, point.Lon)*1000 < opts.StationaryRadius {
+			continue
+		}
+		last = &point
+
+		if opts.Roads != nil {
+			if snap, ok := opts.Roads.Snap(point, opts.MaxSnapDistanceKm); ok {
+				point = snap.Point
+			}
+		}
+		if n := len(path.Points); n > 0 && path.Points[n-1] == point {
+			continue
+		}
+		path.Points = append(path.Points, point)
+	}
+
+	for i := 1; i < len(path.Points); i++ {
+		a, b := path.Points[i-1], path.Points[i]
+		path.DistanceKm += util.CalculateDistance(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+	path.Polyline = util.EncodePolyline(path.Points)
+	return path
+}
+
+// earthRadiusM converts the offsets in radians to the meters the filter works in
+const earthRadiusM = 6371000.0
+
+// kalmanFilter smooths the positions with a constant velocity model in meters east and north of the first
+// fix, so a moving driver is followed without lagging behind. The velocity may change by processNoise m/s²,
+// which grows the uncertainty with the time since the last fix, and every fix weighs by its accuracy.
+type kalmanFilter struct {
+	processNoise float64
+	// origin is the first fix, cosLat scales the longitudes at its latitude
+	originLat, originLon float64
+	cosLat               float64
+	east, north          kalmanAxis
+	last                 time.Time
+	started              bool
+}
+
+// kalmanAxis is the position in m and the velocity in m/s along an axis with their covariance
+type kalmanAxis struct {
+	pos, vel   float64
+	pp, pv, vv float64
+}
+
+func newKalmanFilter(processNoise float64) *kalmanFilter {
+	return &kalmanFilter{processNoise: processNoise}
+}
+
+// update feeds a fix with its accuracy in meters and returns the estimated position
+func (k *kalmanFilter) update(lat, lon, accuracy float64, at time.Time) (float64, float64) {
+	variance := accuracy * accuracy
+	if !k.started {
+		k.originLat, k.originLon = lat, lon
+		k.cosLat = math.Cos(lat * math.Pi / 180)
+		// The speed is unknown until the next fixes, up to a fast car
+		k.east = kalmanAxis{pp: variance, vv: 30 * 30}
+		k.north = kalmanAxis{pp: variance, vv: 30 * 30}
+		k.last, k.started = at, true
+		return lat, lon
+	}
+
+	elapsed := at.Sub(k.last).Seconds()
+	if elapsed > 0 {
+		k.east.predict(elapsed, k.processNoise)
+		k.north.predict(elapsed, k.processNoise)
+		k.last = at
+	}
+	east := (lon - k.originLon) * math.Pi / 180 * earthRadiusM * k.cosLat
+	north := (lat - k.originLat) * math.Pi / 180 * earthRadiusM
+	k.east.correct(east, variance)
+	k.north.correct(north, variance)
+
+	return k.originLat + k.north.pos/earthRadiusM*180/math.Pi,
+		k.originLon + k.east.pos/(earthRadiusM*k.cosLat)*180/math.Pi
+}
+
+// predict moves the axis by its velocity for dt seconds, a random acceleration of noise m/s² adds to the
+// covariance
+func (a *kalmanAxis) predict(dt, noise float64) {
+	a.pos += a.vel * dt
+	q := noise * noise
+	a.pp += dt*(2*a.pv+dt*a.vv) + q*dt*dt*dt*dt/4
+	a.pv += dt*a.vv + q*dt*dt*dt/2
+	a.vv += q * dt * dt
+}
+
+// correct weighs the measured position with the prediction by their variances
+func (a *kalmanAxis) correct(measured, variance float64) {
+	s := a.pp + variance
+	gainPos, gainVel := a.pp/s, a.pv/s
+	residual := measured - a.pos
+	a.pos += gainPos * residual
+	a.vel += gainVel * residual
+	pp, pv, vv := a.pp, a.pv, a.vv
+	a.pp = (1 - gainPos) * pp
+	a.pv = (1 - gainPos) * pv
+	a.vv = vv - gainVel*pv
+}
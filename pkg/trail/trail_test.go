@@ -0,0 +1,100 @@
+package trail
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/billing"
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/roads"
+	"github.com/OscarMoya/Glubber/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noisyTrail drives east at 10m/s for the given seconds, a fix per second with up to noise meters of error in
+// every direction
+func noisyTrail(seconds int, speed, noise float64) []model.TrailPoint {
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	baseLat, baseLon := 40.4, -3.7
+
+	points := make([]model.TrailPoint, 0, seconds)
+	for i := 0; i < seconds; i++ {
+		lat, lon := util.AddKM(baseLat, baseLon, speed*float64(i)/1000, 90)
+		lat, lon = util.AddKM(lat, lon, noise*rnd.Float64()/1000, rnd.Float64()*360)
+		points = append(points, model.TrailPoint{Latitude: lat, Longitude: lon, Accuracy: noise, RecordedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+	return points
+}
+
+func rawDistance(points []model.TrailPoint) float64 {
+	route := make([]model.Coordinate, len(points))
+	for i, p := range points {
+		route[i] = model.Coordinate{Lat: p.Latitude, Lon: p.Longitude}
+	}
+	return billing.RouteDistance(route)
+}
+
+func TestShouldSmoothNoisyTrail(t *testing.T) {
+	points := noisyTrail(300, 10, 15)
+	path := Process(points, Options{})
+
+	// 3km driven, the raw fixes add the noise to it
+	assert.Greater(t, rawDistance(points), 4.0)
+	assert.InDelta(t, 3.0, path.DistanceKm, 0.2)
+	assert.Less(t, len(path.Points), len(points))
+}
+
+func TestShouldDropStationaryJitter(t *testing.T) {
+	points := noisyTrail(600, 0, 10)
+	path := Process(points, Options{})
+
+	assert.Greater(t, rawDistance(points), 4.0)
+	assert.Less(t, path.DistanceKm, 0.05)
+	assert.Empty(t, Process(nil, Options{}).Points)
+}
+
+func TestShouldSnapToRoads(t *testing.T) {
+	// A straight road going east under the trail
+	g := roads.NewGraph()
+	west := g.AddNode(roads.Node{ID: 1, Lat: 40.4, Lon: -3.71})
+	east := g.AddNode(roads.Node{ID: 2, Lat: 40.4, Lon: -3.64})
+	g.AddRoad(west, east, "primary", false)
+
+	path := Process(noisyTrail(300, 10, 15), Options{Roads: g})
+	require.NotEmpty(t, path.Points)
+	for _, p := range path.Points {
+		assert.InDelta(t, 40.4, p.Lat, 1e-6)
+	}
+	assert.InDelta(t, 3.0, path.DistanceKm, 0.1)
+}
+
+func TestShouldFollowCurvedTrail(t *testing.T) {
+	// A roundabout of 150m of radius at 10m/s, a fix per second with up to 10m of error
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	centerLat, centerLon := 40.4, -3.7
+	const radius, speed, noise = 150.0, 10.0, 10.0
+
+	opts := Options{}
+	opts.defaults()
+	filter := newKalmanFilter(opts.ProcessNoise)
+	errorSum, samples := 0.0, 0
+	for i := 0; i < 180; i++ {
+		bearing := float64(i) * speed / radius * 180 / math.Pi
+		lat, lon := util.AddKM(centerLat, centerLon, radius/1000, bearing)
+		fixLat, fixLon := util.AddKM(lat, lon, noise*rnd.Float64()/1000, rnd.Float64()*360)
+
+		gotLat, gotLon := filter.update(fixLat, fixLon, noise, start.Add(time.Duration(i)*time.Second))
+		// The first fixes find the speed
+		if i >= 10 {
+			errorSum += util.CalculateDistance(lat, lon, gotLat, gotLon) * 1000
+			samples++
+		}
+	}
+	// The estimate keeps up with the driver instead of cutting the curve behind it
+	assert.Less(t, errorSum/float64(samples), noise/2)
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"math"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
 )
 
 // This is the formula to calculate the new latitude and longitude given a distance in kilometers and a bearing
@@ -60,6 +62,86 @@ func CalculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return distance
 }
 
+// Bearing returns the initial bearing in degrees from the first point to the second one, clockwise from the
+// north between 0 and 360
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1Rad, lat2Rad := degreesToRadians(lat1), degreesToRadians(lat2)
+	dLon := degreesToRadians(lon2 - lon1)
+
+	y := math.Sin(dLon) * math.Cos(lat2Rad)
+	x := math.Cos(lat1Rad)*math.Sin(lat2Rad) - math.Sin(lat1Rad)*math.Cos(lat2Rad)*math.Cos(dLon)
+	return math.Mod(radiansToDegrees(math.Atan2(y, x))+360, 360)
+}
+
+// BBox is an area between two latitudes and two longitudes in degrees. A box crossing the antimeridian has
+// MinLon greater than MaxLon.
+type BBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Contains returns true when the point is inside the box
+func (b BBox) Contains(lat, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// BoundingBox returns the smallest box holding the circle of radiusKm around the point, the box covers every
+// longitude when the circle reaches a pole
+func BoundingBox(lat, lon, radiusKm float64) BBox {
+	dLat := radiansToDegrees(radiusKm / earthRadiusKm)
+	box := BBox{MinLat: lat - dLat, MaxLat: lat + dLat, MinLon: -180, MaxLon: 180}
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		box.MinLat, box.MaxLat = math.Max(box.MinLat, -90), math.Min(box.MaxLat, 90)
+		return box
+	}
+
+	// The widest point of the circle is north of its center in the northern hemisphere and south of it in the
+	// southern one
+	dLon := radiansToDegrees(math.Asin(math.Sin(radiusKm/earthRadiusKm) / math.Cos(degreesToRadians(lat))))
+	if dLon >= 180 {
+		return box
+	}
+	box.MinLon, box.MaxLon = normalizeLongitude(lon-dLon), normalizeLongitude(lon+dLon)
+	return box
+}
+
+// normalizeLongitude wraps a longitude in degrees to [-180, 180)
+func normalizeLongitude(lon float64) float64 {
+	return math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+}
+
+// ProjectToSegment returns the closest point of the segment ab to p and how far along the segment it is, from
+// 0 to 1. The segment is projected on a plane around p, accurate enough for segments of a few kilometers.
+func ProjectToSegment(p, a, b model.Coordinate) (model.Coordinate, float64) {
+	scale := math.Cos(degreesToRadians(p.Lat))
+	ax, ay := (a.Lon-p.Lon)*scale, a.Lat-p.Lat
+	bx, by := (b.Lon-p.Lon)*scale, b.Lat-p.Lat
+	dx, dy := bx-ax, by-ay
+
+	fraction := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		fraction = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return model.Coordinate{
+		Lat: a.Lat + (b.Lat-a.Lat)*fraction,
+		Lon: a.Lon + (b.Lon-a.Lon)*fraction,
+	}, fraction
+}
+
+// DistanceToSegment returns the distance in kilometers from p to the closest point of the segment ab
+func DistanceToSegment(p, a, b model.Coordinate) float64 {
+	closest, _ := ProjectToSegment(p, a, b)
+	return CalculateDistance(p.Lat, p.Lon, closest.Lat, closest.Lon)
+}
+
 // Convert from degrees to radians
 func degreesToRadians(degrees float64) float64 {
 	return degrees * math.Pi / 180
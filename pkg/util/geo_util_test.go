@@ -0,0 +1,86 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldCalculateBearing(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		expected               float64
+	}{
+		{"north", 0, 0, 1, 0, 0},
+		{"east", 0, 0, 0, 1, 90},
+		{"south", 1, 0, 0, 0, 180},
+		{"west", 0, 1, 0, 0, 270},
+		{"north east of madrid", 40.4, -3.7, 40.5, -3.6, 37.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, Bearing(tt.lat1, tt.lon1, tt.lat2, tt.lon2), 0.1)
+		})
+	}
+
+	// The bearing of AddKM is found back
+	lat, lon := AddKM(40.4, -3.7, 5, 135)
+	assert.InDelta(t, 135, Bearing(40.4, -3.7, lat, lon), 0.1)
+}
+
+func TestShouldBuildBoundingBox(t *testing.T) {
+	box := BoundingBox(40.4, -3.7, 10)
+	for bearing := 0.0; bearing < 360; bearing += 15 {
+		lat, lon := AddKM(40.4, -3.7, 9.99, bearing)
+		assert.True(t, box.Contains(lat, lon), "bearing %v", bearing)
+		lat, lon = AddKM(40.4, -3.7, 10.5, bearing)
+		if bearing == 0 || bearing == 90 || bearing == 180 || bearing == 270 {
+			assert.False(t, box.Contains(lat, lon), "bearing %v", bearing)
+		}
+	}
+
+	// Around the antimeridian the box wraps
+	box = BoundingBox(0, 179.95, 20)
+	assert.Greater(t, box.MinLon, box.MaxLon)
+	assert.True(t, box.Contains(0, -179.95))
+	assert.True(t, box.Contains(0, 179.9))
+	assert.False(t, box.Contains(0, 0))
+
+	// Close to a pole the box covers every longitude
+	box = BoundingBox(89.95, 0, 20)
+	assert.Equal(t, 90.0, box.MaxLat)
+	assert.True(t, box.Contains(89.99, 180))
+}
+
+func TestShouldMeasureDistanceToSegment(t *testing.T) {
+	a := model.Coordinate{Lat: 40.4, Lon: -3.71}
+	b := model.Coordinate{Lat: 40.4, Lon: -3.69}
+	north := func(p model.Coordinate, km float64) model.Coordinate {
+		lat, lon := AddKM(p.Lat, p.Lon, km, 0)
+		return model.Coordinate{Lat: lat, Lon: lon}
+	}
+
+	tests := []struct {
+		name             string
+		p                model.Coordinate
+		expectedKm       float64
+		expectedFraction float64
+	}{
+		{"above the middle", north(model.Coordinate{Lat: 40.4, Lon: -3.7}, 1), 1, 0.5},
+		{"on the segment", model.Coordinate{Lat: 40.4, Lon: -3.705}, 0, 0.25},
+		{"before the start", model.Coordinate{Lat: 40.4, Lon: -3.72}, CalculateDistance(40.4, -3.72, a.Lat, a.Lon), 0},
+		{"after the end", north(b, 2), 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expectedKm, DistanceToSegment(tt.p, a, b), 0.005)
+			_, fraction := ProjectToSegment(tt.p, a, b)
+			assert.InDelta(t, tt.expectedFraction, fraction, 0.01)
+		})
+	}
+
+	// A segment of a single point
+	assert.InDelta(t, 1, DistanceToSegment(north(a, 1), a, a), 0.005)
+}
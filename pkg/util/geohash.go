@@ -0,0 +1,120 @@
+package util
+
+import (
+	"errors"
+	"strings"
+)
+
+// geohashAlphabet is the base32 alphabet of the geohashes, without a, i, l and o
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// MaxGeohashPrecision is the longest geohash, about 3cm, longer ones go beyond the float64 precision
+const MaxGeohashPrecision = 12
+
+// ErrInvalidGeohash is returned when a geohash is empty, too long or has characters out of the alphabet
+var ErrInvalidGeohash = errors.New("invalid geohash")
+
+// EncodeGeohash returns the geohash of the given number of characters of the cell holding the point, the
+// precision is bounded between 1 and MaxGeohashPrecision
+func EncodeGeohash(lat, lon float64, precision int) string {
+	if precision < 1 {
+		precision = 1
+	}
+	if precision > MaxGeohashPrecision {
+		precision = MaxGeohashPrecision
+	}
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bits, char, even := 0, 0, true
+	for hash.Len() < precision {
+		// The bits alternate between longitude and latitude, starting with longitude
+		value, rng := lon, &lonRange
+		if !even {
+			value, rng = lat, &latRange
+		}
+		mid := (rng[0] + rng[1]) / 2
+		char <<= 1
+		if value >= mid {
+			char |= 1
+			rng[0] = mid
+		} else {
+			rng[1] = mid
+		}
+		even = !even
+		bits++
+		if bits == 5 {
+			hash.WriteByte(geohashAlphabet[char])
+			bits, char = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// GeohashBounds returns the cell of a geohash
+func GeohashBounds(hash string) (BBox, error) {
+	if len(hash) == 0 || len(hash) > MaxGeohashPrecision {
+		return BBox{}, ErrInvalidGeohash
+	}
+	box := BBox{MinLat: -90, MaxLat: 90, MinLon: -180, MaxLon: 180}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		char := strings.IndexByte(geohashAlphabet, hash[i])
+		if char < 0 {
+			return BBox{}, ErrInvalidGeohash
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := char>>bit&1 == 1
+			if even {
+				mid := (box.MinLon + box.MaxLon) / 2
+				if set {
+					box.MinLon = mid
+				} else {
+					box.MaxLon = mid
+				}
+			} else {
+				mid := (box.MinLat + box.MaxLat) / 2
+				if set {
+					box.MinLat = mid
+				} else {
+					box.MaxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return box, nil
+}
+
+// DecodeGeohash returns the center of the cell of a geohash
+func DecodeGeohash(hash string) (float64, float64, error) {
+	box, err := GeohashBounds(hash)
+	if err != nil {
+		return 0, 0, err
+	}
+	return (box.MinLat + box.MaxLat) / 2, (box.MinLon + box.MaxLon) / 2, nil
+}
+
+// GeohashNeighbours returns the cells of the same precision around a geohash, clockwise from the north. The
+// cells east of the antimeridian wrap around and the ones beyond the poles are left out.
+func GeohashNeighbours(hash string) ([]string, error) {
+	box, err := GeohashBounds(hash)
+	if err != nil {
+		return nil, err
+	}
+	lat, lon := (box.MinLat+box.MaxLat)/2, (box.MinLon+box.MaxLon)/2
+	height, width := box.MaxLat-box.MinLat, box.MaxLon-box.MinLon
+
+	// North, north east, east, south east, south, south west, west and north west
+	steps := [][2]float64{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	neighbours := make([]string, 0, len(steps))
+	for _, step := range steps {
+		neighbourLat := lat + step[0]*height
+		if neighbourLat > 90 || neighbourLat < -90 {
+			continue
+		}
+		neighbourLon := normalizeLongitude(lon + step[1]*width)
+		neighbours = append(neighbours, EncodeGeohash(neighbourLat, neighbourLon, len(hash)))
+	}
+	return neighbours, nil
+}
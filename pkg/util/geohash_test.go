@@ -0,0 +1,65 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldEncodeGeohash(t *testing.T) {
+	tests := []struct {
+		name      string
+		lat, lon  float64
+		precision int
+		expected  string
+	}{
+		{"wikipedia example", 57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{"madrid", 40.4168, -3.7038, 6, "ezjmgt"},
+		{"too short", 40.4168, -3.7038, 0, "e"},
+		{"too long", 40.4168, -3.7038, 20, EncodeGeohash(40.4168, -3.7038, MaxGeohashPrecision)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, EncodeGeohash(tt.lat, tt.lon, tt.precision))
+		})
+	}
+}
+
+func TestShouldDecodeGeohash(t *testing.T) {
+	lat, lon, err := DecodeGeohash("ezs42")
+	require.NoError(t, err)
+	assert.InDelta(t, 42.605, lat, 0.001)
+	assert.InDelta(t, -5.603, lon, 0.001)
+
+	// The point is in the cell of its geohash
+	box, err := GeohashBounds(EncodeGeohash(40.4168, -3.7038, 7))
+	require.NoError(t, err)
+	assert.True(t, box.Contains(40.4168, -3.7038))
+	assert.InDelta(t, 0.00137, box.MaxLat-box.MinLat, 0.00001)
+
+	for _, hash := range []string{"", "ezs4a", "0123456789bcd"} {
+		_, _, err := DecodeGeohash(hash)
+		assert.ErrorIs(t, err, ErrInvalidGeohash, hash)
+	}
+}
+
+func TestShouldFindGeohashNeighbours(t *testing.T) {
+	neighbours, err := GeohashNeighbours("gbsuv")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gbsvj", "gbsvn", "gbsuy", "gbsuw", "gbsut", "gbsus", "gbsuu", "gbsvh"}, neighbours)
+
+	// East of the antimeridian is the far west
+	east := EncodeGeohash(0.1, 179.99, 4)
+	neighbours, err = GeohashNeighbours(east)
+	require.NoError(t, err)
+	assert.Contains(t, neighbours, EncodeGeohash(0.1, -179.99, 4))
+
+	// There is nothing north of the pole
+	neighbours, err = GeohashNeighbours(EncodeGeohash(89.99, 0, 4))
+	require.NoError(t, err)
+	assert.Len(t, neighbours, 5)
+
+	_, err = GeohashNeighbours("a")
+	assert.ErrorIs(t, err, ErrInvalidGeohash)
+}
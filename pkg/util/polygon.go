@@ -0,0 +1,151 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// ErrInvalidGeoJSON is returned when a GeoJSON document is not a valid polygon
+var ErrInvalidGeoJSON = errors.New("invalid GeoJSON polygon")
+
+// Polygon is an outer ring followed by the rings of its holes, every ring is closed: its last point repeats
+// the first one
+type Polygon [][]model.Coordinate
+
+// Contains returns true when the point is inside the outer ring and out of every hole, the points on an
+// edge may be in or out. The rings are treated as flat, fine for areas the size of a city.
+func (p Polygon) Contains(pt model.Coordinate) bool {
+	if len(p) == 0 || !ringContains(p[0], pt) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, pt) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains casts a ray east of the point and counts the edges it crosses
+func ringContains(ring []model.Coordinate, pt model.Coordinate) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > pt.Lat) != (b.Lat > pt.Lat) &&
+			pt.Lon < (b.Lon-a.Lon)*(pt.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// MultiPolygon is a set of polygons, a point is in it when it is in any of them
+type MultiPolygon []Polygon
+
+// Contains returns true when the point is inside any of the polygons
+func (m MultiPolygon) Contains(pt model.Coordinate) bool {
+	for _, p := range m {
+		if p.Contains(pt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the box holding every polygon
+func (m MultiPolygon) Bounds() BBox {
+	box := BBox{MinLat: math.Inf(1), MinLon: math.Inf(1), MaxLat: math.Inf(-1), MaxLon: math.Inf(-1)}
+	for _, p := range m {
+		if len(p) == 0 {
+			continue
+		}
+		for _, c := range p[0] {
+			box.MinLat, box.MaxLat = math.Min(box.MinLat, c.Lat), math.Max(box.MaxLat, c.Lat)
+			box.MinLon, box.MaxLon = math.Min(box.MinLon, c.Lon), math.Max(box.MaxLon, c.Lon)
+		}
+	}
+	return box
+}
+
+// geoJSON holds the members of the GeoJSON objects that can hold a polygon
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+}
+
+// ParseGeoJSONPolygon parses a GeoJSON Polygon or MultiPolygon, or a Feature holding one of them. GeoJSON
+// positions are longitude first.
+func ParseGeoJSONPolygon(data []byte) (MultiPolygon, error) {
+	var doc geoJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+	}
+	if doc.Type == "Feature" {
+		if doc.Geometry == nil {
+			return nil, fmt.Errorf("%w: feature without geometry", ErrInvalidGeoJSON)
+		}
+		doc = *doc.Geometry
+	}
+
+	switch doc.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(doc.Coordinates, &rings); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		polygon, err := polygonOf(rings)
+		if err != nil {
+			return nil, err
+		}
+		return MultiPolygon{polygon}, nil
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(doc.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		if len(polygons) == 0 {
+			return nil, fmt.Errorf("%w: no polygons", ErrInvalidGeoJSON)
+		}
+		result := make(MultiPolygon, 0, len(polygons))
+		for _, rings := range polygons {
+			polygon, err := polygonOf(rings)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, polygon)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidGeoJSON, doc.Type)
+}
+
+// polygonOf checks the rings of a GeoJSON polygon: at least an outer ring, every ring closed with four
+// positions or more
+func polygonOf(rings [][][]float64) (Polygon, error) {
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("%w: polygon without rings", ErrInvalidGeoJSON)
+	}
+	polygon := make(Polygon, 0, len(rings))
+	for _, positions := range rings {
+		if len(positions) < 4 {
+			return nil, fmt.Errorf("%w: ring with less than 4 positions", ErrInvalidGeoJSON)
+		}
+		ring := make([]model.Coordinate, len(positions))
+		for i, pos := range positions {
+			if len(pos) < 2 || pos[1] < -90 || pos[1] > 90 || pos[0] < -180 || pos[0] > 180 {
+				return nil, fmt.Errorf("%w: invalid position %v", ErrInvalidGeoJSON, pos)
+			}
+			ring[i] = model.Coordinate{Lat: pos[1], Lon: pos[0]}
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return nil, fmt.Errorf("%w: ring not closed", ErrInvalidGeoJSON)
+		}
+		polygon = append(polygon, ring)
+	}
+	return polygon, nil
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// squareWithHole is a square around Madrid with a hole in its center, as a GeoJSON feature
+const squareWithHole = `{
+  "type": "Feature",
+  "properties": {"name": "center"},
+  "geometry": {
+    "type": "Polygon",
+    "coordinates": [
+      [[-3.8, 40.3], [-3.6, 40.3], [-3.6, 40.5], [-3.8, 40.5], [-3.8, 40.3]],
+      [[-3.72, 40.38], [-3.68, 40.38], [-3.68, 40.42], [-3.72, 40.42], [-3.72, 40.38]]
+    ]
+  }
+}`
+
+func TestShouldContainPointsInPolygon(t *testing.T) {
+	zone, err := ParseGeoJSONPolygon([]byte(squareWithHole))
+	require.NoError(t, err)
+	require.Len(t, zone, 1)
+
+	tests := []struct {
+		name     string
+		point    model.Coordinate
+		expected bool
+	}{
+		{"inside", model.Coordinate{Lat: 40.35, Lon: -3.75}, true},
+		{"in the hole", model.Coordinate{Lat: 40.40, Lon: -3.70}, false},
+		{"north of it", model.Coordinate{Lat: 40.55, Lon: -3.70}, false},
+		{"east of it", model.Coordinate{Lat: 40.40, Lon: -3.50}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, zone.Contains(tt.point))
+		})
+	}
+	assert.Equal(t, BBox{MinLat: 40.3, MinLon: -3.8, MaxLat: 40.5, MaxLon: -3.6}, zone.Bounds())
+}
+
+func TestShouldContainPointsInMultiPolygon(t *testing.T) {
+	// A triangle and a square far from it
+	zone, err := ParseGeoJSONPolygon([]byte(`{
+      "type": "MultiPolygon",
+      "coordinates": [
+        [[[0, 0], [4, 0], [0, 4], [0, 0]]],
+        [[[10, 10], [11, 10], [11, 11], [10, 11], [10, 10]]]
+      ]
+    }`))
+	require.NoError(t, err)
+	require.Len(t, zone, 2)
+
+	assert.True(t, zone.Contains(model.Coordinate{Lat: 1, Lon: 1}))
+	assert.False(t, zone.Contains(model.Coordinate{Lat: 3, Lon: 3}), "beyond the diagonal of the triangle")
+	assert.True(t, zone.Contains(model.Coordinate{Lat: 10.5, Lon: 10.5}))
+	assert.False(t, zone.Contains(model.Coordinate{Lat: 5, Lon: 5}))
+}
+
+func TestShouldRejectInvalidGeoJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not json", `{`},
+		{"point", `{"type": "Point", "coordinates": [0, 0]}`},
+		{"feature without geometry", `{"type": "Feature"}`},
+		{"no rings", `{"type": "Polygon", "coordinates": []}`},
+		{"short ring", `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [0, 0]]]}`},
+		{"open ring", `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}`},
+		{"latitude out of range", `{"type": "Polygon", "coordinates": [[[0, 0], [0, 95], [1, 1], [0, 0]]]}`},
+		{"empty multipolygon", `{"type": "MultiPolygon", "coordinates": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGeoJSONPolygon([]byte(tt.doc))
+			assert.ErrorIs(t, err, ErrInvalidGeoJSON)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// polylineFactor is the precision of the Google encoded polylines, 5 decimals
+const polylineFactor = 1e5
+
+// ErrInvalidPolyline is returned when an encoded polyline is truncated or has characters out of its alphabet
+var ErrInvalidPolyline = errors.New("invalid encoded polyline")
+
+// EncodePolyline encodes the points in the Google encoded polyline format, every coordinate is the difference
+// with the previous point rounded to 5 decimals
+func EncodePolyline(points []model.Coordinate) string {
+	var out strings.Builder
+	var prevLat, prevLon int64
+	for _, p := range points {
+		lat, lon := int64(math.Round(p.Lat*polylineFactor)), int64(math.Round(p.Lon*polylineFactor))
+		encodePolylineValue(&out, lat-prevLat)
+		encodePolylineValue(&out, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return out.String()
+}
+
+// encodePolylineValue writes the value zigzag encoded in chunks of 5 bits, least significant first, every
+// chunk but the last one flagged with 0x20 and offset by 63 to be printable
+func encodePolylineValue(out *strings.Builder, value int64) {
+	v := uint64(value << 1)
+	if value < 0 {
+		v = ^v
+	}
+	for v >= 0x20 {
+		out.WriteByte(byte(0x20|(v&0x1f)) + 63)
+		v >>= 5
+	}
+	out.WriteByte(byte(v) + 63)
+}
+
+// DecodePolyline decodes a polyline in the Google encoded polyline format
+func DecodePolyline(encoded string) ([]model.Coordinate, error) {
+	var points []model.Coordinate
+	var lat, lon int64
+	for i := 0; i < len(encoded); {
+		dLat, n, err := decodePolylineValue(encoded[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		dLon, n, err := decodePolylineValue(encoded[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		lat, lon = lat+dLat, lon+dLon
+		points = append(points, model.Coordinate{Lat: float64(lat) / polylineFactor, Lon: float64(lon) / polylineFactor})
+	}
+	return points, nil
+}
+
+// decodePolylineValue returns the first value of the encoded string and the number of bytes it takes
+func decodePolylineValue(encoded string) (int64, int, error) {
+	var v uint64
+	for i, shift := 0, uint(0); i < len(encoded) && shift < 64; i, shift = i+1, shift+5 {
+		c := encoded[i]
+		if c < 63 || c > 63+0x3f {
+			return 0, 0, ErrInvalidPolyline
+		}
+		chunk := uint64(c - 63)
+		v |= (chunk & 0x1f) << shift
+		if chunk&0x20 == 0 {
+			value := int64(v >> 1)
+			if v&1 == 1 {
+				value = ^value
+			}
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, ErrInvalidPolyline
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldEncodePolyline(t *testing.T) {
+	// The example of the Google documentation
+	points := []model.Coordinate{{Lat: 38.5, Lon: -120.2}, {Lat: 40.7, Lon: -120.95}, {Lat: 43.252, Lon: -126.453}}
+	encoded := "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+
+	assert.Equal(t, encoded, EncodePolyline(points))
+	decoded, err := DecodePolyline(encoded)
+	require.NoError(t, err)
+	require.Len(t, decoded, len(points))
+	for i := range points {
+		assert.InDelta(t, points[i].Lat, decoded[i].Lat, 1e-9)
+		assert.InDelta(t, points[i].Lon, decoded[i].Lon, 1e-9)
+	}
+
+	assert.Equal(t, "", EncodePolyline(nil))
+	decoded, err = DecodePolyline("")
+	require.NoError(t, err)
+	assert.Empty(t, decoded)
+}
+
+func TestShouldRoundTripPolyline(t *testing.T) {
+	var points []model.Coordinate
+	lat, lon := 40.4168, -3.7038
+	for i := 0; i < 50; i++ {
+		lat, lon = AddKM(lat, lon, 0.137*float64(i), float64(i*37%360))
+		points = append(points, model.Coordinate{Lat: lat, Lon: lon})
+	}
+
+	decoded, err := DecodePolyline(EncodePolyline(points))
+	require.NoError(t, err)
+	require.Len(t, decoded, len(points))
+	for i := range points {
+		assert.InDelta(t, points[i].Lat, decoded[i].Lat, 0.5e-5)
+		assert.InDelta(t, points[i].Lon, decoded[i].Lon, 0.5e-5)
+	}
+}
+
+func TestShouldRejectInvalidPolyline(t *testing.T) {
+	// Truncated in the middle of a value, a latitude without longitude and a character out of the alphabet
+	for _, encoded := range []string{"_p~iF~ps|", "_p~iF", "_p~iF~ps|U " + "\x01"} {
+		_, err := DecodePolyline(encoded)
+		assert.ErrorIs(t, err, ErrInvalidPolyline, encoded)
+	}
+}
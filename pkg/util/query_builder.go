@@ -113,10 +113,50 @@ func BuildSQLCreateTableQuery(tableName string, dataStruct interface{}) (string,
 			continue
 		}
 
-		// Determine the SQL type based on the Go type
-		var sqlType string
-		c := field.Type.Kind()
-		switch c {
+		sqlType, err := sqlColumnType(field)
+		if err != nil {
+			return "", err
+		}
+		if dbTag == "id" {
+			sqlType = "SERIAL PRIMARY KEY"
+		}
+
+		columns = append(columns, fmt.Sprintf("%s %s", dbTag, sqlType))
+	}
+
+	columnsStr := strings.Join(columns, ", ")
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", tableName, columnsStr)
+
+	return createTableQuery, nil
+}
+
+// sqlColumnType returns the SQL type of the column of a struct field based on its Go type
+func sqlColumnType(field reflect.StructField) (string, error) {
+	var sqlType string
+	c := field.Type.Kind()
+	switch c {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sqlType = "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		sqlType = "FLOAT"
+	case reflect.String:
+		sqlType = "TEXT"
+	case reflect.Bool:
+		sqlType = "BOOLEAN"
+	case reflect.Struct:
+		switch field.Type.String() {
+		case "sql.NullInt64":
+			sqlType = "INTEGER NULL"
+		case "time.Time":
+			sqlType = "TIMESTAMPTZ"
+		case "sql.NullTime":
+			sqlType = "TIMESTAMPTZ NULL"
+		default:
+			return "", fmt.Errorf("unsupported field type: %s", field.Type.String())
+		}
+	case reflect.Ptr:
+		// Handle pointers by determining the underlying type
+		switch field.Type.Elem().Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			sqlType = "INTEGER"
 		case reflect.Float32, reflect.Float64:
@@ -126,38 +166,55 @@ func BuildSQLCreateTableQuery(tableName string, dataStruct interface{}) (string,
 		case reflect.Bool:
 			sqlType = "BOOLEAN"
 		case reflect.Struct:
-			switch field.Type.String() {
-			case "sql.NullInt64":
-				sqlType = "INTEGER NULL"
-			default:
-				return "", fmt.Errorf("unsupported field type: %s", field.Type.String())
-			}
-		case reflect.Ptr:
-			// Handle pointers by determining the underlying type
-			switch field.Type.Elem().Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				sqlType = "INTEGER"
-			case reflect.Float32, reflect.Float64:
-				sqlType = "FLOAT"
-			case reflect.String:
-				sqlType = "TEXT"
-			case reflect.Bool:
-				sqlType = "BOOLEAN"
-			default:
+			if field.Type.Elem().String() != "time.Time" {
 				return "", fmt.Errorf("unsupported field type: %s", field.Type.String())
 			}
+			sqlType = "TIMESTAMPTZ NULL"
 		default:
 			return "", fmt.Errorf("unsupported field type: %s", field.Type.String())
 		}
-		if dbTag == "id" {
-			sqlType = "SERIAL PRIMARY KEY"
-		}
+	default:
+		return "", fmt.Errorf("unsupported field type: %s", field.Type.String())
+	}
+	return sqlType, nil
+}
 
-		columns = append(columns, fmt.Sprintf("%s %s", dbTag, sqlType))
+// BuildSQLAddColumnsQuery builds the query adding the columns of a struct that implements db tags to a
+// table created before they existed. The columns that are not pointers get their zero value as default,
+// so the rows already in the table can be scanned into the struct.
+func BuildSQLAddColumnsQuery(tableName string, dataStruct interface{}) (string, error) {
+	t := reflect.TypeOf(dataStruct)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("BuildSQLAddColumnsQuery expects a struct or a pointer to a struct")
 	}
 
-	columnsStr := strings.Join(columns, ", ")
-	createTableQuery := fmt.Sprintf("CREATE TABLE %s (%s);", tableName, columnsStr)
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		dbTag := field.Tag.Get("db")
+		if dbTag == "" || dbTag == "id" {
+			continue
+		}
+		sqlType, err := sqlColumnType(field)
+		if err != nil {
+			return "", err
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+			sqlType += " NOT NULL DEFAULT 0"
+		case reflect.String:
+			sqlType += " NOT NULL DEFAULT ''"
+		case reflect.Bool:
+			sqlType += " NOT NULL DEFAULT FALSE"
+		}
+		columns = append(columns, fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s", dbTag, sqlType))
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("BuildSQLAddColumnsQuery found no columns in %s", t.Name())
+	}
 
-	return createTableQuery, nil
+	return fmt.Sprintf("ALTER TABLE %s %s;", tableName, strings.Join(columns, ", ")), nil
 }
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type addColumnsRow struct {
+	ID       int        `db:"id"`
+	Name     string     `db:"name"`
+	Attempts int        `db:"attempts"`
+	Shared   bool       `db:"shared"`
+	GroupID  *int       `db:"group_id"`
+	At       *time.Time `db:"at"`
+	Note     string
+}
+
+func TestShouldBuildSQLAddColumnsQuery(t *testing.T) {
+	query, err := BuildSQLAddColumnsQuery("rows", addColumnsRow{})
+	require.NoError(t, err)
+	assert.Equal(t, "ALTER TABLE rows "+
+		"ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '', "+
+		"ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0, "+
+		"ADD COLUMN IF NOT EXISTS shared BOOLEAN NOT NULL DEFAULT FALSE, "+
+		"ADD COLUMN IF NOT EXISTS group_id INTEGER, "+
+		"ADD COLUMN IF NOT EXISTS at TIMESTAMPTZ NULL;", query)
+
+	_, err = BuildSQLAddColumnsQuery("rows", 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package zones
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+)
+
+// Source lists the stored zones, all of them when the region is empty, see service.ZoneCruder
+type Source interface {
+	ListZones(ctx context.Context, region string) ([]model.Zone, error)
+}
+
+// Registry holds the index of the stored zones and reloads it, the zones are edited rarely so they are
+// checked in memory. Until the first load there are no zones and no ride is valid.
+type Registry struct {
+	source Source
+	index  atomic.Pointer[Index]
+	// loaded is set by the first Reload
+	loaded atomic.Bool
+}
+
+// NewRegistry creates a registry of the zones of the source, Reload or Run load them
+func NewRegistry(source Source) *Registry {
+	r := &Registry{source: source}
+	r.index.Store(&Index{})
+	return r
+}
+
+// Reload replaces the index with the zones stored now, the index is kept when they can not be loaded. Loading
+// no served zone is logged, until one is created every ride is rejected.
+func (r *Registry) Reload(ctx context.Context) error {
+	zones, err := r.source.ListZones(ctx, "")
+	if err != nil {
+		return err
+	}
+	index, err := NewIndex(zones)
+	if err != nil {
+		return err
+	}
+	previous := r.index.Swap(index)
+	first := !r.loaded.Swap(true)
+	if !index.serves() && (first || previous.serves()) {
+		log.Println("no served zones stored, every ride is out of the service area until one is created")
+	}
+	return nil
+}
+
+// Run reloads the zones every interval until the context is done
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(ctx); err != nil {
+				log.Println("reload zones:", err)
+			}
+		}
+	}
+}
+
+// Index returns the current index
+func (r *Registry) Index() *Index {
+	return r.index.Load()
+}
+
+// Lookup returns the zones holding the point
+func (r *Registry) Lookup(p model.Coordinate) []model.Zone {
+	return r.Index().Lookup(p)
+}
+
+// ValidateRide checks the ride against the current zones, see Index.ValidateRide
+func (r *Registry) ValidateRide(ride *model.Ride) error {
+	return r.Index().ValidateRide(ride)
+}
+
+// Staging returns the staging zone holding the point, see Index.Staging
+func (r *Registry) Staging(p model.Coordinate) (model.Zone, bool) {
+	return r.Index().Staging(p)
+}
+
+// StagingFor returns the staging zones serving the pickup, see Index.StagingFor
+func (r *Registry) StagingFor(pickup model.Coordinate) []model.Zone {
+	return r.Index().StagingFor(pickup)
+}
@@ -0,0 +1,211 @@
+// Package zones checks the rides against the service areas: the operational zones of every region where the
+// rides can start and end, and the restricted zones such as airports where the pickups are only allowed at
+// designated points. The zones are also looked up for the surcharges of the billing and for the staging zones
+// where the drivers wait in line for the pickups of a restricted zone.
+package zones
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/OscarMoya/Glubber/pkg/util"
+)
+
+// PickupPointRadiusKm is how far from a designated pickup point a pickup of a restricted zone can be
+const PickupPointRadiusKm = 0.05
+
+var (
+	// ErrInvalidZone is returned when a zone can not be stored
+	ErrInvalidZone = errors.New("invalid zone")
+	// ErrPickupOutOfServiceArea and ErrDropoffOutOfServiceArea are returned when the point is in no served zone
+	ErrPickupOutOfServiceArea  = errors.New("pickup out of the service area")
+	ErrDropoffOutOfServiceArea = errors.New("dropoff out of the service area")
+	// ErrStopOutOfServiceArea is returned when an intermediate stop of the ride is in no served zone
+	ErrStopOutOfServiceArea = errors.New("stop out of the service area")
+	// ErrRestrictedPickup is returned when the pickup is in a restricted zone away from its pickup points
+	ErrRestrictedPickup = errors.New("pickup not allowed here, use a designated pickup point")
+)
+
+// Validate checks that the zone can be stored: a known kind, a valid GeoJSON area, the pickup points of the
+// restricted zones inside them and the pickup zone of the staging zones
+func Validate(z *model.Zone) error {
+	if z.Region == "" || z.Name == "" {
+		return fmt.Errorf("%w: region and name are required", ErrInvalidZone)
+	}
+	if z.Surcharge < 0 {
+		return fmt.Errorf("%w: negative surcharge", ErrInvalidZone)
+	}
+	area, err := util.ParseGeoJSONPolygon(z.Area)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidZone, err)
+	}
+	switch z.Kind {
+	case model.ZoneKindServed:
+	case model.ZoneKindRestricted:
+		if len(z.PickupPoints) == 0 {
+			return fmt.Errorf("%w: restricted zone without pickup points", ErrInvalidZone)
+		}
+		for _, p := range z.PickupPoints {
+			if !area.Contains(p) {
+				return fmt.Errorf("%w: pickup point %v out of the zone", ErrInvalidZone, p)
+			}
+		}
+	case model.ZoneKindStaging:
+		if z.PickupZoneID <= 0 {
+			return fmt.Errorf("%w: staging zone without pickup zone", ErrInvalidZone)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidZone, z.Kind)
+	}
+	return nil
+}
+
+// zone is a zone with its parsed area
+type zone struct {
+	model.Zone
+	area   util.MultiPolygon
+	bounds util.BBox
+}
+
+func (z *zone) contains(p model.Coordinate) bool {
+	return z.bounds.Contains(p.Lat, p.Lon) && z.area.Contains(p)
+}
+
+// Index answers which zones hold a point, it is immutable so it can be shared
+type Index struct {
+	zones []zone
+}
+
+// NewIndex parses the areas of the zones, it fails on the first invalid one
+func NewIndex(zones []model.Zone) (*Index, error) {
+	ix := &Index{zones: make([]zone, 0, len(zones))}
+	for _, z := range zones {
+		if err := Validate(&z); err != nil {
+			return nil, fmt.Errorf("zone %d: %w", z.ID, err)
+		}
+		area, _ := util.ParseGeoJSONPolygon(z.Area)
+		ix.zones = append(ix.zones, zone{Zone: z, area: area, bounds: area.Bounds()})
+	}
+	return ix, nil
+}
+
+// Lookup returns the zones holding the point
+func (ix *Index) Lookup(p model.Coordinate) []model.Zone {
+	var result []model.Zone
+	for i := range ix.zones {
+		if ix.zones[i].contains(p) {
+			result = append(result, ix.zones[i].Zone)
+		}
+	}
+	return result
+}
+
+// Staging returns the staging zone holding the point, the first one stored when they overlap
+func (ix *Index) Staging(p model.Coordinate) (model.Zone, bool) {
+	for i := range ix.zones {
+		if ix.zones[i].Kind == model.ZoneKindStaging && ix.zones[i].contains(p) {
+			return ix.zones[i].Zone, true
+		}
+	}
+	return model.Zone{}, false
+}
+
+// StagingFor returns the staging zones whose pickup zone holds the pickup, the rides starting there are
+// offered to their queues
+func (ix *Index) StagingFor(pickup model.Coordinate) []model.Zone {
+	pickupZones := make(map[int]bool)
+	for _, z := range ix.Lookup(pickup) {
+		pickupZones[z.ID] = true
+	}
+	var result []model.Zone
+	for i := range ix.zones {
+		if ix.zones[i].Kind == model.ZoneKindStaging && pickupZones[ix.zones[i].PickupZoneID] {
+			result = append(result, ix.zones[i].Zone)
+		}
+	}
+	return result
+}
+
+// ValidateRide checks that the pickup, the intermediate stops and the dropoff of the ride are in served zones
+// and that the pickups and the stops in restricted zones are at one of their pickup points, since passengers
+// can board at any stop. The errors of the restricted zones carry the points.
+func (ix *Index) ValidateRide(ride *model.Ride) error {
+	pickup := model.Coordinate{Lat: ride.SrcLat, Lon: ride.SrcLon}
+	dropoff := model.Coordinate{Lat: ride.DstLat, Lon: ride.DstLon}
+
+	pickupZones := ix.Lookup(pickup)
+	if !hasKind(pickupZones, model.ZoneKindServed) {
+		return ErrPickupOutOfServiceArea
+	}
+	if !hasKind(ix.Lookup(dropoff), model.ZoneKindServed) {
+		return ErrDropoffOutOfServiceArea
+	}
+	if err := checkRestricted(pickup, pickupZones); err != nil {
+		return err
+	}
+	for _, stop := range ride.Stops {
+		point := model.Coordinate{Lat: stop.Lat, Lon: stop.Lon}
+		stopZones := ix.Lookup(point)
+		if !hasKind(stopZones, model.ZoneKindServed) {
+			return fmt.Errorf("%w: stop %d", ErrStopOutOfServiceArea, stop.Seq)
+		}
+		if err := checkRestricted(point, stopZones); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRestricted returns a RestrictedPickupError when the point is in a restricted zone away from its pickup
+// points
+func checkRestricted(p model.Coordinate, zones []model.Zone) error {
+	for _, z := range zones {
+		if z.Kind == model.ZoneKindRestricted && !nearAny(p, z.PickupPoints) {
+			return &RestrictedPickupError{Zone: z.Name, PickupPoints: z.PickupPoints}
+		}
+	}
+	return nil
+}
+
+// serves returns true when the index has a served zone, without one every ride is out of the service area
+func (ix *Index) serves() bool {
+	for i := range ix.zones {
+		if ix.zones[i].Kind == model.ZoneKindServed {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictedPickupError is the ErrRestrictedPickup of a zone with the points the pickup must be moved to
+type RestrictedPickupError struct {
+	Zone         string
+	PickupPoints []model.Coordinate
+}
+
+func (e *RestrictedPickupError) Error() string {
+	return fmt.Sprintf("%v in %s", ErrRestrictedPickup, e.Zone)
+}
+
+func (e *RestrictedPickupError) Unwrap() error {
+	return ErrRestrictedPickup
+}
+
+func hasKind(zones []model.Zone, kind model.ZoneKind) bool {
+	for _, z := range zones {
+		if z.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func nearAny(p model.Coordinate, points []model.Coordinate) bool {
+	closest := math.Inf(1)
+	for _, q := range points {
+		closest = math.Min(closest, util.CalculateDistance(p.Lat, p.Lon, q.Lat, q.Lon))
+	}
+	return closest <= PickupPointRadiusKm
+}
@@ -0,0 +1,174 @@
+package zones
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OscarMoya/Glubber/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// madrid is the served zone of the tests and barajas an airport in its north-east corner
+var (
+	madrid = model.Zone{
+		ID: 1, Region: "madrid", Name: "madrid", Kind: model.ZoneKindServed,
+		Area: json.RawMessage(`{"type": "Polygon", "coordinates": [[[-3.8, 40.3], [-3.5, 40.3], [-3.5, 40.5], [-3.8, 40.5], [-3.8, 40.3]]]}`),
+	}
+	barajas = model.Zone{
+		ID: 2, Region: "madrid", Name: "barajas", Kind: model.ZoneKindRestricted, Surcharge: 5,
+		Area:         json.RawMessage(`{"type": "Polygon", "coordinates": [[[-3.6, 40.45], [-3.5, 40.45], [-3.5, 40.5], [-3.6, 40.5], [-3.6, 40.45]]]}`),
+		PickupPoints: []model.Coordinate{{Lat: 40.47, Lon: -3.56}},
+	}
+	// taxiLot is where the drivers wait for the pickups of barajas
+	taxiLot = model.Zone{
+		ID: 3, Region: "madrid", Name: "taxi lot", Kind: model.ZoneKindStaging, PickupZoneID: 2,
+		Area: json.RawMessage(`{"type": "Polygon", "coordinates": [[[-3.58, 40.46], [-3.57, 40.46], [-3.57, 40.465], [-3.58, 40.465], [-3.58, 40.46]]]}`),
+	}
+)
+
+func TestShouldValidateZones(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(z *model.Zone)
+		valid  bool
+	}{
+		{"served", func(z *model.Zone) { *z = madrid }, true},
+		{"restricted", func(z *model.Zone) {}, true},
+		{"without region", func(z *model.Zone) { z.Region = "" }, false},
+		{"negative surcharge", func(z *model.Zone) { z.Surcharge = -1 }, false},
+		{"unknown kind", func(z *model.Zone) { z.Kind = "closed" }, false},
+		{"invalid area", func(z *model.Zone) { z.Area = json.RawMessage(`{"type": "Point", "coordinates": [0, 0]}`) }, false},
+		{"without pickup points", func(z *model.Zone) { z.PickupPoints = nil }, false},
+		{"pickup point outside", func(z *model.Zone) { z.PickupPoints = []model.Coordinate{{Lat: 40.4, Lon: -3.7}} }, false},
+		{"staging", func(z *model.Zone) { *z = taxiLot }, true},
+		{"staging without pickup zone", func(z *model.Zone) { *z = taxiLot; z.PickupZoneID = 0 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := barajas
+			tt.change(&zone)
+			err := Validate(&zone)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidZone)
+			}
+		})
+	}
+}
+
+func TestShouldLookupZones(t *testing.T) {
+	index, err := NewIndex([]model.Zone{madrid, barajas})
+	require.NoError(t, err)
+
+	assert.Empty(t, index.Lookup(model.Coordinate{Lat: 41.38, Lon: 2.17}))
+	assert.Equal(t, []model.Zone{madrid}, index.Lookup(model.Coordinate{Lat: 40.42, Lon: -3.70}))
+	assert.Equal(t, []model.Zone{madrid, barajas}, index.Lookup(model.Coordinate{Lat: 40.48, Lon: -3.55}))
+
+	_, err = NewIndex([]model.Zone{madrid, {ID: 3, Region: "madrid", Name: "broken"}})
+	assert.ErrorIs(t, err, ErrInvalidZone)
+}
+
+func TestShouldLookupStagingZones(t *testing.T) {
+	index, err := NewIndex([]model.Zone{madrid, barajas, taxiLot})
+	require.NoError(t, err)
+
+	zone, ok := index.Staging(model.Coordinate{Lat: 40.462, Lon: -3.575})
+	require.True(t, ok)
+	assert.Equal(t, taxiLot.ID, zone.ID)
+	_, ok = index.Staging(model.Coordinate{Lat: 40.48, Lon: -3.55})
+	assert.False(t, ok)
+
+	// Only the pickups of the airport are offered to the queue of the lot
+	assert.Equal(t, []model.Zone{taxiLot}, index.StagingFor(model.Coordinate{Lat: 40.47, Lon: -3.56}))
+	assert.Empty(t, index.StagingFor(model.Coordinate{Lat: 40.42, Lon: -3.70}))
+}
+
+func TestShouldValidateRides(t *testing.T) {
+	index, err := NewIndex([]model.Zone{madrid, barajas})
+	require.NoError(t, err)
+
+	center := model.Coordinate{Lat: 40.42, Lon: -3.70}
+	terminal := model.Coordinate{Lat: 40.48, Lon: -3.55}
+	pickupPoint := model.Coordinate{Lat: 40.4702, Lon: -3.5601}
+	barcelona := model.Coordinate{Lat: 41.38, Lon: 2.17}
+	ride := func(src, dst model.Coordinate) *model.Ride {
+		return &model.Ride{SrcLat: src.Lat, SrcLon: src.Lon, DstLat: dst.Lat, DstLon: dst.Lon}
+	}
+	withStop := func(r *model.Ride, stop model.Coordinate) *model.Ride {
+		r.Stops = []model.RideStop{{Seq: 1, Lat: stop.Lat, Lon: stop.Lon}}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		ride     *model.Ride
+		expected error
+	}{
+		{"in the city", ride(center, center), nil},
+		{"to the airport", ride(center, terminal), nil},
+		{"from a pickup point", ride(pickupPoint, center), nil},
+		{"from the terminal", ride(terminal, center), ErrRestrictedPickup},
+		{"from out of the area", ride(barcelona, center), ErrPickupOutOfServiceArea},
+		{"to out of the area", ride(center, barcelona), ErrDropoffOutOfServiceArea},
+		{"through a stop out of the area", withStop(ride(center, center), barcelona), ErrStopOutOfServiceArea},
+		{"through a stop at the terminal", withStop(ride(center, center), terminal), ErrRestrictedPickup},
+		{"through a pickup point", withStop(ride(center, center), pickupPoint), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := index.ValidateRide(tt.ride)
+			if tt.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expected)
+			}
+		})
+	}
+
+	var restricted *RestrictedPickupError
+	require.ErrorAs(t, index.ValidateRide(ride(terminal, center)), &restricted)
+	assert.Equal(t, "barajas", restricted.Zone)
+	assert.Equal(t, barajas.PickupPoints, restricted.PickupPoints)
+}
+
+// fakeSource returns its zones or its error
+type fakeSource struct {
+	zones []model.Zone
+	err   error
+}
+
+func (s *fakeSource) ListZones(ctx context.Context, region string) ([]model.Zone, error) {
+	return s.zones, s.err
+}
+
+func TestShouldReloadRegistry(t *testing.T) {
+	source := &fakeSource{}
+	registry := NewRegistry(source)
+	ride := &model.Ride{SrcLat: 40.42, SrcLon: -3.70, DstLat: 40.41, DstLon: -3.69}
+
+	// Without zones there is no service area
+	assert.ErrorIs(t, registry.ValidateRide(ride), ErrPickupOutOfServiceArea)
+
+	source.zones = []model.Zone{madrid}
+	require.NoError(t, registry.Reload(context.Background()))
+	assert.NoError(t, registry.ValidateRide(ride))
+
+	// A failed reload keeps the zones loaded before
+	source.err = errors.New("database down")
+	assert.Error(t, registry.Reload(context.Background()))
+	assert.NoError(t, registry.ValidateRide(ride))
+
+	source.err = nil
+	source.zones = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go registry.Run(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return registry.ValidateRide(ride) != nil
+	}, time.Second, 10*time.Millisecond)
+}